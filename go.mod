@@ -1,3 +1,5 @@
 module mymodule
 
 go 1.21.10
+
+require golang.org/x/image v0.24.0
@@ -0,0 +1,276 @@
+package imagetor
+
+import "math"
+
+// uniformFraction is the fraction of pixels that must lie within tolerance of
+// the mean color for IsMostlyUniform to consider an image uniform.
+const uniformFraction = 0.95
+
+// IsMostlyUniform reports whether an image consists mostly of a single color,
+// such as a blank scan or an all-white upload.
+//
+// The mean color of the image is computed, and the image is considered uniform
+// when at least 95% of its pixels differ from it by no more than tolerance in
+// every channel.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	tolerance: The largest per-channel difference from the mean color for a
+//	  pixel to count as matching it.
+//
+// Returns:
+//
+//	Whether the image is mostly uniform, and its mean RGBA color. An empty
+//	tensor is not uniform.
+func IsMostlyUniform(tensor [][][]float64, tolerance float64) (bool, [4]float64) {
+	var mean [4]float64
+	count := 0
+	for _, row := range tensor {
+		for _, pixel := range row {
+			for c := 0; c < channels; c++ {
+				mean[c] += pixel[c]
+			}
+			count++
+		}
+	}
+	if count == 0 {
+		return false, mean
+	}
+	for c := range mean {
+		mean[c] /= float64(count)
+	}
+
+	matching := 0
+	for _, row := range tensor {
+		for _, pixel := range row {
+			near := true
+			for c := 0; c < channels && near; c++ {
+				near = math.Abs(pixel[c]-mean[c]) <= tolerance
+			}
+			if near {
+				matching++
+			}
+		}
+	}
+	return float64(matching) >= uniformFraction*float64(count), mean
+}
+
+// commonRatios are the aspect ratios AspectRatio labels, in landscape and
+// portrait orientation.
+var commonRatios = []struct {
+	w, h  int
+	label string
+}{
+	{1, 1, "1:1"},
+	{5, 4, "5:4"}, {4, 5, "4:5"},
+	{4, 3, "4:3"}, {3, 4, "3:4"},
+	{3, 2, "3:2"}, {2, 3, "2:3"},
+	{16, 10, "16:10"}, {10, 16, "10:16"},
+	{16, 9, "16:9"}, {9, 16, "9:16"},
+	{21, 9, "21:9"}, {9, 21, "9:21"},
+}
+
+// gcd returns the greatest common divisor of two non-negative integers.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// AspectRatio returns the aspect ratio of an image as a reduced integer
+// fraction, along with a label for common ratios.
+//
+// Images within 1% of a common ratio are labeled with it even when their exact
+// ratio differs, so that a 1366x768 screen is labeled "16:9".
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//
+// Returns:
+//
+//	The width and height of the reduced ratio, and a label such as "16:9" or
+//	"4:3", or an empty label if the ratio is not a common one. An empty tensor
+//	returns 0, 0 and an empty label.
+func AspectRatio(tensor [][][]float64) (w, h int, label string) {
+	width, height, _ := Dimensions(tensor)
+	if width == 0 || height == 0 {
+		return 0, 0, ""
+	}
+	d := gcd(width, height)
+	w, h = width/d, height/d
+
+	ratio := float64(width) / float64(height)
+	for _, common := range commonRatios {
+		target := float64(common.w) / float64(common.h)
+		if math.Abs(ratio-target) <= 0.01*target {
+			return w, h, common.label
+		}
+	}
+	return w, h, ""
+}
+
+// quantizeColor rounds the channels of a pixel to 8 bits.
+func quantizeColor(pixel []float64) [4]uint8 {
+	var q [4]uint8
+	for c := 0; c < channels; c++ {
+		q[c] = uint8(math.Round(clamp(pixel[c]) * 255))
+	}
+	return q
+}
+
+// CountColors counts the distinct colors of an image, for choosing between a
+// palette and a truecolor encoding or detecting flat graphics.
+//
+// Colors are compared after rounding every channel, alpha included, to 8 bits.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//
+// Returns:
+//
+//	The number of distinct 8-bit RGBA colors.
+func CountColors(tensor [][][]float64) int {
+	seen := make(map[[4]uint8]struct{})
+	for _, row := range tensor {
+		for _, pixel := range row {
+			seen[quantizeColor(pixel)] = struct{}{}
+		}
+	}
+	return len(seen)
+}
+
+// UniqueColors lists the distinct colors of an image, compared as in
+// CountColors.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	limit: The largest number of colors to return. The scan stops once it is
+//	  reached, so a small limit keeps images with many colors cheap.
+//
+// Returns:
+//
+//	The distinct colors, rounded to 8 bits, in the order they first appear
+//	scanning row by row.
+func UniqueColors(tensor [][][]float64, limit int) [][4]float64 {
+	var colors [][4]float64
+	seen := make(map[[4]uint8]struct{})
+	for _, row := range tensor {
+		for _, pixel := range row {
+			if len(colors) >= limit {
+				return colors
+			}
+			q := quantizeColor(pixel)
+			if _, ok := seen[q]; ok {
+				continue
+			}
+			seen[q] = struct{}{}
+			colors = append(colors, [4]float64{float64(q[0]) / 255, float64(q[1]) / 255, float64(q[2]) / 255, float64(q[3]) / 255})
+		}
+	}
+	return colors
+}
+
+// heatColor maps a value in [0, 1] to the jet colormap, running from dark
+// blue through cyan, green and yellow to dark red.
+func heatColor(v float64) (r, g, b float64) {
+	return clamp(1.5 - math.Abs(4*v-3)), clamp(1.5 - math.Abs(4*v-2)), clamp(1.5 - math.Abs(4*v-1))
+}
+
+// EnergyHeatmap visualizes the gradient energy of an image as a false-color
+// heatmap, for inspecting the decisions of seam carving and smart cropping.
+//
+// The energy is the Sobel gradient magnitude of the luminance, normalized so
+// that the strongest edge of the image is red while flat areas are blue.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//
+// Returns:
+//
+//	An opaque 3D tensor of the same dimensions holding the heatmap, or an
+//	error if a worker panics.
+func EnergyHeatmap(tensor [][][]float64) ([][][]float64, error) {
+	width, height, _ := Dimensions(tensor)
+	if width == 0 || height == 0 {
+		return newTensor(0, 0), nil
+	}
+	energy, err := sobel(luminancePlane(tensor))
+	if err != nil {
+		return nil, err
+	}
+
+	peak := 0.0
+	for _, row := range energy {
+		for _, e := range row {
+			peak = math.Max(peak, e)
+		}
+	}
+
+	heatmap := newTensor(width, height)
+	for y, row := range energy {
+		for x, e := range row {
+			v := 0.0
+			if peak > 0 {
+				v = e / peak
+			}
+			pixel := heatmap[y][x]
+			pixel[0], pixel[1], pixel[2] = heatColor(v)
+			pixel[3] = 1
+		}
+	}
+	return heatmap, nil
+}
+
+// Colors ClippingOverlay marks clipped pixels with.
+var (
+	highlightWarning = [3]float64{1, 0, 0}
+	shadowWarning    = [3]float64{0, 0, 1}
+)
+
+// ClippingOverlay marks the blown highlights and crushed shadows of an image,
+// like the clipping warnings of a camera display, for checking exposure.
+//
+// A pixel is a blown highlight when any of its RGB channels reaches
+// highThreshold, and is painted red. A pixel is a crushed shadow when all of
+// its RGB channels are at or below lowThreshold, and is painted blue. All other
+// pixels are copied unchanged.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	highThreshold: The channel value from which highlights count as clipped,
+//	  such as 0.98.
+//	lowThreshold: The channel value up to which shadows count as clipped,
+//	  such as 0.02.
+//
+// Returns:
+//
+//	A marked copy of the image.
+func ClippingOverlay(tensor [][][]float64, highThreshold, lowThreshold float64) [][][]float64 {
+	result := cloneTensor(tensor)
+	for _, row := range result {
+		for _, pixel := range row {
+			brightest := math.Max(pixel[0], math.Max(pixel[1], pixel[2]))
+			var warning *[3]float64
+			switch {
+			case brightest >= highThreshold:
+				warning = &highlightWarning
+			case brightest <= lowThreshold:
+				warning = &shadowWarning
+			default:
+				continue
+			}
+			// Keep the color premultiplied by the alpha of the pixel.
+			for c := 0; c < 3; c++ {
+				pixel[c] = warning[c] * pixel[3]
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,217 @@
+package imagetor
+
+import "testing"
+
+// solidTensor returns a tensor of the given size filled with one color.
+func solidTensor(width, height int, color [4]float64) [][][]float64 {
+	tensor := newTensor(width, height)
+	for _, row := range tensor {
+		for _, p := range row {
+			copy(p, color[:])
+		}
+	}
+	return tensor
+}
+
+func TestIsMostlyUniform(t *testing.T) {
+	white := [4]float64{1, 1, 1, 1}
+
+	// A white scan with a few specks of dust, under 5% of the pixels.
+	specks := solidTensor(20, 20, white)
+	for i := 0; i < 15; i++ {
+		copy(specks[i][i], []float64{0, 0, 0, 1})
+	}
+
+	tests := []struct {
+		name      string
+		tensor    [][][]float64
+		tolerance float64
+		want      bool
+		wantMean  [4]float64
+	}{
+		{"solid white", solidTensor(16, 16, white), 0.01, true, white},
+		{"solid translucent gray", solidTensor(8, 8, [4]float64{0.25, 0.25, 0.25, 0.5}), 0, true, [4]float64{0.25, 0.25, 0.25, 0.5}},
+		{"white with specks", specks, 0.05, true, [4]float64{1 - 15.0/400, 1 - 15.0/400, 1 - 15.0/400, 1}},
+		{"detailed", stripesTensor(64, 48), 0.1, false, [4]float64{1.0 / 3, 1.0 / 3, 1.0 / 3, 1}},
+		{"detailed, huge tolerance", stripesTensor(64, 48), 1, true, [4]float64{1.0 / 3, 1.0 / 3, 1.0 / 3, 1}},
+		{"empty", nil, 1, false, [4]float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, mean := IsMostlyUniform(tt.tensor, tt.tolerance)
+			if got != tt.want {
+				t.Errorf("IsMostlyUniform = %v, want %v", got, tt.want)
+			}
+			for c := range mean {
+				if !near(mean[c], tt.wantMean[c], 1e-9) {
+					t.Errorf("mean color = %v, want %v", mean, tt.wantMean)
+					break
+				}
+			}
+		})
+	}
+}
+
+// sharedTensor returns a width x height tensor whose pixels all share one
+// black pixel slice, for tests that only look at the shape of large images.
+func sharedTensor(width, height int) [][][]float64 {
+	pixel := make([]float64, channels)
+	row := make([][]float64, width)
+	for x := range row {
+		row[x] = pixel
+	}
+	tensor := make([][][]float64, height)
+	for y := range tensor {
+		tensor[y] = row
+	}
+	return tensor
+}
+
+func TestAspectRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantW, wantH  int
+		wantLabel     string
+	}{
+		{"full HD", 1920, 1080, 16, 9, "16:9"},
+		{"portrait full HD", 1080, 1920, 9, 16, "9:16"},
+		{"VGA", 640, 480, 4, 3, "4:3"},
+		{"square", 512, 512, 1, 1, "1:1"},
+		{"close to 16:9", 1366, 768, 683, 384, "16:9"},
+		{"uncommon", 1000, 400, 5, 2, ""},
+		{"empty", 0, 0, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, label := AspectRatio(sharedTensor(tt.width, tt.height))
+			if w != tt.wantW || h != tt.wantH || label != tt.wantLabel {
+				t.Errorf("AspectRatio = %d, %d, %q, want %d, %d, %q", w, h, label, tt.wantW, tt.wantH, tt.wantLabel)
+			}
+		})
+	}
+}
+
+func TestCountColors(t *testing.T) {
+	black, white := [4]float64{0, 0, 0, 1}, [4]float64{1, 1, 1, 1}
+	checker := solidTensor(4, 4, white)
+	for y, row := range checker {
+		for x, p := range row {
+			if (x+y)%2 == 0 {
+				copy(p, black[:])
+			}
+		}
+	}
+	// Values closer together than an 8-bit step count as one color.
+	nearWhite := solidTensor(2, 1, white)
+	nearWhite[0][1][0] = 1 - 0.001
+
+	tests := []struct {
+		name       string
+		tensor     [][][]float64
+		want       int
+		wantUnique [][4]float64
+	}{
+		{"two colors", checker, 2, [][4]float64{black, white}},
+		{"solid", solidTensor(3, 3, white), 1, [][4]float64{white}},
+		{"same 8-bit color", nearWhite, 1, [][4]float64{white}},
+		{"alpha differs", [][][]float64{{{0, 0, 0, 1}, {0, 0, 0, 0}}}, 2, [][4]float64{black, {}}},
+		{"empty", nil, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CountColors(tt.tensor); got != tt.want {
+				t.Errorf("CountColors = %d, want %d", got, tt.want)
+			}
+			unique := UniqueColors(tt.tensor, 10)
+			if len(unique) != len(tt.wantUnique) {
+				t.Fatalf("UniqueColors = %v, want %v", unique, tt.wantUnique)
+			}
+			for i := range unique {
+				if unique[i] != tt.wantUnique[i] {
+					t.Errorf("UniqueColors = %v, want %v", unique, tt.wantUnique)
+				}
+			}
+		})
+	}
+
+	if got := UniqueColors(checker, 1); len(got) != 1 {
+		t.Errorf("UniqueColors with limit 1 returned %d colors", len(got))
+	}
+}
+
+func TestEnergyHeatmap(t *testing.T) {
+	vertical := edgeTensor(16, 8, 0, 1)
+	horizontal := cloneTensor(vertical)
+	if err := Rotate90(&horizontal, 1); err != nil {
+		t.Fatal(err)
+	}
+	hot := [4]float64{0.5, 0, 0, 1}
+	cold := [4]float64{0, 0, 0.5, 1}
+
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+		// onEdge reports whether a pixel is next to the edge.
+		onEdge func(x, y int) bool
+	}{
+		{"vertical edge", vertical, func(x, y int) bool { return x == 7 || x == 8 }},
+		{"horizontal edge", horizontal, func(x, y int) bool { return y == 7 || y == 8 }},
+		{"flat", solidTensor(6, 6, [4]float64{0.3, 0.6, 0.9, 1}), func(x, y int) bool { return false }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heatmap, err := EnergyHeatmap(tt.tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, h, _ := Dimensions(tt.tensor)
+			if gw, gh, _ := Dimensions(heatmap); gw != w || gh != h {
+				t.Fatalf("heatmap is %dx%d, want %dx%d", gw, gh, w, h)
+			}
+			for y, row := range heatmap {
+				for x, p := range row {
+					want := cold
+					if tt.onEdge(x, y) {
+						want = hot
+					}
+					for c := range want {
+						if !near(p[c], want[c], 1e-9) {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, p, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestClippingOverlay(t *testing.T) {
+	// White, black, mid-gray and a saturated red with one clipped channel.
+	source := [][][]float64{{
+		{1, 1, 1, 1}, {0, 0, 0, 1}, {0.5, 0.5, 0.5, 1}, {1, 0.2, 0.1, 1},
+	}}
+	red, blue := []float64{1, 0, 0, 1}, []float64{0, 0, 1, 1}
+
+	tests := []struct {
+		name      string
+		high, low float64
+		want      [][]float64
+	}{
+		{"default thresholds", 0.98, 0.02, [][]float64{red, blue, {0.5, 0.5, 0.5, 1}, red}},
+		{"wide thresholds", 0.5, 0.5, [][]float64{red, blue, red, red}},
+		{"disabled", 2, -1, source[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := cloneTensor(source)
+			got := ClippingOverlay(original, tt.high, tt.low)
+			if !pixelsNear(got, [][][]float64{tt.want}, 0) {
+				t.Errorf("ClippingOverlay = %v, want %v", got, tt.want)
+			}
+			if !pixelsNear(original, source, 0) {
+				t.Error("ClippingOverlay modified its input")
+			}
+		})
+	}
+}
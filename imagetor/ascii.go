@@ -0,0 +1,51 @@
+package imagetor
+
+import (
+	"math"
+	"strings"
+)
+
+// asciiRamp holds the characters ToASCII maps luminance to, from black to
+// white, for light text on a dark terminal.
+const asciiRamp = " .:-=+*#%@"
+
+// ToASCII renders an image as ASCII art, for previewing images in a terminal.
+//
+// The image is downscaled to width columns, with half as many rows as the
+// aspect ratio calls for since terminal characters are about twice as tall as
+// they are wide. Each cell is mapped to a character by its luminance, from a
+// space for black to "@" for white. Transparent areas render as black.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	width: The number of columns of the output.
+//
+// Returns:
+//
+//	The rows of the rendering, each terminated by a newline. An empty tensor
+//	or a width below 1 returns an empty string. An error is returned if a
+//	worker panics.
+func ToASCII(tensor [][][]float64, width int) (string, error) {
+	srcWidth, srcHeight, _ := Dimensions(tensor)
+	if width < 1 || srcWidth == 0 || srcHeight == 0 {
+		return "", nil
+	}
+	height := max(1, int(math.Round(float64(srcHeight)*float64(width)/float64(srcWidth)/2)))
+	small, err := resizeArea(tensor, width, height)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.Grow((width + 1) * height)
+	last := len(asciiRamp) - 1
+	for _, row := range small {
+		for _, pixel := range row {
+			lum := clamp(luminance(pixel[0], pixel[1], pixel[2]))
+			sb.WriteByte(asciiRamp[int(math.Round(lum*float64(last)))])
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
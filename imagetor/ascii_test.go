@@ -0,0 +1,68 @@
+package imagetor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		name      string
+		tensor    [][][]float64
+		width     int
+		wantRows  int
+		wantFirst byte
+		wantLast  byte
+	}{
+		{"wide ramp", mustPattern(t, 100, 20, PatternGrayRamp), 40, 4, ' ', '@'},
+		{"ramp at full resolution", mustPattern(t, 10, 20, PatternGrayRamp), 10, 10, ' ', '@'},
+		{"single column", solidTensor(4, 8, [4]float64{1, 1, 1, 1}), 1, 1, '@', '@'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			art, err := ToASCII(tt.tensor, tt.width)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows := strings.Split(strings.TrimSuffix(art, "\n"), "\n")
+			if len(rows) != tt.wantRows {
+				t.Fatalf("got %d rows, want %d:\n%s", len(rows), tt.wantRows, art)
+			}
+			for _, row := range rows {
+				if len(row) != tt.width {
+					t.Fatalf("row %q has %d columns, want %d", row, len(row), tt.width)
+				}
+				if row[0] != tt.wantFirst || row[len(row)-1] != tt.wantLast {
+					t.Errorf("row %q runs from %q to %q, want %q to %q", row, row[0], row[len(row)-1], tt.wantFirst, tt.wantLast)
+				}
+				for x := 1; x < len(row); x++ {
+					if strings.IndexByte(asciiRamp, row[x]) < strings.IndexByte(asciiRamp, row[x-1]) {
+						t.Errorf("row %q gets darker at column %d", row, x)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestToASCIIEmpty(t *testing.T) {
+	for _, width := range []int{0, -3} {
+		if art, err := ToASCII(solidTensor(4, 4, [4]float64{1, 1, 1, 1}), width); art != "" || err != nil {
+			t.Errorf("ToASCII with width %d = %q, %v, want an empty string", width, art, err)
+		}
+	}
+	if art, err := ToASCII(nil, 10); art != "" || err != nil {
+		t.Errorf("ToASCII of an empty tensor = %q, %v, want an empty string", art, err)
+	}
+}
+
+// mustPattern returns a test pattern, failing the test if it cannot be made.
+func mustPattern(t *testing.T, width, height int, kind PatternKind) [][][]float64 {
+	t.Helper()
+	tensor, err := TestPattern(width, height, kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tensor
+}
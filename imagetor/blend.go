@@ -0,0 +1,141 @@
+package imagetor
+
+import "fmt"
+
+// BlendMode selects how Blend combines the colors of two images.
+type BlendMode int
+
+const (
+	// BlendNormal puts the top image over the bottom one.
+	BlendNormal BlendMode = iota
+	// BlendMultiply multiplies the colors, darkening the result. White leaves
+	// the bottom image unchanged.
+	BlendMultiply
+	// BlendScreen multiplies the inverted colors, lightening the result. Black
+	// leaves the bottom image unchanged.
+	BlendScreen
+	// BlendOverlay multiplies dark areas of the bottom image and screens light
+	// ones, increasing contrast.
+	BlendOverlay
+)
+
+// blendChannel combines a bottom and a top color channel, both unpremultiplied,
+// with a blend mode.
+func blendChannel(mode BlendMode, bottom, top float64) float64 {
+	switch mode {
+	case BlendMultiply:
+		return bottom * top
+	case BlendScreen:
+		return bottom + top - bottom*top
+	case BlendOverlay:
+		if bottom <= 0.5 {
+			return 2 * bottom * top
+		}
+		return 1 - 2*(1-bottom)*(1-top)
+	default:
+		return top
+	}
+}
+
+// Blend combines two images of the same size, placing b over a with the given
+// blend mode and opacity.
+//
+// Where both images are opaque the result is the blend mode applied to their
+// colors, mixed with a by the opacity. Elsewhere the images are composited as
+// specified by the W3C Compositing and Blending recommendation, so transparent
+// parts of b leave a unchanged.
+//
+// Args:
+//
+//	a: A pointer to the 3D tensor representing the bottom image, which is
+//	  replaced by the result.
+//	b: The 3D tensor representing the top image.
+//	mode: How to combine the colors.
+//	alpha: The opacity of b, from 0 to 1.
+//
+// Returns:
+//
+//	An error if either image is empty or ragged, their dimensions differ or
+//	the mode is unknown, in which case a is left unchanged, or if blending
+//	panics.
+func Blend(a *[][][]float64, b [][][]float64, mode BlendMode, alpha float64) error {
+	return blend(a, b, mode, alpha, false)
+}
+
+// BlendLinear combines two images of the same size as Blend does, but in
+// linear light.
+//
+// The colors of both images are converted from sRGB to linear light before
+// blending and the result is converted back, so that a 50% mix of red and
+// green comes out as bright as the light it models instead of darkened, as it
+// is when sRGB values are mixed directly.
+//
+// Args:
+//
+//	a: A pointer to the 3D tensor representing the bottom image, which is
+//	  replaced by the result.
+//	b: The 3D tensor representing the top image.
+//	mode: How to combine the colors.
+//	alpha: The opacity of b, from 0 to 1.
+//
+// Returns:
+//
+//	An error if either image is empty or ragged, their dimensions differ or
+//	the mode is unknown, in which case a is left unchanged, or if blending
+//	panics.
+func BlendLinear(a *[][][]float64, b [][][]float64, mode BlendMode, alpha float64) error {
+	return blend(a, b, mode, alpha, true)
+}
+
+// blend implements Blend and BlendLinear, blending in linear light when
+// linear is set.
+func blend(a *[][][]float64, b [][][]float64, mode BlendMode, alpha float64, linear bool) error {
+	if mode < BlendNormal || mode > BlendOverlay {
+		return fmt.Errorf("unknown blend mode %d", mode)
+	}
+	height, width, err := dimsOf(*a)
+	if err != nil {
+		return err
+	}
+	bHeight, bWidth, err := dimsOf(b)
+	if err != nil {
+		return err
+	}
+	if bWidth != width || bHeight != height {
+		return fmt.Errorf("dimensions differ: %dx%d and %dx%d", width, height, bWidth, bHeight)
+	}
+	alpha = clamp(alpha)
+
+	return parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x, dst := range (*a)[y] {
+				src := b[y][x]
+				// The channels are premultiplied; blend modes apply to the
+				// unpremultiplied colors.
+				srcAlpha, dstAlpha := src[3]*alpha, dst[3]
+				resultAlpha := srcAlpha + dstAlpha*(1-srcAlpha)
+				for c := 0; c < 3; c++ {
+					var top, bottom float64
+					if src[3] > 0 {
+						top = src[c] / src[3]
+					}
+					if dstAlpha > 0 {
+						bottom = dst[c] / dstAlpha
+					}
+					under := dst[c]
+					if linear {
+						top, bottom = srgbToLinear(top), srgbToLinear(bottom)
+						under = toLinearPremultiplied(dst[c], dstAlpha)
+					}
+					mixed := (1-dstAlpha)*top + dstAlpha*blendChannel(mode, bottom, top)
+					value := srcAlpha*mixed + (1-srcAlpha)*under
+					if linear {
+						value = fromLinearPremultiplied(value, resultAlpha)
+					}
+					dst[c] = value
+				}
+				dst[3] = resultAlpha
+			}
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package imagetor
+
+import "testing"
+
+func TestBlendLinear(t *testing.T) {
+	red, green := [4]float64{1, 0, 0, 1}, [4]float64{0, 1, 0, 1}
+	// Reference composites of 50% red over green: sRGB values mix directly,
+	// while in linear light each channel carries half the light of a full
+	// channel, which re-encodes to linearToSRGB(0.5), about 0.735.
+	half := linearToSRGB(0.5)
+	srgbRef := []float64{0.5, 0.5, 0, 1}
+	linearRef := []float64{half, half, 0, 1}
+
+	halfRed := solidTensor(2, 2, [4]float64{0.5, 0, 0, 0.5})
+
+	tests := []struct {
+		name    string
+		compose func(target *[][][]float64) error
+		want    []float64
+	}{
+		{"Blend", func(target *[][][]float64) error {
+			return Blend(target, solidTensor(2, 2, red), BlendNormal, 0.5)
+		}, srgbRef},
+		{"BlendLinear", func(target *[][][]float64) error {
+			return BlendLinear(target, solidTensor(2, 2, red), BlendNormal, 0.5)
+		}, linearRef},
+		{"AddOverlay", func(target *[][][]float64) error {
+			overlay := cloneTensor(halfRed)
+			return AddOverlay(target, &overlay)
+		}, srgbRef},
+		{"AddLinearOverlay", func(target *[][][]float64) error {
+			overlay := cloneTensor(halfRed)
+			return AddLinearOverlay(target, &overlay)
+		}, linearRef},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := solidTensor(2, 2, green)
+			if err := tt.compose(&target); err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range target {
+				for x, p := range row {
+					for c := range p {
+						if !near(p[c], tt.want[c], 1e-6) {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, p, tt.want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBlendModes(t *testing.T) {
+	white, black := [4]float64{1, 1, 1, 1}, [4]float64{0, 0, 0, 1}
+	gray := [4]float64{0.5, 0.5, 0.5, 1}
+
+	tests := []struct {
+		name  string
+		mode  BlendMode
+		top   [][][]float64
+		alpha float64
+		// want is nil when the bottom image should be unchanged.
+		want [][][]float64
+	}{
+		{"multiply with white", BlendMultiply, solidTensor(8, 6, white), 1, nil},
+		{"screen with black", BlendScreen, solidTensor(8, 6, black), 1, nil},
+		{"normal at opacity 0", BlendNormal, solidTensor(8, 6, white), 0, nil},
+		{"normal", BlendNormal, solidTensor(8, 6, gray), 1, solidTensor(8, 6, gray)},
+		{"multiply with black", BlendMultiply, solidTensor(8, 6, black), 1, solidTensor(8, 6, black)},
+		{"screen with white", BlendScreen, solidTensor(8, 6, white), 1, solidTensor(8, 6, white)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bottom := gradientTensor(8, 6)
+			tensor := cloneTensor(bottom)
+			if err := Blend(&tensor, tt.top, tt.mode, tt.alpha); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == nil {
+				want = bottom
+			}
+			if !pixelsNear(tensor, want, 1e-12) {
+				t.Error("blended image differs from the expected result")
+			}
+		})
+	}
+}
+
+func TestBlendOverlayMode(t *testing.T) {
+	// Overlay multiplies dark bottom colors and screens light ones.
+	tests := []struct {
+		name        string
+		bottom, top float64
+		want        float64
+	}{
+		{"dark bottom", 0.25, 0.5, 0.25},
+		{"light bottom", 0.75, 0.5, 0.75},
+		{"dark bottom, light top", 0.25, 0.8, 0.4},
+		{"light bottom, dark top", 0.75, 0.2, 0.6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := solidTensor(2, 2, [4]float64{tt.bottom, tt.bottom, tt.bottom, 1})
+			top := solidTensor(2, 2, [4]float64{tt.top, tt.top, tt.top, 1})
+			if err := Blend(&tensor, top, BlendOverlay, 1); err != nil {
+				t.Fatal(err)
+			}
+			if got := tensor[1][1][0]; !near(got, tt.want, 1e-12) {
+				t.Errorf("overlay of %v on %v = %v, want %v", tt.top, tt.bottom, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlendErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b [][][]float64
+		mode BlendMode
+	}{
+		{"dimensions differ", gradientTensor(4, 4), gradientTensor(4, 5), BlendNormal},
+		{"empty top", gradientTensor(4, 4), nil, BlendNormal},
+		{"empty bottom", nil, gradientTensor(4, 4), BlendNormal},
+		{"unknown mode", gradientTensor(4, 4), gradientTensor(4, 4), BlendOverlay + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := cloneTensor(tt.a)
+			if err := Blend(&a, tt.b, tt.mode, 1); err == nil {
+				t.Fatal("Blend did not return an error")
+			}
+			if !pixelsNear(a, tt.a, 0) {
+				t.Error("failed blend changed the bottom image")
+			}
+		})
+	}
+}
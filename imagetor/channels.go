@@ -0,0 +1,72 @@
+package imagetor
+
+import "fmt"
+
+// ExtractChannel copies one channel of a tensor into a plane of its own, for
+// example to inspect it or to feed it to code that expects a single channel.
+//
+// Tensors produced by ImageToTensor hold color premultiplied by alpha, and the
+// plane holds the stored values as they are.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	ch: The channel to extract: 0 for red, 1 for green, 2 for blue and 3 for
+//	  alpha.
+//
+// Returns:
+//
+//	A GrayTensor with the same dimensions as the tensor holding the channel,
+//	or nil if ch is not a valid channel.
+func ExtractChannel(tensor [][][]float64, ch int) GrayTensor {
+	if ch < 0 || ch >= channels {
+		return nil
+	}
+	plane := make(GrayTensor, len(tensor))
+	for y, row := range tensor {
+		plane[y] = make([]float64, len(row))
+		for x, pixel := range row {
+			plane[y][x] = pixel[ch]
+		}
+	}
+	return plane
+}
+
+// SwapChannels rearranges the channels of every pixel, for example to turn
+// RGBA into the BGRA order some libraries and models expect.
+//
+// Channel i of each pixel is replaced by channel order[i] of the original
+// pixel, so {2, 1, 0, 3} swaps red and blue, and applying it twice restores
+// the image. A channel may be repeated: {0, 0, 0, 3} copies red into green and
+// blue. Moving alpha into a color channel, or a color channel into alpha,
+// leaves the color no longer premultiplied by the alpha.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	order: The source channel of each channel, from 0 to 3.
+//
+// Returns:
+//
+//	An error if order holds an index outside [0, 3], in which case the image
+//	is left unchanged, or if a worker panics, which may leave the image partly
+//	rearranged.
+func SwapChannels(tensor *[][][]float64, order [4]int) error {
+	for i, c := range order {
+		if c < 0 || c >= channels {
+			return fmt.Errorf("channel order %v: index %d is %d, want 0 to %d", order, i, c, channels-1)
+		}
+	}
+
+	return parallelRows(len(*tensor), func(start, end int) {
+		var original [channels]float64
+		for y := start; y < end; y++ {
+			for _, pixel := range (*tensor)[y] {
+				copy(original[:], pixel)
+				for i, c := range order {
+					pixel[i] = original[c]
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package imagetor
+
+import "testing"
+
+func TestExtractChannel(t *testing.T) {
+	source := gradientTensor(6, 4)
+	tests := []struct {
+		name  string
+		ch    int
+		valid bool
+	}{
+		{"red", 0, true},
+		{"green", 1, true},
+		{"blue", 2, true},
+		{"alpha", 3, true},
+		{"negative", -1, false},
+		{"past alpha", 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plane := ExtractChannel(source, tt.ch)
+			if !tt.valid {
+				if plane != nil {
+					t.Errorf("ExtractChannel(%d) = %v, want nil", tt.ch, plane)
+				}
+				return
+			}
+			if len(plane) != 4 || len(plane[0]) != 6 {
+				t.Fatalf("plane is %dx%d, want 6x4", len(plane[0]), len(plane))
+			}
+			for y, row := range plane {
+				for x, v := range row {
+					if want := source[y][x][tt.ch]; v != want {
+						t.Fatalf("plane (%d, %d) = %v, want %v", x, y, v, want)
+					}
+				}
+			}
+			plane[0][0] = -1
+			if source[0][0][tt.ch] == -1 {
+				t.Error("plane shares its values with the tensor")
+			}
+		})
+	}
+}
+
+func TestSwapChannels(t *testing.T) {
+	pixel := []float64{0.1, 0.2, 0.3, 0.9}
+	tests := []struct {
+		name  string
+		order [4]int
+		want  []float64
+	}{
+		{"identity", [4]int{0, 1, 2, 3}, []float64{0.1, 0.2, 0.3, 0.9}},
+		{"BGRA", [4]int{2, 1, 0, 3}, []float64{0.3, 0.2, 0.1, 0.9}},
+		{"ARGB", [4]int{3, 0, 1, 2}, []float64{0.9, 0.1, 0.2, 0.3}},
+		{"repeated red", [4]int{0, 0, 0, 3}, []float64{0.1, 0.1, 0.1, 0.9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := [][][]float64{{append([]float64(nil), pixel...)}}
+			if err := SwapChannels(&tensor, tt.order); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, [][][]float64{{tt.want}}, 0) {
+				t.Errorf("SwapChannels(%v) = %v, want %v", tt.order, tensor[0][0], tt.want)
+			}
+		})
+	}
+
+	t.Run("BGRA twice", func(t *testing.T) {
+		tensor := gradientTensor(9, 7)
+		for i := 0; i < 2; i++ {
+			if err := SwapChannels(&tensor, [4]int{2, 1, 0, 3}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !pixelsNear(tensor, gradientTensor(9, 7), 0) {
+			t.Error("swapping to BGRA twice did not restore the image")
+		}
+	})
+}
+
+func TestSwapChannelsInvalidOrder(t *testing.T) {
+	for _, order := range [][4]int{{0, 1, 2, 4}, {-1, 1, 2, 3}} {
+		tensor := gradientTensor(3, 3)
+		if err := SwapChannels(&tensor, order); err == nil {
+			t.Errorf("SwapChannels(%v) did not return an error", order)
+		}
+		if !pixelsNear(tensor, gradientTensor(3, 3), 0) {
+			t.Errorf("SwapChannels(%v) changed the image", order)
+		}
+	}
+}
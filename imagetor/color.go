@@ -0,0 +1,403 @@
+package imagetor
+
+import "math"
+
+// luminance returns the perceived brightness of an RGB color using the same
+// LUMINOSITY weights as GrayScale.
+func luminance(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// clamp limits a channel value to the normalized range [0, 1].
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// AutoContrast stretches the contrast of the image while preserving its mean brightness.
+//
+// The RGB channels are scaled around the mean luminance of the image by the
+// largest factor that keeps the darkest and brightest pixels within [0, 1].
+// Unlike a plain levels stretch, the overall brightness of the image does not
+// shift. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+func AutoContrast(tensor *[][][]float64) {
+	lo, hi, sum, count := math.Inf(1), math.Inf(-1), 0.0, 0
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			l := luminance(pixel[0], pixel[1], pixel[2])
+			lo, hi = math.Min(lo, l), math.Max(hi, l)
+			sum += l
+			count++
+		}
+	}
+	if count == 0 || hi-lo < 1e-9 {
+		return
+	}
+
+	mean := sum / float64(count)
+	factor := math.Inf(1)
+	if hi > mean {
+		factor = (1 - mean) / (hi - mean)
+	}
+	if lo < mean {
+		factor = math.Min(factor, mean/(mean-lo))
+	}
+
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			for c := 0; c < 3; c++ {
+				pixel[c] = clamp(mean + factor*(pixel[c]-mean))
+			}
+		}
+	}
+}
+
+// rgbToHSL converts an RGB color to hue (in degrees, [0, 360)), saturation
+// and lightness.
+func rgbToHSL(r, g, b float64) (h, s, l float64) {
+	hi, lo := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l = (hi + lo) / 2
+	d := hi - lo
+	if d == 0 {
+		return 0, 0, l
+	}
+	s = d / (1 - math.Abs(2*l-1))
+
+	switch hi {
+	case r:
+		h = math.Mod((g-b)/d, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, s, l
+}
+
+// hslToRGB converts a hue (in degrees), saturation and lightness back to RGB.
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	chroma := (1 - math.Abs(2*l-1)) * s
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	x := chroma * (1 - math.Abs(math.Mod(h/60, 2)-1))
+
+	switch {
+	case h < 60:
+		r, g, b = chroma, x, 0
+	case h < 120:
+		r, g, b = x, chroma, 0
+	case h < 180:
+		r, g, b = 0, chroma, x
+	case h < 240:
+		r, g, b = 0, x, chroma
+	case h < 300:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+
+	m := l - chroma/2
+	return r + m, g + m, b + m
+}
+
+// pixelHSL returns the hue, saturation and lightness of the unpremultiplied
+// color of a premultiplied pixel. Fully transparent pixels carry no color and
+// come out as black.
+func pixelHSL(pixel []float64) (h, s, l float64) {
+	alpha := pixel[3]
+	if alpha <= 0 {
+		return 0, 0, 0
+	}
+	return rgbToHSL(clamp(pixel[0]/alpha), clamp(pixel[1]/alpha), clamp(pixel[2]/alpha))
+}
+
+// setPixelHSL sets the color of a premultiplied pixel from a hue, saturation
+// and lightness, premultiplying it by the alpha of the pixel.
+func setPixelHSL(pixel []float64, h, s, l float64) {
+	r, g, b := hslToRGB(h, s, l)
+	pixel[0], pixel[1], pixel[2] = r*pixel[3], g*pixel[3], b*pixel[3]
+}
+
+// hueDistance returns the angular distance between two hues, in degrees.
+func hueDistance(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	return math.Min(d, 360-d)
+}
+
+// RecolorRange shifts the hue of every pixel whose hue lies near fromHue so
+// that the selected range becomes centered on toHue.
+//
+// Saturation and lightness are preserved, so for example a red object can be
+// turned blue without affecting the rest of the image. Gray pixels have no hue
+// and are never selected. Translucent pixels are judged and recolored on their
+// unpremultiplied color. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	fromHue: The center of the hue range to recolor, in degrees.
+//	toHue: The hue the range is moved to, in degrees.
+//	hueRange: The maximum distance from fromHue, in degrees, of selected hues.
+func RecolorRange(tensor *[][][]float64, fromHue, toHue, hueRange float64) {
+	shift := toHue - fromHue
+
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			h, s, l := pixelHSL(pixel)
+			if s == 0 || hueDistance(h, fromHue) > hueRange {
+				continue
+			}
+			setPixelHSL(pixel, h+shift, s, l)
+		}
+	}
+}
+
+// HueRotate shifts the hue of every pixel by the given angle, wrapping around
+// the color wheel, to recolor an image to a different color theme.
+//
+// Saturation and lightness are preserved, so a rotation of 120 degrees turns
+// pure red into pure green. Gray pixels have no hue and are unaffected.
+// Translucent pixels are rotated on their unpremultiplied color. Alpha is left
+// untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	degrees: The angle to rotate hues by, in degrees. Negative values rotate
+//	  the other way.
+func HueRotate(tensor *[][][]float64, degrees float64) {
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			h, s, l := pixelHSL(pixel)
+			if s == 0 {
+				continue
+			}
+			setPixelHSL(pixel, h+degrees, s, l)
+		}
+	}
+}
+
+// Lightness brightens or darkens the image by shifting the lightness of each
+// pixel in HSL space.
+//
+// Hue and saturation are preserved, so colors do not wash out the way they do
+// when RGB values are scaled. Translucent pixels are shifted on their
+// unpremultiplied color, and the shifted lightness is clamped to [0, 1].
+// Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	delta: The amount added to the lightness, from -1 (black) to 1 (white).
+func Lightness(tensor *[][][]float64, delta float64) {
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			h, s, l := pixelHSL(pixel)
+			setPixelHSL(pixel, h, s, clamp(l+delta))
+		}
+	}
+}
+
+// Saturation makes the colors of the image more or less vivid.
+//
+// Each pixel is moved away from or towards the gray of its own luminance, so
+// the perceived brightness is preserved: a factor of 0 gives the same result as
+// GrayScale, 1 leaves the image unchanged and values above 1 boost the colors.
+// Boosted channels are clamped to the valid range. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	factor: The saturation multiplier, at least 0.
+func Saturation(tensor *[][][]float64, factor float64) {
+	factor = math.Max(0, factor)
+
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			gray := luminance(pixel[0], pixel[1], pixel[2])
+			for c := 0; c < 3; c++ {
+				// Premultiplied channels cannot exceed alpha.
+				pixel[c] = math.Max(0, math.Min(pixel[3], gray+factor*(pixel[c]-gray)))
+			}
+		}
+	}
+}
+
+// Brightness brightens or darkens the image by adding a constant to each RGB
+// channel.
+//
+// Tensors produced by ImageToTensor hold color premultiplied by alpha, so the
+// constant is added to the unpremultiplied color of translucent pixels, which
+// keeps their channels from exceeding alpha. The results are clamped to
+// [0, 1]. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	delta: The amount added to each channel, from -1 (black) to 1 (white).
+func Brightness(tensor *[][][]float64, delta float64) {
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			alpha := pixel[3]
+			if alpha <= 0 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				pixel[c] = clamp(pixel[c]/alpha+delta) * alpha
+			}
+		}
+	}
+}
+
+// Gamma applies gamma correction, raising each RGB channel to the power
+// 1/gamma.
+//
+// A gamma above 1 brightens the midtones and one below 1 darkens them, while
+// black and white stay fixed. Tensors produced by ImageToTensor hold color
+// premultiplied by alpha, so translucent pixels are corrected on their
+// unpremultiplied color. The results are clamped to [0, 1]. Alpha is left
+// untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	gamma: The display gamma, greater than 0; for example 2.2. Other values
+//	  leave the image unchanged.
+func Gamma(tensor *[][][]float64, gamma float64) {
+	if gamma <= 0 || gamma == 1 {
+		return
+	}
+	exponent := 1 / gamma
+
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			alpha := pixel[3]
+			if alpha <= 0 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				pixel[c] = math.Pow(clamp(pixel[c]/alpha), exponent) * alpha
+			}
+		}
+	}
+}
+
+// Invert replaces the image with its negative.
+//
+// Each RGB channel is replaced with 1 - value. Tensors produced by
+// ImageToTensor hold color premultiplied by alpha, so the channels of
+// translucent pixels are inverted within their alpha instead, as alpha - value.
+// Alpha is left untouched, and inverting twice restores the image.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+func Invert(tensor *[][][]float64) {
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			for c := 0; c < 3; c++ {
+				pixel[c] = pixel[3] - pixel[c]
+			}
+		}
+	}
+}
+
+// Threshold binarizes the image, turning each pixel black or white depending
+// on its luminance, as preprocessing for OCR or to build a mask.
+//
+// Pixels whose luminance exceeds level become white and all others black.
+// Tensors produced by ImageToTensor hold color premultiplied by alpha, so the
+// luminance of translucent pixels is measured on their unpremultiplied color
+// and white is stored as alpha. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	level: The luminance above which pixels become white, from 0 to 1.
+func Threshold(tensor *[][][]float64, level float64) {
+	for _, row := range *tensor {
+		for _, pixel := range row {
+			alpha := pixel[3]
+			value := 0.0
+			if alpha > 0 && luminance(pixel[0], pixel[1], pixel[2])/alpha > level {
+				value = alpha
+			}
+			pixel[0], pixel[1], pixel[2] = value, value, value
+		}
+	}
+}
+
+// Rescale linearly maps the values of each color channel from their actual
+// minimum and maximum to the range [0, 1].
+//
+// This makes the output of operations that leave values outside [0, 1], such
+// as convolutions or difference images, viewable without clipping. Unlike
+// clamping, relative differences are preserved. A channel holding a single
+// value is only clamped. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+func Rescale(tensor *[][][]float64) {
+	for c := 0; c < 3; c++ {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, row := range *tensor {
+			for _, pixel := range row {
+				lo, hi = math.Min(lo, pixel[c]), math.Max(hi, pixel[c])
+			}
+		}
+
+		for _, row := range *tensor {
+			for _, pixel := range row {
+				if hi > lo {
+					pixel[c] = (pixel[c] - lo) / (hi - lo)
+				} else {
+					pixel[c] = clamp(pixel[c])
+				}
+			}
+		}
+	}
+}
+
+// srgbToLinear converts an sRGB-encoded channel value to linear light.
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts a linear-light channel value to sRGB encoding.
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// toLinearPremultiplied converts a premultiplied sRGB channel value with the
+// given alpha to a premultiplied linear-light value. The transfer function
+// applies to the unpremultiplied color, so alpha is divided out first.
+func toLinearPremultiplied(v, alpha float64) float64 {
+	if alpha <= 0 {
+		return 0
+	}
+	return srgbToLinear(v/alpha) * alpha
+}
+
+// fromLinearPremultiplied is the inverse of toLinearPremultiplied.
+func fromLinearPremultiplied(v, alpha float64) float64 {
+	if alpha <= 0 {
+		return 0
+	}
+	return linearToSRGB(v/alpha) * alpha
+}
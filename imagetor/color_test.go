@@ -0,0 +1,380 @@
+package imagetor
+
+import (
+	"math"
+	"testing"
+)
+
+// lumaStats returns the mean and standard deviation of the luminance of a
+// tensor.
+func lumaStats(tensor [][][]float64) (mean, std float64) {
+	var sum, sumSq float64
+	n := 0
+	for _, row := range tensor {
+		for _, p := range row {
+			l := luminance(p[0], p[1], p[2])
+			sum += l
+			sumSq += l * l
+			n++
+		}
+	}
+	mean = sum / float64(n)
+	return mean, math.Sqrt(math.Max(0, sumSq/float64(n)-mean*mean))
+}
+
+func TestAutoContrast(t *testing.T) {
+	tests := []struct {
+		name   string
+		lo, hi float64
+	}{
+		{"dark", 0.1, 0.3},
+		{"midtones", 0.4, 0.6},
+		{"bright", 0.75, 0.9},
+		{"asymmetric", 0.2, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := newTensor(16, 4)
+			for _, row := range tensor {
+				for x, p := range row {
+					v := tt.lo + (tt.hi-tt.lo)*float64(x)/15
+					p[0], p[1], p[2], p[3] = v, v, v, 1
+				}
+			}
+			meanBefore, stdBefore := lumaStats(tensor)
+
+			AutoContrast(&tensor)
+			meanAfter, stdAfter := lumaStats(tensor)
+			if stdAfter <= stdBefore*1.5 {
+				t.Errorf("luminance std = %v, want well above %v", stdAfter, stdBefore)
+			}
+			if !near(meanAfter, meanBefore, 0.01) {
+				t.Errorf("luminance mean = %v, want %v", meanAfter, meanBefore)
+			}
+			for _, p := range tensor[0] {
+				if p[3] != 1 {
+					t.Fatalf("alpha = %v, want 1", p[3])
+				}
+			}
+		})
+	}
+}
+
+func TestRecolorRange(t *testing.T) {
+	red, green := [4]float64{0.9, 0.1, 0.1, 1}, [4]float64{0.1, 0.7, 0.1, 1}
+	// A half transparent red pixel, premultiplied.
+	translucentRed := [4]float64{0.45, 0.05, 0.05, 0.5}
+
+	tests := []struct {
+		name         string
+		from, to     float64
+		wantRed      [4]float64
+		wantGreen    [4]float64
+		wantTransRed [4]float64
+	}{
+		{"red to blue", 0, 240, [4]float64{0.1, 0.1, 0.9, 1}, green, [4]float64{0.05, 0.05, 0.45, 0.5}},
+		{"red to green", 0, 120, [4]float64{0.1, 0.9, 0.1, 1}, green, [4]float64{0.05, 0.45, 0.05, 0.5}},
+		{"green to yellow", 120, 60, red, [4]float64{0.7, 0.7, 0.1, 1}, translucentRed},
+		{"no match", 200, 0, red, green, translucentRed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A red object in the middle of a green background.
+			tensor := newTensor(5, 3)
+			for y, row := range tensor {
+				for x, p := range row {
+					switch {
+					case x == 2 && y == 1:
+						copy(p, translucentRed[:])
+					case x >= 1 && x <= 3 && y == 1:
+						copy(p, red[:])
+					default:
+						copy(p, green[:])
+					}
+				}
+			}
+
+			RecolorRange(&tensor, tt.from, tt.to, 30)
+			for y, row := range tensor {
+				for x, p := range row {
+					want := tt.wantGreen
+					switch {
+					case x == 2 && y == 1:
+						want = tt.wantTransRed
+					case x >= 1 && x <= 3 && y == 1:
+						want = tt.wantRed
+					}
+					for c := range want {
+						if !near(p[c], want[c], 1e-9) {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, p, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLightness(t *testing.T) {
+	tests := []struct {
+		name  string
+		pixel []float64
+		delta float64
+	}{
+		{"brighten saturated red", []float64{0.8, 0.1, 0.1, 1}, 0.2},
+		{"brighten saturated blue", []float64{0.1, 0.2, 0.7, 1}, 0.1},
+		{"darken orange", []float64{0.9, 0.5, 0.1, 1}, -0.2},
+		{"brighten translucent green", []float64{0.05, 0.3, 0.1, 0.5}, 0.15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := [][][]float64{{append([]float64(nil), tt.pixel...)}}
+			Lightness(&tensor, tt.delta)
+			got := tensor[0][0]
+
+			h0, s0, l0 := pixelHSL(tt.pixel)
+			h, s, l := pixelHSL(got)
+			if !near(l, l0+tt.delta, 1e-9) {
+				t.Errorf("lightness = %v, want %v", l, l0+tt.delta)
+			}
+			if hueDistance(h, h0) > 1e-6 {
+				t.Errorf("hue = %v, want %v", h, h0)
+			}
+			if !near(s, s0, 1e-9) {
+				t.Errorf("saturation = %v, want %v", s, s0)
+			}
+			if got[3] != tt.pixel[3] {
+				t.Errorf("alpha = %v, want %v", got[3], tt.pixel[3])
+			}
+
+			before := luminance(tt.pixel[0], tt.pixel[1], tt.pixel[2])
+			after := luminance(got[0], got[1], got[2])
+			if (after > before) != (tt.delta > 0) {
+				t.Errorf("luminance went from %v to %v for delta %v", before, after, tt.delta)
+			}
+		})
+	}
+}
+
+func TestRescale(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+		want   [][][]float64
+	}{
+		{
+			"negative and above one",
+			[][][]float64{{{-0.5, 2, 0.25, 1}, {0.5, -1, 0.75, 0.5}, {1.5, 0.5, 0.5, 1}}},
+			[][][]float64{{{0, 1, 0, 1}, {0.5, 0, 1, 0.5}, {1, 0.5, 0.5, 1}}},
+		},
+		{
+			"constant channels are clamped",
+			[][][]float64{{{1.5, -0.5, 0.3, 1}}, {{1.5, -0.5, 0.3, 1}}},
+			[][][]float64{{{1, 0, 0.3, 1}}, {{1, 0, 0.3, 1}}},
+		},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Rescale(&tt.tensor)
+			if !pixelsNear(tt.tensor, tt.want, 1e-12) {
+				t.Errorf("Rescale = %v, want %v", tt.tensor, tt.want)
+			}
+		})
+	}
+}
+
+func TestBrightness(t *testing.T) {
+	tests := []struct {
+		name  string
+		pixel []float64
+		delta float64
+		want  []float64
+	}{
+		{"mid-gray brightened", []float64{0.5, 0.5, 0.5, 1}, 0.5, []float64{1, 1, 1, 1}},
+		{"mid-gray darkened", []float64{0.5, 0.5, 0.5, 1}, -0.25, []float64{0.25, 0.25, 0.25, 1}},
+		{"clamped", []float64{0.9, 0.2, 0.1, 1}, 0.5, []float64{1, 0.7, 0.6, 1}},
+		{"zero delta", []float64{0.3, 0.6, 0.9, 1}, 0, []float64{0.3, 0.6, 0.9, 1}},
+		{"translucent", []float64{0.25, 0.25, 0.25, 0.5}, 0.25, []float64{0.375, 0.375, 0.375, 0.5}},
+		{"transparent", []float64{0, 0, 0, 0}, 0.5, []float64{0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := [][][]float64{{append([]float64(nil), tt.pixel...)}}
+			Brightness(&tensor, tt.delta)
+			if !pixelsNear(tensor, [][][]float64{{tt.want}}, 1e-12) {
+				t.Errorf("Brightness(%v, %v) = %v, want %v", tt.pixel, tt.delta, tensor[0][0], tt.want)
+			}
+		})
+	}
+}
+
+func TestInvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+		want   [][][]float64
+	}{
+		{
+			"opaque",
+			[][][]float64{{{0, 0.25, 1, 1}, {0.5, 0.5, 0.5, 1}}},
+			[][][]float64{{{1, 0.75, 0, 1}, {0.5, 0.5, 0.5, 1}}},
+		},
+		{
+			"translucent",
+			[][][]float64{{{0.1, 0.2, 0.5, 0.5}, {0, 0, 0, 0}}},
+			[][][]float64{{{0.4, 0.3, 0, 0.5}, {0, 0, 0, 0}}},
+		},
+		{"gradient", gradientTensor(7, 5), nil},
+		{"partial alpha", alphaRamp(9, 3), nil},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := cloneTensor(tt.tensor)
+			Invert(&tensor)
+			if tt.want != nil && !pixelsNear(tensor, tt.want, 1e-12) {
+				t.Errorf("Invert = %v, want %v", tensor, tt.want)
+			}
+			Invert(&tensor)
+			if !pixelsNear(tensor, tt.tensor, 1e-12) {
+				t.Error("inverting twice did not restore the image")
+			}
+		})
+	}
+}
+
+// grayRamp returns a 101 pixel wide gray ramp from 0 to 1 in steps of 0.01,
+// premultiplied by alpha.
+func grayRamp(alpha float64) [][][]float64 {
+	tensor := newTensor(101, 2)
+	for _, row := range tensor {
+		for x, p := range row {
+			v := float64(x) / 100 * alpha
+			p[0], p[1], p[2], p[3] = v, v, v, alpha
+		}
+	}
+	return tensor
+}
+
+func TestThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   float64
+		alpha   float64
+		firstOn int
+	}{
+		{"middle", 0.505, 1, 51},
+		{"low", 0.255, 1, 26},
+		{"high", 0.755, 1, 76},
+		{"zero keeps only black", 0, 1, 1},
+		{"one turns everything black", 1, 1, 101},
+		{"translucent", 0.505, 0.5, 51},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := grayRamp(tt.alpha)
+			Threshold(&tensor, tt.level)
+			for y, row := range tensor {
+				for x, p := range row {
+					want := 0.0
+					if x >= tt.firstOn {
+						want = tt.alpha
+					}
+					if p[0] != want || p[1] != want || p[2] != want || p[3] != tt.alpha {
+						t.Fatalf("pixel (%d, %d) = %v, want %v with alpha %v", x, y, p, want, tt.alpha)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGamma(t *testing.T) {
+	tests := []struct {
+		name  string
+		pixel []float64
+		gamma float64
+		want  []float64
+	}{
+		{"gamma 1", []float64{0.2, 0.5, 0.8, 1}, 1, []float64{0.2, 0.5, 0.8, 1}},
+		{"midtone at 2.2", []float64{0.5, 0.5, 0.5, 1}, 2.2, []float64{0.7297400528, 0.7297400528, 0.7297400528, 1}},
+		{"midtone at 1/2.2", []float64{0.5, 0.5, 0.5, 1}, 1 / 2.2, []float64{0.2176376408, 0.2176376408, 0.2176376408, 1}},
+		{"black and white fixed", []float64{0, 1, 0, 1}, 2.2, []float64{0, 1, 0, 1}},
+		{"translucent", []float64{0.25, 0.25, 0.25, 0.5}, 2.2, []float64{0.3648700264, 0.3648700264, 0.3648700264, 0.5}},
+		{"zero gamma ignored", []float64{0.2, 0.5, 0.8, 1}, 0, []float64{0.2, 0.5, 0.8, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := [][][]float64{{append([]float64(nil), tt.pixel...)}}
+			Gamma(&tensor, tt.gamma)
+			if !pixelsNear(tensor, [][][]float64{{tt.want}}, 1e-9) {
+				t.Errorf("Gamma(%v, %v) = %v, want %v", tt.pixel, tt.gamma, tensor[0][0], tt.want)
+			}
+		})
+	}
+}
+
+func TestSaturation(t *testing.T) {
+	source := gradientTensor(16, 12)
+	gray := cloneTensor(source)
+	if err := GrayScale(&gray); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		factor float64
+		want   [][][]float64
+	}{
+		{"factor 0 is GrayScale", 0, gray},
+		{"negative is clamped to 0", -1, gray},
+		{"factor 1 is the identity", 1, source},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := cloneTensor(source)
+			Saturation(&tensor, tt.factor)
+			if !pixelsNear(tensor, tt.want, 1e-9) {
+				t.Error("saturated image differs from the expected result")
+			}
+		})
+	}
+
+	t.Run("boost", func(t *testing.T) {
+		tensor := [][][]float64{{{0.6, 0.4, 0.4, 1}}}
+		Saturation(&tensor, 2)
+		got := tensor[0][0]
+		if !(got[0] > 0.6 && got[1] < 0.4 && got[2] < 0.4) {
+			t.Errorf("boosted pixel = %v, want more vivid than [0.6 0.4 0.4]", got)
+		}
+		if before, after := luminance(0.6, 0.4, 0.4), luminance(got[0], got[1], got[2]); !near(after, before, 1e-9) {
+			t.Errorf("luminance changed from %v to %v", before, after)
+		}
+	})
+}
+
+func TestHueRotate(t *testing.T) {
+	tests := []struct {
+		name    string
+		color   [4]float64
+		degrees float64
+		want    [4]float64
+	}{
+		{"red by 120 is green", [4]float64{1, 0, 0, 1}, 120, [4]float64{0, 1, 0, 1}},
+		{"red by 240 is blue", [4]float64{1, 0, 0, 1}, 240, [4]float64{0, 0, 1, 1}},
+		{"red by -120 is blue", [4]float64{1, 0, 0, 1}, -120, [4]float64{0, 0, 1, 1}},
+		{"wraps past 360", [4]float64{1, 0, 0, 1}, 480, [4]float64{0, 1, 0, 1}},
+		{"translucent red", [4]float64{0.5, 0, 0, 0.5}, 120, [4]float64{0, 0.5, 0, 0.5}},
+		{"gray is unaffected", [4]float64{0.4, 0.4, 0.4, 1}, 90, [4]float64{0.4, 0.4, 0.4, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := solidTensor(3, 2, tt.color)
+			HueRotate(&tensor, tt.degrees)
+			if !pixelsNear(tensor, solidTensor(3, 2, tt.want), 1e-9) {
+				t.Errorf("HueRotate(%v, %v) = %v, want %v", tt.color, tt.degrees, tensor[0][0], tt.want)
+			}
+		})
+	}
+}
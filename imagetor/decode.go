@@ -0,0 +1,206 @@
+package imagetor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// DecodeTensor decodes a JPEG, PNG, BMP, TIFF or WebP image from a reader to a
+// tensor, for images that arrive as uploads or in-memory buffers rather than
+// files. Only the first page of a multi-page TIFF is decoded.
+//
+// The format is recognized by the magic bytes at the start of the stream,
+// which are peeked at without requiring the reader to support seeking. Images
+// with an EXIF orientation are turned upright with ApplyOrientation.
+//
+// Args:
+//
+//	r: The reader holding the encoded image.
+//
+// Returns:
+//
+//	The decoded tensor, or an error if the stream is not in a supported format
+//	or cannot be decoded.
+func DecodeTensor(r io.Reader) ([][][]float64, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(12)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if detectFormat(header) == "" {
+		return nil, fmt.Errorf("unsupported image format")
+	}
+
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	tensor, _, err := decodeWithMetadata(data)
+	return tensor, err
+}
+
+// DecodeTensorTolerant decodes an image from a reader, recovering what it can
+// from truncated or partially corrupt baseline JPEG streams.
+//
+// Well-formed images decode exactly as with DecodeTensor. When a JPEG stream
+// ends early or its entropy-coded data is damaged, every MCU (the 8x8 or 16x16
+// pixel blocks a JPEG is coded in) decoded before the damage is kept and the
+// remaining pixels are left fully transparent. Either way, images with an EXIF
+// orientation are turned upright with ApplyOrientation.
+//
+// Args:
+//
+//	r: The reader holding the encoded image.
+//
+// Returns:
+//
+//	The decoded tensor, a flag reporting whether only part of the image could
+//	be recovered, and an error if the stream is not a supported image or no
+//	pixels could be recovered from it.
+func DecodeTensorTolerant(r io.Reader) ([][][]float64, bool, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tensor, _, decodeErr := decodeWithMetadata(data)
+	if decodeErr == nil {
+		return tensor, false, nil
+	}
+
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, false, decodeErr
+	}
+
+	repaired, damaged, err := repairJPEG(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("%v (recovery failed: %v)", decodeErr, err)
+	}
+
+	img, _, err := decodeImage(repaired)
+	if err != nil {
+		return nil, false, fmt.Errorf("%v (recovery failed: %v)", decodeErr, err)
+	}
+
+	tensor, err = ImageToTensor(img)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, rect := range damaged {
+		rect = rect.Intersect(img.Bounds())
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				for c := 0; c < channels; c++ {
+					tensor[y][x][c] = 0
+				}
+			}
+		}
+	}
+
+	// The damaged blocks are in stored coordinates, so turn the image upright
+	// only after clearing them.
+	if orientation := exifOrientation(jpegMetadata(data).EXIF); orientation != 1 {
+		if err := ApplyOrientation(&tensor, orientation); err != nil {
+			return nil, false, err
+		}
+	}
+	return tensor, len(damaged) > 0, nil
+}
+
+// detectFormat returns the format of an encoded image recognized by the magic
+// bytes at its start: "jpeg", "png", "bmp", "tiff" or "webp", or "" if none
+// matches. The header must hold at least the first 12 bytes to recognize WebP.
+func detectFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8}):
+		return "jpeg"
+	case bytes.HasPrefix(header, []byte(pngSignature)):
+		return "png"
+	case bytes.HasPrefix(header, []byte("BM")):
+		return "bmp"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "tiff"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "webp"
+	default:
+		return ""
+	}
+}
+
+// decodeImage decodes an image in any registered format from data.
+//
+// In addition to what image.Decode accepts, it decodes 4-component CMYK JPEGs
+// that lack the Adobe APP14 segment. The standard decoder only handles CMYK
+// JPEGs written with that segment, whose values are stored inverted (255
+// meaning no ink) as Adobe applications do, and rejects the others. Without the
+// segment the values are taken to be stored uninverted.
+//
+// Args:
+//
+//	data: The encoded image.
+//
+// Returns:
+//
+//	The decoded image, the name of its format and any decoding error.
+func decodeImage(data []byte) (image.Image, string, error) {
+	if !isUnmarkedCMYKJPEG(data) {
+		return image.Decode(bytes.NewReader(data))
+	}
+
+	// Insert an Adobe segment with transform 0 (CMYK) after the SOI marker,
+	// then undo the inversion the decoder applies for it.
+	adobe := []byte{0xFF, 0xEE, 0x00, 0x0E, 'A', 'd', 'o', 'b', 'e', 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00}
+	marked := append(append(append([]byte(nil), data[:2]...), adobe...), data[2:]...)
+
+	img, err := jpeg.Decode(bytes.NewReader(marked))
+	if err != nil {
+		return nil, "jpeg", err
+	}
+	if cmyk, ok := img.(*image.CMYK); ok {
+		for i := range cmyk.Pix {
+			cmyk.Pix[i] = 255 - cmyk.Pix[i]
+		}
+	}
+	return img, "jpeg", nil
+}
+
+// isUnmarkedCMYKJPEG reports whether data is a JPEG with four color components
+// and no Adobe APP14 segment before its first scan.
+func isUnmarkedCMYKJPEG(data []byte) bool {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return false
+	}
+
+	fourComponents, adobe := false, false
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		switch {
+		case marker == 0xDA || marker == 0xD9:
+			return fourComponents && !adobe
+		case marker == 0xEE && len(segment) >= 5 && string(segment[:5]) == "Adobe":
+			adobe = true
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			fourComponents = len(segment) >= 6 && segment[5] == 4
+		}
+	}
+	return fourComponents && !adobe
+}
@@ -0,0 +1,408 @@
+package imagetor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+)
+
+// encodeTestJPEG encodes a tensor as a baseline JPEG at high quality.
+func encodeTestJPEG(t *testing.T, tensor [][][]float64) []byte {
+	t.Helper()
+	img, err := TensorToImage(tensor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// orientationEXIF returns a big-endian EXIF payload holding only the given
+// orientation tag.
+func orientationEXIF(orientation int) []byte {
+	exif := []byte("MM\x00*\x00\x00\x00\x08")
+	exif = binary.BigEndian.AppendUint16(exif, 1)
+	exif = binary.BigEndian.AppendUint16(exif, exifOrientationTag)
+	exif = binary.BigEndian.AppendUint16(exif, 3) // SHORT
+	exif = binary.BigEndian.AppendUint32(exif, 1)
+	exif = binary.BigEndian.AppendUint16(exif, uint16(orientation))
+	exif = append(exif, 0, 0)                     // value padding
+	return binary.BigEndian.AppendUint32(exif, 0) // no next IFD
+}
+
+// truncateScan cuts a JPEG stream halfway through its entropy-coded data.
+func truncateScan(t *testing.T, data []byte) []byte {
+	t.Helper()
+	sos := bytes.Index(data, []byte{0xFF, 0xDA})
+	if sos < 0 {
+		t.Fatal("no SOS marker in JPEG")
+	}
+	return data[:sos+(len(data)-sos)/2]
+}
+
+// stripesTensor returns an opaque tensor whose rows cycle through red, green
+// and blue in bands of eight rows, matching the JPEG block grid.
+func stripesTensor(width, height int) [][][]float64 {
+	tensor := newTensor(width, height)
+	for y, row := range tensor {
+		for _, p := range row {
+			p[(y/8)%3], p[3] = 1, 1
+		}
+	}
+	return tensor
+}
+
+func TestDecodeTensorTolerant(t *testing.T) {
+	const width, height = 128, 96
+	source := stripesTensor(width, height)
+	full := encodeTestJPEG(t, source)
+	withEXIF, err := embedJPEGMetadata(full, Metadata{EXIF: orientationEXIF(6)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantPartial bool
+		wantWidth   int
+		wantHeight  int
+	}{
+		{"complete", full, false, width, height},
+		{"truncated mid-stream", truncateScan(t, full), true, width, height},
+		{"complete, orientation 6", withEXIF, false, height, width},
+		{"truncated, orientation 6", truncateScan(t, withEXIF), true, height, width},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor, partial, err := DecodeTensorTolerant(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if partial != tt.wantPartial {
+				t.Errorf("partial = %v, want %v", partial, tt.wantPartial)
+			}
+			gotWidth, gotHeight, _ := Dimensions(tensor)
+			if gotWidth != tt.wantWidth || gotHeight != tt.wantHeight {
+				t.Fatalf("dimensions = %dx%d, want %dx%d", gotWidth, gotHeight, tt.wantWidth, tt.wantHeight)
+			}
+
+			// Turn rotated results back to the stored orientation.
+			if tt.wantWidth != width {
+				if err := Rotate90(&tensor, -1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The top band is decoded before any damage.
+			for y := 0; y < 8; y++ {
+				for x := 0; x < width; x++ {
+					if p := tensor[y][x]; p[0] < 0.9 || p[1] > 0.1 || p[2] > 0.1 || p[3] != 1 {
+						t.Fatalf("pixel (%d, %d) = %v, want opaque red", x, y, p)
+					}
+				}
+			}
+			if tt.wantPartial {
+				if p := tensor[height-1][width-1]; p[3] != 0 {
+					t.Errorf("bottom-right pixel = %v, want transparent", p)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTensorTolerantInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not an image", []byte("hello, world")},
+		{"jpeg header only", []byte{0xFF, 0xD8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := DecodeTensorTolerant(bytes.NewReader(tt.data)); err == nil {
+				t.Error("DecodeTensorTolerant returned no error")
+			}
+		})
+	}
+}
+
+// readTestdata returns the contents of a file in the testdata directory.
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeCMYK(t *testing.T) {
+	marked := readTestdata(t, "video-001.cmyk.jpeg")
+	reference, err := png.Decode(bytes.NewReader(readTestdata(t, "video-001.cmyk.png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMarked, err := ImageToTensor(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without its Adobe segment, the same stream holds inverted ink values.
+	adobe := bytes.Index(marked, []byte{0xFF, 0xEE})
+	if adobe < 0 {
+		t.Fatal("no Adobe segment in the CMYK fixture")
+	}
+	length := int(marked[adobe+2])<<8 | int(marked[adobe+3])
+	unmarked := append(append([]byte(nil), marked[:adobe]...), marked[adobe+2+length:]...)
+	img, err := jpeg.Decode(bytes.NewReader(marked))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inverted := img.(*image.CMYK)
+	for i := range inverted.Pix {
+		inverted.Pix[i] = 255 - inverted.Pix[i]
+	}
+	wantUnmarked, err := ImageToTensor(inverted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want [][][]float64
+		tol  float64
+	}{
+		{"Adobe APP14", marked, wantMarked, 2.0 / 255},
+		{"unmarked", unmarked, wantUnmarked, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor, err := DecodeTensor(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, tt.want, tt.tol) {
+				t.Error("decoded pixels differ from the reference")
+			}
+		})
+	}
+}
+
+func TestDecodeTensorPNG(t *testing.T) {
+	// Straight colors with their expected premultiplied tensor values.
+	colors := []struct {
+		c    color.NRGBA
+		want []float64
+	}{
+		{color.NRGBA{255, 0, 0, 255}, []float64{1, 0, 0, 1}},
+		{color.NRGBA{0, 255, 0, 255}, []float64{0, 1, 0, 1}},
+		{color.NRGBA{0, 0, 255, 255}, []float64{0, 0, 1, 1}},
+		{color.NRGBA{255, 255, 255, 128}, []float64{0.5, 0.5, 0.5, 0.5}},
+		{color.NRGBA{255, 0, 255, 0}, []float64{0, 0, 0, 0}},
+		{color.NRGBA{51, 102, 204, 255}, []float64{0.2, 0.4, 0.8, 1}},
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	want := newTensor(3, 2)
+	for i, c := range colors {
+		img.SetNRGBA(i%3, i/3, c.c)
+		copy(want[i/3][i%3], c.want)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"bytes reader", bytes.NewReader(data)},
+		{"not seekable", struct{ io.Reader }{bytes.NewReader(data)}},
+		{"one byte at a time", iotest.OneByteReader(bytes.NewReader(data))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor, err := DecodeTensor(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, want, 1.0/255) {
+				t.Errorf("DecodeTensor = %v, want %v", tensor, want)
+			}
+		})
+	}
+}
+
+func TestDecodeTensorInvalid(t *testing.T) {
+	valid := encodeTestPNG(t, gradientTensor(8, 8))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not an image", []byte("hello, world")},
+		{"truncated png", valid[:len(valid)/2]},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tensor, err := DecodeTensor(bytes.NewReader(tt.data)); err == nil {
+				t.Errorf("DecodeTensor returned a %d-row tensor and no error", len(tensor))
+			}
+		})
+	}
+}
+
+func TestDecodeTensorOrientation(t *testing.T) {
+	// A blue 48x32 image with a green 16x16 block in its top left corner,
+	// centered on (8, 8).
+	const width, height = 48, 32
+	source := solidTensor(width, height, [4]float64{0, 0, 1, 1})
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			copy(source[y][x], []float64{0, 1, 0, 1})
+		}
+	}
+	stored := encodeTestJPEG(t, source)
+
+	tests := []struct {
+		name                  string
+		orientation           int
+		wantWidth, wantHeight int
+		// greenX and greenY locate the center of the green block.
+		greenX, greenY int
+	}{
+		{"upright", 1, width, height, 8, 8},
+		{"mirrored", 2, width, height, 39, 8},
+		{"upside down", 3, width, height, 39, 23},
+		{"rotated clockwise", 6, height, width, 23, 8},
+		{"rotated counterclockwise", 8, height, width, 8, 39},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := embedJPEGMetadata(stored, Metadata{EXIF: orientationEXIF(tt.orientation)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tensor, err := DecodeTensor(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(tensor); w != tt.wantWidth || h != tt.wantHeight {
+				t.Fatalf("dimensions = %dx%d, want %dx%d", w, h, tt.wantWidth, tt.wantHeight)
+			}
+			if p := tensor[tt.greenY][tt.greenX]; p[1] < 0.9 || p[2] > 0.1 {
+				t.Errorf("pixel (%d, %d) = %v, want green", tt.greenX, tt.greenY, p)
+			}
+			// The point opposite the block, through the center, is blue.
+			mx, my := tt.wantWidth-1-tt.greenX, tt.wantHeight-1-tt.greenY
+			if p := tensor[my][mx]; p[2] < 0.9 || p[1] > 0.1 {
+				t.Errorf("pixel (%d, %d) = %v, want blue", mx, my, p)
+			}
+		})
+	}
+}
+
+func TestDecodeTensorBMPAndTIFF(t *testing.T) {
+	reference, err := png.Decode(bytes.NewReader(readTestdata(t, "video-001.png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ImageToTensor(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"bmp", "video-001.bmp"},
+		{"tiff", "video-001.tiff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := readTestdata(t, tt.file)
+			if got := detectFormat(data); got != tt.name {
+				t.Errorf("detectFormat = %q, want %q", got, tt.name)
+			}
+			tensor, err := DecodeTensor(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, want, 0) {
+				t.Error("decoded pixels differ from the PNG reference")
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"jpeg", "\xFF\xD8\xFF\xE0", "jpeg"},
+		{"png", "\x89PNG\r\n\x1a\n", "png"},
+		{"bmp", "BM6\x00\x00\x00", "bmp"},
+		{"little-endian tiff", "II*\x00\x08\x00", "tiff"},
+		{"big-endian tiff", "MM\x00*\x00\x00", "tiff"},
+		{"webp", "RIFF\x24\x00\x00\x00WEBPVP8L", "webp"},
+		{"riff but not webp", "RIFF\x24\x00\x00\x00WAVEfmt ", ""},
+		{"gif", "GIF89a", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectFormat([]byte(tt.header)); got != tt.want {
+				t.Errorf("detectFormat(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTensorWebP(t *testing.T) {
+	reference, err := png.Decode(bytes.NewReader(readTestdata(t, "gopher-doc.8bpp.png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ImageToTensor(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := readTestdata(t, "gopher-doc.8bpp.lossless.webp")
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"bytes reader", bytes.NewReader(data)},
+		{"one byte at a time", iotest.OneByteReader(bytes.NewReader(data))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor, err := DecodeTensor(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, want, 0) {
+				t.Error("decoded pixels differ from the PNG reference")
+			}
+		})
+	}
+}
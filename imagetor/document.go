@@ -0,0 +1,380 @@
+package imagetor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// documentWorkSize is the longest side the image is reduced to before
+	// DetectDocument searches it for a document.
+	documentWorkSize = 256
+	// documentMinArea is the smallest fraction of the image a region must
+	// cover to be taken for a document.
+	documentMinArea = 0.05
+)
+
+// otsuThreshold returns the threshold that best separates the values of a
+// plane into two classes, maximizing the variance between them.
+func otsuThreshold(plane GrayTensor) float64 {
+	var histogram [256]int
+	total := 0
+	for _, row := range plane {
+		for _, v := range row {
+			histogram[int(clamp(v)*255)]++
+			total++
+		}
+	}
+
+	sum := 0.0
+	for i, n := range histogram {
+		sum += float64(i * n)
+	}
+	best, threshold := -1.0, 0
+	sumBelow, countBelow := 0.0, 0
+	for i, n := range histogram {
+		countBelow += n
+		if countBelow == 0 || countBelow == total {
+			continue
+		}
+		sumBelow += float64(i * n)
+		meanBelow := sumBelow / float64(countBelow)
+		meanAbove := (sum - sumBelow) / float64(total-countBelow)
+		variance := float64(countBelow) * float64(total-countBelow) * (meanBelow - meanAbove) * (meanBelow - meanAbove)
+		if variance > best {
+			best, threshold = variance, i
+		}
+	}
+	return (float64(threshold) + 0.5) / 255
+}
+
+// largestComponent returns the pixels of the largest 4-connected region of
+// a binary mask.
+func largestComponent(mask [][]bool) [][2]int {
+	height, width := len(mask), len(mask[0])
+	visited := make([][]bool, height)
+	for y := range visited {
+		visited[y] = make([]bool, width)
+	}
+
+	var largest [][2]int
+	for sy := 0; sy < height; sy++ {
+		for sx := 0; sx < width; sx++ {
+			if !mask[sy][sx] || visited[sy][sx] {
+				continue
+			}
+			visited[sy][sx] = true
+			component := [][2]int{{sx, sy}}
+			for i := 0; i < len(component); i++ {
+				x, y := component[i][0], component[i][1]
+				for _, d := range [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+					nx, ny := x+d[0], y+d[1]
+					if nx >= 0 && nx < width && ny >= 0 && ny < height && mask[ny][nx] && !visited[ny][nx] {
+						visited[ny][nx] = true
+						component = append(component, [2]int{nx, ny})
+					}
+				}
+			}
+			if len(component) > len(largest) {
+				largest = component
+			}
+		}
+	}
+	return largest
+}
+
+// cross returns the z component of the cross product of (a - o) and (b - o).
+func cross(o, a, b [2]float64) float64 {
+	return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+}
+
+// convexHull returns the convex hull of a set of points in counterclockwise
+// order, using Andrew's monotone chain algorithm.
+func convexHull(points [][2]float64) [][2]float64 {
+	sort.Slice(points, func(i, j int) bool {
+		if points[i][0] != points[j][0] {
+			return points[i][0] < points[j][0]
+		}
+		return points[i][1] < points[j][1]
+	})
+	hull := make([][2]float64, 0, 2*len(points))
+	for pass := 0; pass < 2; pass++ {
+		start := len(hull)
+		for _, p := range points {
+			for len(hull) >= start+2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+				hull = hull[:len(hull)-1]
+			}
+			hull = append(hull, p)
+		}
+		hull = hull[:len(hull)-1]
+		for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+			points[i], points[j] = points[j], points[i]
+		}
+	}
+	return hull
+}
+
+// quadArea returns the area of a quadrilateral with the shoelace formula.
+func quadArea(q [4][2]float64) float64 {
+	area := 0.0
+	for i := range q {
+		j := (i + 1) % 4
+		area += q[i][0]*q[j][1] - q[j][0]*q[i][1]
+	}
+	return math.Abs(area) / 2
+}
+
+// largestQuad returns the quadrilateral of largest area whose corners are
+// vertices of a convex hull, ordered top-left, top-right, bottom-right and
+// bottom-left. It starts from the extreme points along the diagonals and moves
+// one corner at a time while that enlarges the area.
+func largestQuad(hull [][2]float64) [4][2]float64 {
+	var quad [4][2]float64
+	// Score functions picking the top-left, top-right, bottom-right and
+	// bottom-left extremes.
+	scores := [4]func(p [2]float64) float64{
+		func(p [2]float64) float64 { return -p[0] - p[1] },
+		func(p [2]float64) float64 { return p[0] - p[1] },
+		func(p [2]float64) float64 { return p[0] + p[1] },
+		func(p [2]float64) float64 { return -p[0] + p[1] },
+	}
+	for i, score := range scores {
+		quad[i] = hull[0]
+		for _, p := range hull {
+			if score(p) > score(quad[i]) {
+				quad[i] = p
+			}
+		}
+	}
+
+	for improved := true; improved; {
+		improved = false
+		for i := range quad {
+			for _, p := range hull {
+				candidate := quad
+				candidate[i] = p
+				if quadArea(candidate) > quadArea(quad)+1e-9 {
+					quad, improved = candidate, true
+				}
+			}
+		}
+	}
+	return quad
+}
+
+// solveLinear solves the square linear system a*x = b by Gaussian elimination
+// with partial pivoting. It reports false when the system is singular.
+func solveLinear(a [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for row := col + 1; row < n; row++ {
+			f := a[row][col] / a[col][col]
+			for k := col; k < n; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+			b[row] -= f * b[col]
+		}
+	}
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, true
+}
+
+// warpQuad maps the quadrilateral with the given corners, ordered top-left,
+// top-right, bottom-right and bottom-left, onto a width x height rectangle
+// with a perspective transform, sampling the source bilinearly.
+func warpQuad(tensor [][][]float64, corners [4][2]float64, width, height int) ([][][]float64, error) {
+	// Solve for the homography taking rectangle corners to the quad corners.
+	rect := [4][2]float64{{0, 0}, {float64(width - 1), 0}, {float64(width - 1), float64(height - 1)}, {0, float64(height - 1)}}
+	a := make([][]float64, 8)
+	b := make([]float64, 8)
+	for i := 0; i < 4; i++ {
+		u, v := rect[i][0], rect[i][1]
+		x, y := corners[i][0], corners[i][1]
+		a[2*i] = []float64{u, v, 1, 0, 0, 0, -u * x, -v * x}
+		a[2*i+1] = []float64{0, 0, 0, u, v, 1, -u * y, -v * y}
+		b[2*i], b[2*i+1] = x, y
+	}
+	h, ok := solveLinear(a, b)
+	if !ok {
+		return nil, fmt.Errorf("document corners are degenerate")
+	}
+
+	srcHeight, srcWidth, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+	result := newTensor(width, height)
+	if err := parallelRows(height, func(start, end int) {
+		for v := start; v < end; v++ {
+			for u := 0; u < width; u++ {
+				fu, fv := float64(u), float64(v)
+				w := h[6]*fu + h[7]*fv + 1
+				x := (h[0]*fu + h[1]*fv + h[2]) / w
+				y := (h[3]*fu + h[4]*fv + h[5]) / w
+				x = math.Max(0, math.Min(float64(srcWidth-1), x))
+				y = math.Max(0, math.Min(float64(srcHeight-1), y))
+
+				x0, y0 := int(x), int(y)
+				x1, y1 := min(x0+1, srcWidth-1), min(y0+1, srcHeight-1)
+				dx, dy := x-float64(x0), y-float64(y0)
+				for c := 0; c < channels; c++ {
+					result[v][u][c] = (1-dx)*(1-dy)*tensor[y0][x0][c] + dx*(1-dy)*tensor[y0][x1][c] +
+						(1-dx)*dy*tensor[y1][x0][c] + dx*dy*tensor[y1][x1][c]
+				}
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DetectDocument finds a document, such as a sheet of paper, photographed
+// against a contrasting background, and returns its corners without modifying
+// the image, for example to draw them for the user to adjust before calling
+// WarpDocument.
+//
+// The luminance is split into light and dark with Otsu's threshold. The class
+// covering less of the image border is taken for the document, and its largest
+// connected region is located. The largest quadrilateral fitting the convex
+// hull of that region gives the corners of the document.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//
+// Returns:
+//
+//	The corners of the document as [x, y] pixel coordinates, ordered
+//	top-left, top-right, bottom-right and bottom-left, or an error if no
+//	document-like region is found.
+func DetectDocument(tensor [][][]float64) ([4][2]float64, error) {
+	var corners [4][2]float64
+	height, width, err := dimsOf(tensor)
+	if err != nil {
+		return corners, err
+	}
+
+	// Search a reduced copy; the corners are scaled back afterwards.
+	scale := math.Min(1, float64(documentWorkSize)/float64(max(width, height)))
+	workWidth := max(1, int(math.Round(float64(width)*scale)))
+	workHeight := max(1, int(math.Round(float64(height)*scale)))
+	work := tensor
+	if workWidth != width || workHeight != height {
+		if work, err = resizeArea(work, workWidth, workHeight); err != nil {
+			return corners, err
+		}
+	}
+	plane := luminancePlane(work)
+	threshold := otsuThreshold(plane)
+
+	// Pick the class that covers less of the border as the document.
+	borderLight, border := 0, 0
+	for y := 0; y < workHeight; y++ {
+		for x := 0; x < workWidth; x++ {
+			if y == 0 || x == 0 || y == workHeight-1 || x == workWidth-1 {
+				border++
+				if plane[y][x] > threshold {
+					borderLight++
+				}
+			}
+		}
+	}
+	documentLight := 2*borderLight < border
+
+	mask := make([][]bool, workHeight)
+	for y := range mask {
+		mask[y] = make([]bool, workWidth)
+		for x := range mask[y] {
+			mask[y][x] = (plane[y][x] > threshold) == documentLight
+		}
+	}
+	component := largestComponent(mask)
+	if float64(len(component)) < documentMinArea*float64(workWidth*workHeight) {
+		return corners, fmt.Errorf("no document found")
+	}
+
+	// Scale the pixel centers of the region back to the original image.
+	sx, sy := float64(width)/float64(workWidth), float64(height)/float64(workHeight)
+	points := make([][2]float64, len(component))
+	for i, p := range component {
+		points[i] = [2]float64{(float64(p[0])+0.5)*sx - 0.5, (float64(p[1])+0.5)*sy - 0.5}
+	}
+	hull := convexHull(points)
+	if len(hull) < 4 {
+		return corners, fmt.Errorf("no document found")
+	}
+	return largestQuad(hull), nil
+}
+
+// WarpDocument replaces the image with a flattened, front-on view of the
+// quadrilateral with the given corners, as found by DetectDocument.
+//
+// A perspective transform maps the corners onto a rectangle sized by the
+// average lengths of the opposite edges.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	corners: The corners of the document as [x, y] pixel coordinates, ordered
+//	  top-left, top-right, bottom-right and bottom-left.
+//
+// Returns:
+//
+//	An error if the image is empty or ragged or the corners are degenerate,
+//	in which case the image is left unchanged.
+func WarpDocument(tensor *[][][]float64, corners [4][2]float64) error {
+	edge := func(a, b [2]float64) float64 { return math.Hypot(b[0]-a[0], b[1]-a[1]) }
+	outWidth := int(math.Round((edge(corners[0], corners[1])+edge(corners[3], corners[2]))/2)) + 1
+	outHeight := int(math.Round((edge(corners[0], corners[3])+edge(corners[1], corners[2]))/2)) + 1
+	flat, err := warpQuad(*tensor, corners, outWidth, outHeight)
+	if err != nil {
+		return err
+	}
+	*tensor = flat
+	return nil
+}
+
+// AutoCropDocument finds a document, such as a sheet of paper, photographed
+// against a contrasting background, and replaces the image with a flattened,
+// front-on view of it, as a scanner app does.
+//
+// It is DetectDocument followed by WarpDocument; call those separately to
+// only locate the document, or to let the user adjust the corners first.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//
+// Returns:
+//
+//	The corners of the document in the original image as [x, y] pixel
+//	coordinates, ordered top-left, top-right, bottom-right and bottom-left,
+//	or an error if no document-like region is found, in which case the image
+//	is left unchanged.
+func AutoCropDocument(tensor *[][][]float64) ([4][2]float64, error) {
+	corners, err := DetectDocument(*tensor)
+	if err != nil {
+		return corners, err
+	}
+	return corners, WarpDocument(tensor, corners)
+}
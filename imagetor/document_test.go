@@ -0,0 +1,94 @@
+package imagetor
+
+import (
+	"math"
+	"testing"
+)
+
+// sheetTensor returns a photo-like image of a sheet with the given corners
+// against a noisy background: light on dark, or dark on light.
+func sheetTensor(width, height int, corners [4][2]int, lightSheet bool) [][][]float64 {
+	mask := PolygonMask(width, height, corners[:])
+	sheet, background := 0.9, 0.2
+	if !lightSheet {
+		sheet, background = background, sheet
+	}
+	tensor := newTensor(width, height)
+	for y, row := range tensor {
+		for x, p := range row {
+			noise := 0.03 * float64((x*7+y*13)%5-2)
+			v := background + noise
+			if mask[y][x] > 0 {
+				v = sheet + noise
+			}
+			p[0], p[1], p[2], p[3] = v, v, v*0.95, 1
+		}
+	}
+	return tensor
+}
+
+func TestDetectDocument(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		corners       [4][2]int
+		lightSheet    bool
+		tolerance     float64
+	}{
+		{"upright sheet", 120, 90, [4][2]int{{20, 15}, {100, 15}, {100, 75}, {20, 75}}, true, 2},
+		{"tilted sheet", 120, 120, [4][2]int{{30, 10}, {110, 35}, {90, 110}, {10, 85}}, true, 2},
+		{"dark sheet on a light desk", 100, 80, [4][2]int{{15, 20}, {80, 10}, {85, 70}, {20, 65}}, false, 2},
+		{"large photo", 600, 400, [4][2]int{{100, 50}, {520, 80}, {500, 360}, {80, 330}}, true, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := sheetTensor(tt.width, tt.height, tt.corners, tt.lightSheet)
+			got, err := DetectDocument(tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range tt.corners {
+				if d := math.Hypot(got[i][0]-float64(want[0]), got[i][1]-float64(want[1])); d > tt.tolerance {
+					t.Errorf("corner %d = %v, want %v within %v pixels", i, got[i], want, tt.tolerance)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectDocumentNotFound(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+	}{
+		{"blank", solidTensor(40, 30, [4]float64{0.5, 0.5, 0.5, 1})},
+		{"tiny speck", sheetTensor(100, 100, [4][2]int{{50, 50}, {53, 50}, {53, 53}, {50, 53}}, true)},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if corners, err := DetectDocument(tt.tensor); err == nil {
+				t.Errorf("DetectDocument = %v, want an error", corners)
+			}
+		})
+	}
+}
+
+func TestAutoCropDocument(t *testing.T) {
+	tensor := sheetTensor(120, 90, [4][2]int{{20, 15}, {100, 15}, {100, 75}, {20, 75}}, true)
+	if _, err := AutoCropDocument(&tensor); err != nil {
+		t.Fatal(err)
+	}
+	w, h, _ := Dimensions(tensor)
+	if math.Abs(float64(w)-81) > 3 || math.Abs(float64(h)-61) > 3 {
+		t.Errorf("flattened sheet is %dx%d, want about 81x61", w, h)
+	}
+	// The flattened view holds only the light sheet.
+	for y := 2; y < h-2; y++ {
+		for x := 2; x < w-2; x++ {
+			if v := tensor[y][x][0]; v < 0.8 {
+				t.Fatalf("pixel (%d, %d) = %v is not part of the sheet", x, y, v)
+			}
+		}
+	}
+}
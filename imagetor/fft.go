@@ -0,0 +1,355 @@
+package imagetor
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// FilterKind selects the frequency response applied by FFTFilter.
+type FilterKind int
+
+const (
+	// LowPassIdeal keeps frequencies up to the cutoff and removes all others.
+	// Its sharp transition causes ringing next to strong edges.
+	LowPassIdeal FilterKind = iota
+	// LowPassGaussian attenuates frequencies smoothly with a Gaussian response
+	// whose standard deviation is the cutoff, without ringing.
+	LowPassGaussian
+	// HighPassIdeal removes frequencies up to the cutoff and keeps all others.
+	HighPassIdeal
+	// HighPassGaussian is the complement of LowPassGaussian.
+	HighPassGaussian
+)
+
+// nextPowerOfTwo returns the smallest power of two that is at least n.
+func nextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// fft computes the discrete Fourier transform of a, whose length must be a
+// power of two, in place. The inverse transform includes the 1/n scaling.
+func fft(a []complex128, inverse bool) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := a[start+k], a[start+k+size/2]*w
+				a[start+k], a[start+k+size/2] = even+odd, even-odd
+				w *= step
+			}
+		}
+	}
+
+	if inverse {
+		for i := range a {
+			a[i] /= complex(float64(n), 0)
+		}
+	}
+}
+
+// fft2D computes the 2D discrete Fourier transform of a grid, whose dimensions
+// must be powers of two, in place.
+func fft2D(grid [][]complex128, inverse bool) error {
+	height, width := len(grid), len(grid[0])
+
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			fft(grid[y], inverse)
+		}
+	}); err != nil {
+		return err
+	}
+
+	return parallelRows(width, func(start, end int) {
+		column := make([]complex128, height)
+		for x := start; x < end; x++ {
+			for y := 0; y < height; y++ {
+				column[y] = grid[y][x]
+			}
+			fft(column, inverse)
+			for y := 0; y < height; y++ {
+				grid[y][x] = column[y]
+			}
+		}
+	})
+}
+
+// spectrum returns the 2D Fourier transform of a width x height plane whose
+// values are given by value, padded to power-of-two dimensions by repeating
+// the border values.
+func spectrum(width, height int, value func(x, y int) float64) ([][]complex128, error) {
+	paddedHeight, paddedWidth := nextPowerOfTwo(height), nextPowerOfTwo(width)
+
+	grid := make([][]complex128, paddedHeight)
+	for y := range grid {
+		grid[y] = make([]complex128, paddedWidth)
+		for x := range grid[y] {
+			grid[y][x] = complex(value(clampIndex(x, width), clampIndex(y, height)), 0)
+		}
+	}
+	if err := fft2D(grid, false); err != nil {
+		return nil, err
+	}
+	return grid, nil
+}
+
+// channelSpectrum returns the 2D Fourier transform of one channel of a tensor,
+// padded to power-of-two dimensions by repeating the border pixels.
+func channelSpectrum(tensor [][][]float64, c int) ([][]complex128, error) {
+	height, width, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+	return spectrum(width, height, func(x, y int) float64 {
+		return tensor[y][x][c]
+	})
+}
+
+// setChannelFromSpectrum inverts a spectrum produced by channelSpectrum and
+// writes the real part of the result, cropped to the tensor, into channel c.
+func setChannelFromSpectrum(tensor [][][]float64, c int, grid [][]complex128) error {
+	if err := fft2D(grid, true); err != nil {
+		return err
+	}
+	for y, row := range tensor {
+		for x, pixel := range row {
+			pixel[c] = real(grid[y][x])
+		}
+	}
+	return nil
+}
+
+// frequencyRadius returns the distance of the frequency at (u, v) in a
+// spectrum of the given size from the zero frequency, normalized so that the
+// Nyquist frequency along either axis is 1.
+func frequencyRadius(u, v, width, height int) float64 {
+	fu, fv := float64(u)/float64(width), float64(v)/float64(height)
+	if u >= width/2 {
+		fu -= 1
+	}
+	if v >= height/2 {
+		fv -= 1
+	}
+	return math.Hypot(fu, fv) / 0.5
+}
+
+// FFTFilter filters the image in the frequency domain.
+//
+// Each RGB channel is transformed with a 2D fast Fourier transform, multiplied
+// by the frequency response selected by kind and transformed back. Low-pass
+// filters blur the image at a cost independent of the blur radius, and
+// high-pass filters extract fine detail. High-pass results are centered on 0
+// and are not clamped; use Rescale to view them. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	kind: The frequency response to apply.
+//	cutoff: The cutoff frequency, as a fraction of the Nyquist frequency
+//	  (one cycle per two pixels), from 0 to 1.
+//
+// Returns:
+//
+//	An error if a worker panics, which may leave the image partly filtered.
+func FFTFilter(tensor *[][][]float64, kind FilterKind, cutoff float64) error {
+	width, height, _ := Dimensions(*tensor)
+	if width == 0 || height == 0 {
+		return nil
+	}
+
+	for c := 0; c < 3; c++ {
+		grid, err := channelSpectrum(*tensor, c)
+		if err != nil {
+			return err
+		}
+		height, width := len(grid), len(grid[0])
+		for v := 0; v < height; v++ {
+			for u := 0; u < width; u++ {
+				r := frequencyRadius(u, v, width, height)
+				var gain float64
+				switch kind {
+				case LowPassIdeal, HighPassIdeal:
+					if r <= cutoff {
+						gain = 1
+					}
+				case LowPassGaussian, HighPassGaussian:
+					if cutoff > 0 {
+						gain = math.Exp(-r * r / (2 * cutoff * cutoff))
+					}
+				}
+				if kind == HighPassIdeal || kind == HighPassGaussian {
+					gain = 1 - gain
+				}
+				grid[v][u] *= complex(gain, 0)
+			}
+		}
+		if err := setChannelFromSpectrum(*tensor, c, grid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parameters of the spectral peak detection in RemovePeriodicNoise.
+const (
+	// noiseMinRadius excludes the low frequencies, where the energy of the
+	// image content itself is concentrated, from peak detection.
+	noiseMinRadius = 0.08
+	// noiseWindow is the radius, in frequency bins, of the neighborhood a peak
+	// is compared against.
+	noiseWindow = 4
+	// noisePeakRatio is how many times stronger than its neighborhood average
+	// a frequency must be to count as a peak.
+	noisePeakRatio = 8.0
+	// noiseNotchSigma is the standard deviation, in frequency bins, of the
+	// Gaussian notch placed on each peak.
+	noiseNotchSigma = 1.5
+)
+
+// RemovePeriodicNoise removes periodic interference, such as moire from
+// scanned halftone prints or electrical interference stripes, by notch
+// filtering its peaks out of the frequency spectrum.
+//
+// Periodic patterns show up as isolated strong peaks away from the center of
+// the spectrum. The peaks are located on the spectrum of the luminance, and a
+// Gaussian notch is placed on each of them and on its mirrored frequency in
+// every RGB channel. Image detail, which spreads its energy smoothly across the
+// spectrum, is left largely intact. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	autoDetect: When true, every frequency standing out from its neighborhood
+//	  is notched. When false, only the single strongest peak is, which is
+//	  safer for images known to carry one interference pattern.
+//
+// Returns:
+//
+//	An error if a worker panics, which may leave the image partly filtered.
+func RemovePeriodicNoise(tensor *[][][]float64, autoDetect bool) error {
+	srcWidth, srcHeight, _ := Dimensions(*tensor)
+	if srcWidth == 0 || srcHeight == 0 {
+		return nil
+	}
+
+	lum, err := spectrum(srcWidth, srcHeight, func(x, y int) float64 {
+		p := (*tensor)[y][x]
+		return luminance(p[0], p[1], p[2])
+	})
+	if err != nil {
+		return err
+	}
+	height, width := len(lum), len(lum[0])
+
+	magnitude := make([][]float64, height)
+	for v := range magnitude {
+		magnitude[v] = make([]float64, width)
+		for u := range magnitude[v] {
+			magnitude[v][u] = cmplx.Abs(lum[v][u])
+		}
+	}
+	at := func(u, v int) float64 {
+		return magnitude[(v%height+height)%height][(u%width+width)%width]
+	}
+
+	type peak struct {
+		u, v     int
+		strength float64
+	}
+	var peaks []peak
+	for v := 0; v < height; v++ {
+		for u := 0; u < width; u++ {
+			m := magnitude[v][u]
+			if m == 0 || frequencyRadius(u, v, width, height) < noiseMinRadius {
+				continue
+			}
+
+			sum, count, isMax := 0.0, 0, true
+			for dv := -noiseWindow; dv <= noiseWindow && isMax; dv++ {
+				for du := -noiseWindow; du <= noiseWindow; du++ {
+					if du == 0 && dv == 0 {
+						continue
+					}
+					n := at(u+du, v+dv)
+					if n > m {
+						isMax = false
+						break
+					}
+					sum += n
+					count++
+				}
+			}
+			if isMax && m > noisePeakRatio*sum/float64(count) {
+				peaks = append(peaks, peak{u, v, m / (sum / float64(count))})
+			}
+		}
+	}
+	if len(peaks) == 0 {
+		return nil
+	}
+	if !autoDetect {
+		strongest := peaks[0]
+		for _, p := range peaks {
+			if p.strength > strongest.strength {
+				strongest = p
+			}
+		}
+		peaks = []peak{strongest}
+	}
+
+	// The response is 1 everywhere except in the notches around each peak and
+	// around its mirrored frequency (-u, -v).
+	gain := make([][]float64, height)
+	for v := range gain {
+		gain[v] = make([]float64, width)
+		for u := range gain[v] {
+			gain[v][u] = 1
+			for _, p := range peaks {
+				for _, sign := range []int{1, -1} {
+					du := (u - sign*p.u) % width
+					dv := (v - sign*p.v) % height
+					du = min((du+width)%width, (width-du)%width)
+					dv = min((dv+height)%height, (height-dv)%height)
+					gain[v][u] *= 1 - math.Exp(-float64(du*du+dv*dv)/(2*noiseNotchSigma*noiseNotchSigma))
+				}
+			}
+		}
+	}
+
+	for c := 0; c < 3; c++ {
+		grid, err := channelSpectrum(*tensor, c)
+		if err != nil {
+			return err
+		}
+		for v := range grid {
+			for u := range grid[v] {
+				grid[v][u] *= complex(gain[v][u], 0)
+			}
+		}
+		if err := setChannelFromSpectrum(*tensor, c, grid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
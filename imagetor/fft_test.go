@@ -0,0 +1,109 @@
+package imagetor
+
+import (
+	"math"
+	"testing"
+)
+
+// patternedTensor returns a size x size opaque gray image holding a smooth
+// coarse wave, one period across the image, with a pattern of the given
+// amplitude overlaid. Either part may be zero.
+func patternedTensor(size int, coarse float64, pattern func(x, y int) float64) [][][]float64 {
+	tensor := newTensor(size, size)
+	for y, row := range tensor {
+		for x, p := range row {
+			v := 0.5 + coarse*math.Sin(2*math.Pi*float64(x)/float64(size))
+			if pattern != nil {
+				v += pattern(x, y)
+			}
+			p[0], p[1], p[2], p[3] = v, v, v, 1
+		}
+	}
+	return tensor
+}
+
+// rmsDiff returns the root mean square difference between the red channels
+// of two tensors over the pixels at least margin pixels from the border.
+func rmsDiff(a, b [][][]float64, margin int) float64 {
+	sum, count := 0.0, 0
+	for y := margin; y < len(a)-margin; y++ {
+		for x := margin; x < len(a[y])-margin; x++ {
+			d := a[y][x][0] - b[y][x][0]
+			sum += d * d
+			count++
+		}
+	}
+	return math.Sqrt(sum / float64(count))
+}
+
+func TestFFTFilter(t *testing.T) {
+	const size = 64
+	checker := func(x, y int) float64 { return 0.1 * float64(1-2*((x+y)%2)) }
+	noisy := patternedTensor(size, 0.3, checker)
+	coarse := patternedTensor(size, 0.3, nil)
+	fine := patternedTensor(size, 0, checker)
+	for _, row := range fine {
+		for _, p := range row {
+			p[0], p[1], p[2] = p[0]-0.5, p[1]-0.5, p[2]-0.5
+		}
+	}
+
+	tests := []struct {
+		name   string
+		kind   FilterKind
+		cutoff float64
+		want   [][][]float64
+	}{
+		{"ideal low-pass keeps the coarse wave", LowPassIdeal, 0.3, coarse},
+		{"gaussian low-pass keeps the coarse wave", LowPassGaussian, 0.2, coarse},
+		{"ideal high-pass keeps the pattern", HighPassIdeal, 0.3, fine},
+		{"gaussian high-pass keeps the pattern", HighPassGaussian, 0.2, fine},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := cloneTensor(noisy)
+			if err := FFTFilter(&tensor, tt.kind, tt.cutoff); err != nil {
+				t.Fatal(err)
+			}
+			before := rmsDiff(noisy, tt.want, 4)
+			if after := rmsDiff(tensor, tt.want, 4); after > 0.2*before {
+				t.Errorf("distance from the expected image went from %v to %v", before, after)
+			}
+			if tensor[size/2][size/2][3] != 1 {
+				t.Errorf("alpha changed to %v", tensor[size/2][size/2][3])
+			}
+		})
+	}
+}
+
+func TestRemovePeriodicNoise(t *testing.T) {
+	const size = 64
+	tests := []struct {
+		name       string
+		autoDetect bool
+		noise      func(x, y int) float64
+	}{
+		{"vertical stripes", false, func(x, y int) float64 {
+			return 0.1 * math.Sin(2*math.Pi*float64(x)/4)
+		}},
+		{"diagonal stripes", false, func(x, y int) float64 {
+			return 0.1 * math.Sin(2*math.Pi*float64(x+y)/8)
+		}},
+		{"two patterns", true, func(x, y int) float64 {
+			return 0.08*math.Sin(2*math.Pi*float64(x)/4) + 0.08*math.Sin(2*math.Pi*float64(y)/8)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clean := patternedTensor(size, 0.3, nil)
+			tensor := patternedTensor(size, 0.3, tt.noise)
+			before := rmsDiff(tensor, clean, 4)
+			if err := RemovePeriodicNoise(&tensor, tt.autoDetect); err != nil {
+				t.Fatal(err)
+			}
+			if after := rmsDiff(tensor, clean, 4); after > 0.3*before {
+				t.Errorf("distance from the clean image went from %v to %v", before, after)
+			}
+		})
+	}
+}
@@ -0,0 +1,620 @@
+package imagetor
+
+import (
+	"math"
+	"sort"
+)
+
+// clampIndex limits an index to [0, n-1], so that samples taken past the edge
+// of an image repeat its border pixels.
+func clampIndex(i, n int) int {
+	return max(0, min(n-1, i))
+}
+
+// luminancePlane returns the luminance of every pixel of a tensor.
+func luminancePlane(tensor [][][]float64) GrayTensor {
+	plane := make(GrayTensor, len(tensor))
+	for y, row := range tensor {
+		plane[y] = make([]float64, len(row))
+		for x, pixel := range row {
+			plane[y][x] = luminance(pixel[0], pixel[1], pixel[2])
+		}
+	}
+	return plane
+}
+
+// sobel returns the gradient magnitude sqrt(gx² + gy²) of a plane using the
+// 3x3 Sobel operators, repeating border pixels at the edges. A step from 0 to 1
+// has a magnitude of 4.
+func sobel(plane GrayTensor) (GrayTensor, error) {
+	height, width := len(plane), len(plane[0])
+	magnitude := make(GrayTensor, height)
+	for y := range magnitude {
+		magnitude[y] = make([]float64, width)
+	}
+
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			up, down := plane[clampIndex(y-1, height)], plane[clampIndex(y+1, height)]
+			row := plane[y]
+			for x := 0; x < width; x++ {
+				left, right := clampIndex(x-1, width), clampIndex(x+1, width)
+				gx := (up[right] + 2*row[right] + down[right]) - (up[left] + 2*row[left] + down[left])
+				gy := (down[left] + 2*down[x] + down[right]) - (up[left] + 2*up[x] + up[right])
+				magnitude[y][x] = math.Hypot(gx, gy)
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return magnitude, nil
+}
+
+// posterize quantizes each RGB channel to the given number of evenly spaced
+// levels between 0 and 1.
+func posterize(tensor [][][]float64, levels int) {
+	steps := float64(max(2, levels) - 1)
+	for _, row := range tensor {
+		for _, pixel := range row {
+			for c := 0; c < 3; c++ {
+				pixel[c] = math.Round(clamp(pixel[c])*steps) / steps
+			}
+		}
+	}
+}
+
+// Posterize quantizes each RGB channel to the given number of evenly spaced
+// levels between 0 and 1, giving the image a banded, poster-like look.
+//
+// Two levels turn every channel fully on or off. With more than 256 levels the
+// steps are finer than those of an 8-bit image, which is left effectively
+// unchanged. Values are clamped to [0, 1] first. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	levels: The number of levels per channel; values below 2 are taken as 2.
+func Posterize(tensor *[][][]float64, levels int) {
+	posterize(*tensor, levels)
+}
+
+// bilateral smooths a tensor while preserving edges: each pixel becomes the
+// average of its neighborhood weighted both by spatial distance (sigmaSpace,
+// in pixels) and by color difference (sigmaColor), so that pixels across a
+// strong edge contribute almost nothing.
+func bilateral(tensor [][][]float64, radius int, sigmaSpace, sigmaColor float64) ([][][]float64, error) {
+	height, width := len(tensor), len(tensor[0])
+	result := newTensor(width, height)
+
+	spatial := make([]float64, (2*radius+1)*(2*radius+1))
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			spatial[(dy+radius)*(2*radius+1)+dx+radius] = math.Exp(-float64(dx*dx+dy*dy) / (2 * sigmaSpace * sigmaSpace))
+		}
+	}
+
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				center := tensor[y][x]
+				var sum [3]float64
+				total := 0.0
+				for dy := -radius; dy <= radius; dy++ {
+					for dx := -radius; dx <= radius; dx++ {
+						p := tensor[clampIndex(y+dy, height)][clampIndex(x+dx, width)]
+						dr, dg, db := p[0]-center[0], p[1]-center[1], p[2]-center[2]
+						w := spatial[(dy+radius)*(2*radius+1)+dx+radius] *
+							math.Exp(-(dr*dr+dg*dg+db*db)/(2*sigmaColor*sigmaColor))
+						sum[0] += w * p[0]
+						sum[1] += w * p[1]
+						sum[2] += w * p[2]
+						total += w
+					}
+				}
+				for c := 0; c < 3; c++ {
+					result[y][x][c] = sum[c] / total
+				}
+				result[y][x][3] = center[3]
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Cartoonize gives the image a comic-book look with flat colors and dark outlines.
+//
+// The image is first smoothed with an edge-preserving bilateral filter, then
+// each RGB channel is posterized to the given number of levels. Finally, pixels
+// are darkened in proportion to the luminance gradient of the smoothed image,
+// drawing dark outlines along the edges between the flat color regions. Alpha
+// is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	levels: The number of levels per color channel, at least 2.
+//	edgeStrength: How strongly edges are darkened; 0 disables the outlines and
+//	  1 turns the strongest edges black.
+//
+// Returns:
+//
+//	An error if a worker panics, in which case the image is left unchanged.
+func Cartoonize(tensor *[][][]float64, levels int, edgeStrength float64) error {
+	width, height, _ := Dimensions(*tensor)
+	if width == 0 || height == 0 {
+		return nil
+	}
+
+	smoothed, err := bilateral(*tensor, 2, 2.0, 0.1)
+	if err != nil {
+		return err
+	}
+	edges, err := sobel(luminancePlane(smoothed))
+	if err != nil {
+		return err
+	}
+	posterize(smoothed, levels)
+
+	for y, row := range smoothed {
+		for x, pixel := range row {
+			darken := 1 - clamp(edgeStrength*edges[y][x]/4)
+			for c := 0; c < 3; c++ {
+				pixel[c] *= darken
+			}
+		}
+	}
+	*tensor = smoothed
+	return nil
+}
+
+// gaussianKernel returns a normalized 1D Gaussian kernel of 2*radius+1 taps.
+func gaussianKernel(radius int, sigma float64) []float64 {
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := -radius; i <= radius; i++ {
+		kernel[i+radius] = math.Exp(-float64(i*i) / (2 * sigma * sigma))
+		sum += kernel[i+radius]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	return kernel
+}
+
+// gaussianBlur returns a blurred copy of a tensor, applying a separable
+// Gaussian kernel in a horizontal and then a vertical pass. Samples past the
+// edges repeat the border pixels. All four channels are blurred.
+func gaussianBlur(tensor [][][]float64, radius int, sigma float64) ([][][]float64, error) {
+	height, width := len(tensor), len(tensor[0])
+	kernel := gaussianKernel(radius, sigma)
+
+	temp := newTensor(width, height)
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				out := temp[y][x]
+				for k, w := range kernel {
+					p := tensor[y][clampIndex(x+k-radius, width)]
+					for c := 0; c < channels; c++ {
+						out[c] += w * p[c]
+					}
+				}
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	result := newTensor(width, height)
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for k, w := range kernel {
+				row := temp[clampIndex(y+k-radius, height)]
+				for x := 0; x < width; x++ {
+					for c := 0; c < channels; c++ {
+						result[y][x][c] += w * row[x][c]
+					}
+				}
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GaussianBlur blurs the image with a Gaussian kernel.
+//
+// The kernel is separable, so it is applied as a horizontal and then a
+// vertical pass of 2*radius+1 taps each, at a cost that grows linearly with
+// the radius rather than quadratically. Samples past the edges repeat the
+// border pixels. All four channels are blurred, which keeps the premultiplied
+// colors of transparent areas from bleeding into their surroundings.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	radius: The number of pixels the kernel reaches on either side; about
+//	  3*sigma captures the whole kernel. Less than 1 leaves the image unchanged.
+//	sigma: The standard deviation of the kernel, in pixels. 0 or less leaves
+//	  the image unchanged.
+//
+// Returns:
+//
+//	An error if a worker panics, in which case the image is left unchanged.
+func GaussianBlur(tensor *[][][]float64, radius int, sigma float64) error {
+	width, height, _ := Dimensions(*tensor)
+	if width == 0 || height == 0 || radius < 1 || sigma <= 0 {
+		return nil
+	}
+	blurred, err := gaussianBlur(*tensor, radius, sigma)
+	if err != nil {
+		return err
+	}
+	*tensor = blurred
+	return nil
+}
+
+// BoxBlur blurs the image by replacing each pixel with the average of the
+// (2*radius+1) x (2*radius+1) square around it, a cheap approximation of
+// GaussianBlur for previews.
+//
+// The average is applied as a horizontal and then a vertical pass, each
+// keeping a running sum that is updated as the window slides. Each sum is
+// primed once per row or column, so beyond that priming the cost does not
+// depend on the radius. Samples past the edges repeat the border pixels.
+// All four channels are blurred.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	radius: The number of pixels the window reaches on either side. Less than
+//	  1 leaves the image unchanged.
+//
+// Returns:
+//
+//	An error if a worker panics, in which case the image is left unchanged.
+func BoxBlur(tensor *[][][]float64, radius int) error {
+	width, height, _ := Dimensions(*tensor)
+	if width == 0 || height == 0 || radius < 1 {
+		return nil
+	}
+	src := *tensor
+	scale := 1 / float64(2*radius+1)
+
+	temp := newTensor(width, height)
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := src[y]
+			var sum [channels]float64
+			for k := -radius; k <= radius; k++ {
+				for c := 0; c < channels; c++ {
+					sum[c] += row[clampIndex(k, width)][c]
+				}
+			}
+			for x := 0; x < width; x++ {
+				for c := 0; c < channels; c++ {
+					temp[y][x][c] = sum[c] * scale
+				}
+				in, out := row[clampIndex(x+radius+1, width)], row[clampIndex(x-radius, width)]
+				for c := 0; c < channels; c++ {
+					sum[c] += in[c] - out[c]
+				}
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	// The vertical pass splits the columns rather than the rows among the
+	// workers, so each column is primed once and slid down the whole image.
+	result := newTensor(width, height)
+	if err := parallelRows(width, func(start, end int) {
+		sums := make([][channels]float64, end-start)
+		for k := -radius; k <= radius; k++ {
+			row := temp[clampIndex(k, height)]
+			for i := range sums {
+				for c := 0; c < channels; c++ {
+					sums[i][c] += row[start+i][c]
+				}
+			}
+		}
+		for y := 0; y < height; y++ {
+			in, out := temp[clampIndex(y+radius+1, height)], temp[clampIndex(y-radius, height)]
+			for i := range sums {
+				x := start + i
+				for c := 0; c < channels; c++ {
+					result[y][x][c] = sums[i][c] * scale
+					sums[i][c] += in[x][c] - out[x][c]
+				}
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	*tensor = result
+	return nil
+}
+
+// MedianFilter removes noise by replacing each color channel of every pixel
+// with the median of that channel over the (2*radius+1) x (2*radius+1) square
+// around it.
+//
+// Unlike a blur, the median discards isolated outliers such as salt-and-pepper
+// noise entirely, and it keeps edges between flat regions sharp. The channels
+// are filtered independently. Samples past the edges repeat the border pixels.
+// Alpha is copied unchanged.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	radius: The number of pixels the window reaches on either side. Less than
+//	  1 leaves the image unchanged.
+//
+// Returns:
+//
+//	An error if a worker panics, in which case the image is left unchanged.
+func MedianFilter(tensor *[][][]float64, radius int) error {
+	width, height, _ := Dimensions(*tensor)
+	if width == 0 || height == 0 || radius < 1 {
+		return nil
+	}
+	src := *tensor
+
+	result := newTensor(width, height)
+	if err := parallelRows(height, func(start, end int) {
+		window := make([]float64, 0, (2*radius+1)*(2*radius+1))
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				for c := 0; c < 3; c++ {
+					window = window[:0]
+					for dy := -radius; dy <= radius; dy++ {
+						row := src[clampIndex(y+dy, height)]
+						for dx := -radius; dx <= radius; dx++ {
+							window = append(window, row[clampIndex(x+dx, width)][c])
+						}
+					}
+					sort.Float64s(window)
+					result[y][x][c] = window[len(window)/2]
+				}
+				result[y][x][3] = src[y][x][3]
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	*tensor = result
+	return nil
+}
+
+// UnsharpMask sharpens the image by adding back the detail removed by a
+// Gaussian blur: sharp = original + amount*(original - blurred).
+//
+// Sharpening a high-contrast edge pushes the pixels on either side of it past
+// their original values, producing visible light and dark halos. haloSuppress
+// compresses each correction relative to the headroom the pixel has left in
+// the direction of the correction, so that pixels that are already close to
+// white or black receive progressively less of it. Results are clamped to
+// [0, 1]. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	sigma: The standard deviation of the blur, in pixels.
+//	amount: The strength of the sharpening; 0 leaves the image unchanged.
+//	haloSuppress: How strongly corrections are limited by headroom; 0 disables
+//	  suppression and 1 keeps corrections within the available headroom.
+//
+// Returns:
+//
+//	An error if a worker panics, in which case the image is left unchanged.
+func UnsharpMask(tensor *[][][]float64, sigma, amount, haloSuppress float64) error {
+	width, height, _ := Dimensions(*tensor)
+	if width == 0 || height == 0 || sigma <= 0 {
+		return nil
+	}
+
+	blurred, err := gaussianBlur(*tensor, int(math.Ceil(3*sigma)), sigma)
+	if err != nil {
+		return err
+	}
+
+	for y, row := range *tensor {
+		for x, pixel := range row {
+			for c := 0; c < 3; c++ {
+				added := amount * (pixel[c] - blurred[y][x][c])
+				if haloSuppress > 0 && added != 0 {
+					headroom := 1 - pixel[c]
+					if added < 0 {
+						headroom = pixel[c]
+					}
+					headroom = math.Max(headroom, 1e-6)
+					added /= 1 + haloSuppress*math.Abs(added)/headroom
+				}
+				pixel[c] = clamp(pixel[c] + added)
+			}
+		}
+	}
+	return nil
+}
+
+// TonalBlur blurs only the pixels whose luminance falls within a range, for
+// softening noise in the shadows without touching the highlights, or the
+// reverse.
+//
+// Each pixel is blended with a Gaussian-blurred copy of the image, weighted by
+// how deep inside the range its luminance lies: the weight rises from 0 at a
+// bound of the range to 1 a quarter of the range width inside it. Bounds at or
+// beyond 0 and 1 do not fade, so that pure black or white pixels are fully
+// blurred by ranges that include them. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	sigma: The standard deviation of the blur, in pixels.
+//	rangeLow: The lowest luminance blurred, from 0 to 1.
+//	rangeHigh: The highest luminance blurred, from 0 to 1.
+//
+// Returns:
+//
+//	An error if a worker panics, which may leave the image partly blurred.
+func TonalBlur(tensor *[][][]float64, sigma float64, rangeLow, rangeHigh float64) error {
+	width, height, _ := Dimensions(*tensor)
+	if width == 0 || height == 0 || sigma <= 0 || rangeHigh <= rangeLow {
+		return nil
+	}
+
+	blurred, err := gaussianBlur(*tensor, int(math.Ceil(3*sigma)), sigma)
+	if err != nil {
+		return err
+	}
+	fade := (rangeHigh - rangeLow) / 4
+
+	return parallelRows(len(*tensor), func(start, end int) {
+		for y := start; y < end; y++ {
+			for x, pixel := range (*tensor)[y] {
+				lum := luminance(pixel[0], pixel[1], pixel[2])
+				if lum < rangeLow || lum > rangeHigh {
+					continue
+				}
+				depth := math.Inf(1)
+				if rangeLow > 0 {
+					depth = lum - rangeLow
+				}
+				if rangeHigh < 1 {
+					depth = math.Min(depth, rangeHigh-lum)
+				}
+				weight := math.Min(1, depth/fade)
+				for c := 0; c < 3; c++ {
+					pixel[c] += weight * (blurred[y][x][c] - pixel[c])
+				}
+			}
+		}
+	})
+}
+
+// Convolve applies a 3x3 convolution kernel to the image, the building block of
+// many effects such as blurring, sharpening, embossing and edge detection.
+//
+// Each RGB channel of a pixel becomes the sum of its 3x3 neighborhood weighted
+// by the kernel, divided by divisor. Samples past the edges repeat the border
+// pixels. Results are not clamped, so that kernels producing negative values
+// can be inspected with Rescale. Alpha is copied unchanged.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	kernel: The weights, indexed [dy+1][dx+1] for the neighbor at offset
+//	  (dx, dy).
+//	divisor: The value the weighted sums are divided by, usually the sum of
+//	  the weights. 0 is treated as 1.
+//
+// Returns:
+//
+//	An error if a worker panics, in which case the image is left unchanged.
+func Convolve(tensor *[][][]float64, kernel [3][3]float64, divisor float64) error {
+	width, height, _ := Dimensions(*tensor)
+	if width == 0 || height == 0 {
+		return nil
+	}
+	if divisor == 0 {
+		divisor = 1
+	}
+	result := newTensor(width, height)
+
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				out := result[y][x]
+				for ky := 0; ky < 3; ky++ {
+					row := (*tensor)[clampIndex(y+ky-1, height)]
+					for kx := 0; kx < 3; kx++ {
+						w := kernel[ky][kx]
+						p := row[clampIndex(x+kx-1, width)]
+						for c := 0; c < 3; c++ {
+							out[c] += w * p[c]
+						}
+					}
+				}
+				for c := 0; c < 3; c++ {
+					out[c] /= divisor
+				}
+				out[3] = (*tensor)[y][x][3]
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	*tensor = result
+	return nil
+}
+
+// sharpenSigma is the blur radius, in pixels, of the unsharp mask applied by
+// Sharpen, which targets fine detail.
+const sharpenSigma = 1.0
+
+// Sharpen sharpens the image by unsharp masking:
+// sharp = original + amount*(original - blurred).
+//
+// Flat regions are left unchanged, since they do not differ from their blur,
+// while the contrast of edges is increased. This is UnsharpMask with a blur
+// suited to fine detail and no halo suppression. Results are clamped to
+// [0, 1]. Alpha is left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	amount: The strength of the sharpening, from subtle around 0.3 to
+//	  aggressive above 2; 0 leaves the image unchanged.
+//
+// Returns:
+//
+//	An error if a worker panics, in which case the image is left unchanged.
+func Sharpen(tensor *[][][]float64, amount float64) error {
+	return UnsharpMask(tensor, sharpenSigma, amount, 0)
+}
+
+// SobelEdges replaces the image with a grayscale map of its edges.
+//
+// The gradient magnitude sqrt(gx² + gy²) of the luminance is computed with the
+// 3x3 Sobel operators and normalized so that the strongest edge of the image is
+// white, while flat areas are black. Samples past the edges of the image
+// repeat the border pixels. The result is opaque.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//
+// Returns:
+//
+//	An error if a worker panics, in which case the image is left unchanged.
+func SobelEdges(tensor *[][][]float64) error {
+	width, height, _ := Dimensions(*tensor)
+	if width == 0 || height == 0 {
+		return nil
+	}
+	magnitude, err := sobel(luminancePlane(*tensor))
+	if err != nil {
+		return err
+	}
+
+	peak := 0.0
+	for _, row := range magnitude {
+		for _, m := range row {
+			peak = math.Max(peak, m)
+		}
+	}
+
+	for y, row := range *tensor {
+		for x, pixel := range row {
+			v := 0.0
+			if peak > 0 {
+				v = magnitude[y][x] / peak
+			}
+			pixel[0], pixel[1], pixel[2], pixel[3] = v, v, v, 1
+		}
+	}
+	return nil
+}
@@ -0,0 +1,588 @@
+package imagetor
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestCartoonize(t *testing.T) {
+	tests := []struct {
+		name   string
+		levels int
+	}{
+		{"two levels", 2},
+		{"four levels", 4},
+		{"eight levels", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A noisy orange left half next to a noisy dark blue right half.
+			const size = 24
+			tensor := newTensor(size, size)
+			for y, row := range tensor {
+				for x, p := range row {
+					noise := 0.01 * float64((x*7+y*13)%5-2)
+					if x < size/2 {
+						p[0], p[1], p[2] = 0.9+noise, 0.6+noise, 0.2+noise
+					} else {
+						p[0], p[1], p[2] = 0.1+noise, 0.15+noise, 0.4+noise
+					}
+					p[3] = 1
+				}
+			}
+
+			if err := Cartoonize(&tensor, tt.levels, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			// Away from the edge, each half is flat: the noise is smoothed and
+			// posterized away, leaving only faint darkening.
+			for y, row := range tensor {
+				for x, p := range row {
+					if x >= size/2-3 && x < size/2+3 {
+						continue
+					}
+					ref := tensor[0][0]
+					if x >= size/2 {
+						ref = tensor[0][size-1]
+					}
+					for c := 0; c < 3; c++ {
+						if !near(p[c], ref[c], 0.05) {
+							t.Fatalf("pixel (%d, %d) = %v, want flat %v", x, y, p, ref)
+						}
+					}
+				}
+			}
+
+			// The flat colors sit on the posterization levels.
+			step := 1 / float64(tt.levels-1)
+			for _, ref := range [][]float64{tensor[0][0], tensor[0][size-1]} {
+				for c := 0; c < 3; c++ {
+					if level := math.Round(ref[c]/step) * step; !near(ref[c], level, 0.05) {
+						t.Errorf("flat color %v is not posterized to %d levels", ref, tt.levels)
+					}
+				}
+			}
+
+			// Along the edge, both halves are darkened into an outline.
+			for y, row := range tensor {
+				for _, side := range [][2]int{{size/2 - 1, 0}, {size / 2, size - 1}} {
+					edge, flat := row[side[0]], row[side[1]]
+					if luminance(edge[0], edge[1], edge[2]) > 0.75*luminance(flat[0], flat[1], flat[2]) {
+						t.Fatalf("row %d: edge pixel %v is not darker than flat pixel %v", y, edge, flat)
+					}
+				}
+			}
+		})
+	}
+}
+
+// edgeTensor returns an opaque gray image whose left half has value dark and
+// right half value light.
+func edgeTensor(width, height int, dark, light float64) [][][]float64 {
+	tensor := newTensor(width, height)
+	for _, row := range tensor {
+		for x, p := range row {
+			v := dark
+			if x >= width/2 {
+				v = light
+			}
+			p[0], p[1], p[2], p[3] = v, v, v, 1
+		}
+	}
+	return tensor
+}
+
+// overshoot returns how far the red channel of a tensor reaches beyond
+// [dark, light] in either direction.
+func overshoot(tensor [][][]float64, dark, light float64) float64 {
+	worst := 0.0
+	for _, row := range tensor {
+		for _, p := range row {
+			worst = max(worst, p[0]-light, dark-p[0])
+		}
+	}
+	return worst
+}
+
+func TestUnsharpMaskHaloSuppress(t *testing.T) {
+	tests := []struct {
+		name        string
+		dark, light float64
+		amount      float64
+	}{
+		{"mid-gray edge", 0.3, 0.7, 1.5},
+		{"near black and white edge", 0.1, 0.9, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overshoots := make([]float64, 0, 3)
+			for _, suppress := range []float64{0, 0.5, 1} {
+				tensor := edgeTensor(16, 4, tt.dark, tt.light)
+				if err := UnsharpMask(&tensor, 1.5, tt.amount, suppress); err != nil {
+					t.Fatal(err)
+				}
+				if !(tensor[0][7][0] < tt.dark+0.01 && tensor[0][8][0] > tt.light-0.01) {
+					t.Errorf("haloSuppress %v lost the edge: %v %v", suppress, tensor[0][7][0], tensor[0][8][0])
+				}
+				if tensor[2][8][3] != 1 {
+					t.Errorf("haloSuppress %v changed alpha to %v", suppress, tensor[2][8][3])
+				}
+				overshoots = append(overshoots, overshoot(tensor, tt.dark, tt.light))
+			}
+			if overshoots[0] <= 0 {
+				t.Fatalf("plain unsharp mask did not overshoot: %v", overshoots[0])
+			}
+			for i := 1; i < len(overshoots); i++ {
+				if overshoots[i] >= overshoots[i-1] {
+					t.Errorf("overshoots %v do not shrink as haloSuppress grows", overshoots)
+				}
+			}
+		})
+	}
+}
+
+// shadowsAndHighlights returns a 32x16 opaque gray image with noisy shadows
+// on the left half and sharp stripes of 0.75 and 1 on the right half.
+func shadowsAndHighlights() [][][]float64 {
+	tensor := newTensor(32, 16)
+	for y, row := range tensor {
+		for x, p := range row {
+			var v float64
+			if x < 16 {
+				v = 0.1 + 0.02*float64((x*7+y*13)%5-2)
+			} else {
+				v = 0.75 + 0.25*float64(x/2%2)
+			}
+			p[0], p[1], p[2], p[3] = v, v, v, 1
+		}
+	}
+	return tensor
+}
+
+// regionStd returns the standard deviation of the red channel over the
+// columns [x0, x1).
+func regionStd(tensor [][][]float64, x0, x1 int) float64 {
+	sum, sumSq, n := 0.0, 0.0, 0.0
+	for _, row := range tensor {
+		for _, p := range row[x0:x1] {
+			sum += p[0]
+			sumSq += p[0] * p[0]
+			n++
+		}
+	}
+	mean := sum / n
+	return math.Sqrt(sumSq/n - mean*mean)
+}
+
+func TestTonalBlur(t *testing.T) {
+	source := shadowsAndHighlights()
+
+	tests := []struct {
+		name                 string
+		low, high            float64
+		wantShadowsSmoothed  bool
+		wantHighlightsChange bool
+	}{
+		{"shadows only", 0, 0.3, true, false},
+		{"highlights only", 0.6, 1, false, true},
+		{"everything", 0, 1, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := cloneTensor(source)
+			if err := TonalBlur(&tensor, 1.5, tt.low, tt.high); err != nil {
+				t.Fatal(err)
+			}
+
+			// Stay clear of the middle, where the blur mixes the halves.
+			shadowsBefore, shadowsAfter := regionStd(source, 0, 12), regionStd(tensor, 0, 12)
+			if smoothed := shadowsAfter < 0.5*shadowsBefore; smoothed != tt.wantShadowsSmoothed {
+				t.Errorf("shadow noise went from %v to %v, want smoothed %v", shadowsBefore, shadowsAfter, tt.wantShadowsSmoothed)
+			}
+			if !tt.wantShadowsSmoothed && !pixelsNear(columns(tensor, 0, 8), columns(source, 0, 8), 0) {
+				t.Error("shadows changed")
+			}
+
+			changed := !pixelsNear(columns(tensor, 24, 32), columns(source, 24, 32), 1e-9)
+			if changed != tt.wantHighlightsChange {
+				t.Errorf("highlights changed: %v, want %v", changed, tt.wantHighlightsChange)
+			}
+			if tensor[8][24][3] != 1 {
+				t.Errorf("alpha changed to %v", tensor[8][24][3])
+			}
+		})
+	}
+}
+
+// columns returns the columns [x0, x1) of a tensor, sharing its pixels.
+func columns(tensor [][][]float64, x0, x1 int) [][][]float64 {
+	result := make([][][]float64, len(tensor))
+	for y, row := range tensor {
+		result[y] = row[x0:x1]
+	}
+	return result
+}
+
+func TestConvolve(t *testing.T) {
+	source := gradientTensor(9, 7)
+	// shifted holds source moved one pixel to the right, with the left column
+	// repeated as the samples past the edge are.
+	shifted := cloneTensor(source)
+	for y, row := range shifted {
+		for x := range row {
+			copy(row[x], source[y][max(x-1, 0)])
+		}
+	}
+
+	tests := []struct {
+		name    string
+		kernel  [3][3]float64
+		divisor float64
+		want    [][][]float64
+	}{
+		{"identity", [3][3]float64{{0, 0, 0}, {0, 1, 0}, {0, 0, 0}}, 1, source},
+		{"zero divisor", [3][3]float64{{0, 0, 0}, {0, 1, 0}, {0, 0, 0}}, 0, source},
+		{"scaled identity", [3][3]float64{{0, 0, 0}, {0, 4, 0}, {0, 0, 0}}, 4, source},
+		{"shift right", [3][3]float64{{0, 0, 0}, {1, 0, 0}, {0, 0, 0}}, 1, shifted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := cloneTensor(source)
+			if err := Convolve(&tensor, tt.kernel, tt.divisor); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, tt.want, 1e-12) {
+				t.Error("convolved image differs from the expected result")
+			}
+		})
+	}
+}
+
+func TestConvolveKeepsAlpha(t *testing.T) {
+	source := alphaRamp(8, 4)
+	tensor := cloneTensor(source)
+	box := [3][3]float64{{1, 1, 1}, {1, 1, 1}, {1, 1, 1}}
+	if err := Convolve(&tensor, box, 9); err != nil {
+		t.Fatal(err)
+	}
+	for y, row := range tensor {
+		for x, p := range row {
+			if want := source[y][x][3]; p[3] != want {
+				t.Fatalf("alpha at (%d, %d) = %v, want %v", x, y, p[3], want)
+			}
+		}
+	}
+}
+
+func TestGaussianBlur(t *testing.T) {
+	const size, center = 25, 12
+	tests := []struct {
+		name      string
+		radius    int
+		sigma     float64
+		unchanged bool
+	}{
+		{"small", 2, 0.8, false},
+		{"medium", 6, 2, false},
+		{"wide", 9, 3, false},
+		{"zero radius", 0, 2, true},
+		{"zero sigma", 3, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A single white pixel on opaque black.
+			source := solidTensor(size, size, [4]float64{0, 0, 0, 1})
+			copy(source[center][center], []float64{1, 1, 1, 1})
+			tensor := cloneTensor(source)
+			if err := GaussianBlur(&tensor, tt.radius, tt.sigma); err != nil {
+				t.Fatal(err)
+			}
+			if tt.unchanged {
+				if !pixelsNear(tensor, source, 0) {
+					t.Error("image changed")
+				}
+				return
+			}
+
+			total := 0.0
+			for y, row := range tensor {
+				for x, p := range row {
+					total += p[0]
+					dx, dy := x-center, y-center
+					for _, m := range [][2]int{{-dx, dy}, {dx, -dy}, {dy, dx}} {
+						if mirror := tensor[center+m[1]][center+m[0]][0]; !near(p[0], mirror, 1e-12) {
+							t.Fatalf("pixel (%d, %d) = %v, mirrored pixel = %v", x, y, p[0], mirror)
+						}
+					}
+				}
+			}
+			if !near(total, 1, 1e-9) {
+				t.Errorf("total brightness = %v, want 1", total)
+			}
+			for d := 1; d <= tt.radius; d++ {
+				if tensor[center][center+d][0] >= tensor[center][center+d-1][0] {
+					t.Errorf("brightness does not fall off at distance %d", d)
+				}
+			}
+		})
+	}
+}
+
+func TestSharpen(t *testing.T) {
+	const dark, light = 0.3, 0.7
+	tests := []struct {
+		name   string
+		amount float64
+	}{
+		{"none", 0},
+		{"subtle", 0.3},
+		{"strong", 1},
+		{"aggressive", 3},
+	}
+	prevContrast := 0.0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := edgeTensor(32, 8, dark, light)
+			tensor := cloneTensor(source)
+			if err := Sharpen(&tensor, tt.amount); err != nil {
+				t.Fatal(err)
+			}
+			// The blur does not reach this far from the edge between columns
+			// 15 and 16, so these columns are flat.
+			for _, x := range []int{0, 24} {
+				if !pixelsNear(columns(tensor, x, x+8), columns(source, x, x+8), 1e-12) {
+					t.Errorf("flat region at columns %d-%d changed", x, x+7)
+				}
+			}
+			contrast := tensor[4][16][0] - tensor[4][15][0]
+			if tt.amount == 0 {
+				if !pixelsNear(tensor, source, 0) {
+					t.Error("amount 0 changed the image")
+				}
+			} else if contrast <= prevContrast {
+				t.Errorf("edge contrast = %v, want more than %v", contrast, prevContrast)
+			}
+			prevContrast = contrast
+		})
+	}
+}
+
+func TestSobelEdges(t *testing.T) {
+	tests := []struct {
+		name           string
+		x0, y0, x1, y1 int
+	}{
+		{"centered square", 8, 8, 16, 16},
+		{"off-center square", 4, 10, 10, 18},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A white square [x0, x1) x [y0, y1) on opaque black.
+			tensor := solidTensor(24, 24, [4]float64{0, 0, 0, 1})
+			for y := tt.y0; y < tt.y1; y++ {
+				for x := tt.x0; x < tt.x1; x++ {
+					copy(tensor[y][x], []float64{1, 1, 1, 1})
+				}
+			}
+			if err := SobelEdges(&tensor); err != nil {
+				t.Fatal(err)
+			}
+
+			midX, midY := (tt.x0+tt.x1)/2, (tt.y0+tt.y1)/2
+			for _, b := range [][2]int{{tt.x0, midY}, {tt.x1 - 1, midY}, {midX, tt.y0}, {midX, tt.y1 - 1}} {
+				if v := tensor[b[1]][b[0]][0]; v < 0.5 {
+					t.Errorf("border pixel (%d, %d) = %v, want a strong response", b[0], b[1], v)
+				}
+			}
+			for _, f := range [][2]int{{0, 0}, {23, 23}, {midX, midY}} {
+				if v := tensor[f[1]][f[0]][0]; v > 1e-9 {
+					t.Errorf("flat pixel (%d, %d) = %v, want 0", f[0], f[1], v)
+				}
+			}
+			for _, row := range tensor {
+				for _, p := range row {
+					if p[0] < 0 || p[0] > 1 || p[0] != p[1] || p[0] != p[2] || p[3] != 1 {
+						t.Fatalf("pixel %v is not an opaque gray in [0, 1]", p)
+					}
+				}
+			}
+		})
+	}
+}
+
+// naiveBoxBlur averages the (2*radius+1) x (2*radius+1) square around each
+// pixel directly, repeating the border pixels past the edges.
+func naiveBoxBlur(tensor [][][]float64, radius int) [][][]float64 {
+	width, height, _ := Dimensions(tensor)
+	result := newTensor(width, height)
+	n := float64((2*radius + 1) * (2*radius + 1))
+	for y, row := range result {
+		for x, out := range row {
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					p := tensor[clampIndex(y+dy, height)][clampIndex(x+dx, width)]
+					for c := range out {
+						out[c] += p[c] / n
+					}
+				}
+			}
+		}
+	}
+	return result
+}
+
+func TestBoxBlur(t *testing.T) {
+	tests := []struct {
+		name   string
+		source [][][]float64
+		radius int
+	}{
+		{"radius 1", stripesTensor(11, 9), 1},
+		{"radius 2", gradientTensor(11, 9), 2},
+		{"radius wider than the image", stripesTensor(5, 20), 7},
+		{"translucent", alphaRamp(12, 6), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := cloneTensor(tt.source)
+			if err := BoxBlur(&tensor, tt.radius); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, naiveBoxBlur(tt.source, tt.radius), 1e-9) {
+				t.Error("BoxBlur differs from the naive box average")
+			}
+		})
+	}
+
+	t.Run("radius larger than the stripe height", func(t *testing.T) {
+		withStripeHeight(t, 4)
+		source := gradientTensor(9, 30)
+		tensor := cloneTensor(source)
+		if err := BoxBlur(&tensor, 6); err != nil {
+			t.Fatal(err)
+		}
+		if !pixelsNear(tensor, naiveBoxBlur(source, 6), 1e-9) {
+			t.Error("BoxBlur differs from the naive box average")
+		}
+	})
+
+	t.Run("radius 0", func(t *testing.T) {
+		tensor := stripesTensor(8, 8)
+		if err := BoxBlur(&tensor, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !pixelsNear(tensor, stripesTensor(8, 8), 0) {
+			t.Error("radius 0 changed the image")
+		}
+	})
+}
+
+// BenchmarkBoxBlur shows that the cost of BoxBlur does not grow with the
+// radius.
+func BenchmarkBoxBlur(b *testing.B) {
+	source := stripesTensor(1000, 750)
+	for _, radius := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprint(radius), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tensor := cloneTensor(source)
+				b.StartTimer()
+				if err := BoxBlur(&tensor, radius); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMedianFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		radius int
+		noise  [][2]int
+	}{
+		{"isolated pixels, radius 1", 1, [][2]int{{3, 3}, {12, 4}, {5, 6}, {0, 0}}},
+		{"next to the edge", 1, [][2]int{{7, 2}, {8, 5}}},
+		{"pairs, radius 2", 2, [][2]int{{3, 3}, {4, 3}, {11, 5}, {11, 6}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clean := edgeTensor(16, 8, 0.3, 0.7)
+			tensor := cloneTensor(clean)
+			for i, n := range tt.noise {
+				// Alternate white and black salt-and-pepper noise.
+				v := float64(i % 2)
+				copy(tensor[n[1]][n[0]], []float64{v, v, v})
+			}
+			if err := MedianFilter(&tensor, tt.radius); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, clean, 0) {
+				t.Error("filtered image differs from the noiseless one")
+			}
+		})
+	}
+
+	t.Run("alpha copied", func(t *testing.T) {
+		source := alphaRamp(9, 5)
+		tensor := cloneTensor(source)
+		if err := MedianFilter(&tensor, 1); err != nil {
+			t.Fatal(err)
+		}
+		for y, row := range tensor {
+			for x, p := range row {
+				if p[3] != source[y][x][3] {
+					t.Fatalf("alpha at (%d, %d) = %v, want %v", x, y, p[3], source[y][x][3])
+				}
+			}
+		}
+	})
+}
+
+func TestPosterize(t *testing.T) {
+	tests := []struct {
+		name   string
+		levels int
+		// want lists the values a channel may take, or is nil when the image
+		// should be left effectively unchanged.
+		want []float64
+	}{
+		{"two levels", 2, []float64{0, 1}},
+		{"one level is taken as two", 1, []float64{0, 1}},
+		{"three levels", 3, []float64{0, 0.5, 1}},
+		{"257 levels", 257, nil},
+		{"1000 levels", 1000, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := gradientTensor(64, 48)
+			tensor := cloneTensor(source)
+			Posterize(&tensor, tt.levels)
+			if tt.want == nil {
+				// Finer than half an 8-bit step.
+				if !pixelsNear(tensor, source, 0.5/255) {
+					t.Error("posterized image differs from the source")
+				}
+				return
+			}
+			for y, row := range tensor {
+				for x, p := range row {
+					for c := 0; c < 3; c++ {
+						ok := false
+						for _, v := range tt.want {
+							ok = ok || near(p[c], v, 1e-12)
+						}
+						if !ok {
+							t.Fatalf("pixel (%d, %d) = %v, want channels in %v", x, y, p, tt.want)
+						}
+					}
+					if p[3] != source[y][x][3] {
+						t.Fatalf("alpha at (%d, %d) changed to %v", x, y, p[3])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package imagetor
+
+import "fmt"
+
+// rotate90 returns a copy of a tensor rotated clockwise by times quarter
+// turns, or an error if the tensor is malformed. Rotations are exact: pixels
+// are moved, not interpolated.
+func rotate90(tensor [][][]float64, times int) ([][][]float64, error) {
+	times = ((times % 4) + 4) % 4
+	height, width, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+
+	newWidth, newHeight := width, height
+	if times%2 == 1 {
+		newWidth, newHeight = height, width
+	}
+	result := make([][][]float64, newHeight)
+	for y := range result {
+		result[y] = make([][]float64, newWidth)
+		for x := range result[y] {
+			var src []float64
+			switch times {
+			case 0:
+				src = tensor[y][x]
+			case 1:
+				src = tensor[height-1-x][y]
+			case 2:
+				src = tensor[height-1-y][width-1-x]
+			case 3:
+				src = tensor[x][width-1-y]
+			}
+			result[y][x] = append([]float64(nil), src...)
+		}
+	}
+	return result, nil
+}
+
+// Rotate90 rotates the image clockwise by a multiple of 90 degrees.
+//
+// Unlike Rotate, pixels are moved rather than interpolated, so the rotation is
+// exact and the whole image is kept: the width and height swap for odd numbers
+// of quarter turns.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	times: The number of clockwise quarter turns, taken modulo 4. Negative
+//	  values turn counterclockwise.
+//
+// Returns:
+//
+//	An error if the image is empty, ragged or has pixels with fewer than four
+//	channels, in which case it is left unchanged.
+func Rotate90(tensor *[][][]float64, times int) error {
+	result, err := rotate90(*tensor, times)
+	if err != nil {
+		return err
+	}
+	*tensor = result
+	return nil
+}
+
+// Transpose swaps the x and y axes of the image, mirroring it along its main
+// diagonal so that the pixel at (x, y) moves to (y, x).
+//
+// A height x width image becomes width x height, so the tensor is reallocated
+// and the pixels are copied.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//
+// Returns:
+//
+//	An error if the image is empty, ragged or has pixels with fewer than four
+//	channels, in which case it is left unchanged.
+func Transpose(tensor *[][][]float64) error {
+	height, width, err := dimsOf(*tensor)
+	if err != nil {
+		return err
+	}
+
+	result := make([][][]float64, width)
+	for y := range result {
+		result[y] = make([][]float64, height)
+		for x := range result[y] {
+			result[y][x] = append([]float64(nil), (*tensor)[x][y]...)
+		}
+	}
+	*tensor = result
+	return nil
+}
+
+// uprightMargin is how much better than the current orientation another one
+// must score for AutoUpright to rotate the image.
+const uprightMargin = 0.05
+
+// AutoUpright rotates an image by a multiple of 90 degrees to the orientation
+// most likely to be upright, for images that lack EXIF orientation data.
+//
+// The heuristic relies on the top of most photos being brighter and bluer
+// than the bottom, as with sky above the ground or a ceiling light above the
+// floor. Each of the four orientations is scored by comparing the mean
+// luminance and blueness of the band of pixels that would end up at the top
+// with the band that would end up at the bottom. The image is only rotated
+// when another orientation scores clearly better than the current one.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//
+// Returns:
+//
+//	The clockwise rotation applied, in degrees: 0, 90, 180 or 270, or an
+//	error if the image is empty, ragged or has pixels with fewer than four
+//	channels.
+func AutoUpright(tensor *[][][]float64) (int, error) {
+	height, width, err := dimsOf(*tensor)
+	if err != nil {
+		return 0, err
+	}
+
+	// bandScore returns the mean of luminance plus half the blueness (blue
+	// minus red) over the pixels in the rectangle [x0, x1) x [y0, y1).
+	bandScore := func(x0, y0, x1, y1 int) float64 {
+		sum, count := 0.0, 0
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				p := (*tensor)[y][x]
+				sum += luminance(p[0], p[1], p[2]) + 0.5*(p[2]-p[0])
+				count++
+			}
+		}
+		if count == 0 {
+			return 0
+		}
+		return sum / float64(count)
+	}
+
+	bandH, bandW := max(1, height/3), max(1, width/3)
+	top := bandScore(0, 0, width, bandH)
+	bottom := bandScore(0, height-bandH, width, height)
+	left := bandScore(0, 0, bandW, height)
+	right := bandScore(width-bandW, 0, width, height)
+
+	// A clockwise quarter turn moves the left band to the top.
+	scores := [4]float64{top - bottom, left - right, bottom - top, right - left}
+
+	best := 0
+	for k := 1; k < 4; k++ {
+		if scores[k] > scores[best] {
+			best = k
+		}
+	}
+	if best == 0 || scores[best]-scores[0] < uprightMargin {
+		return 0, nil
+	}
+
+	if err := Rotate90(tensor, best); err != nil {
+		return 0, err
+	}
+	return best * 90, nil
+}
+
+// padKind is how a PadMode fills the margins.
+type padKind int
+
+const (
+	padConstant padKind = iota
+	padEdge
+	padReflect
+)
+
+// PadMode selects how Pad fills the added margins.
+type PadMode struct {
+	kind padKind
+	fill [4]float64
+}
+
+var (
+	// PadEdge repeats the border pixels of the image into the margins.
+	PadEdge = PadMode{kind: padEdge}
+	// PadReflect mirrors the image into the margins, including the border
+	// pixels, so that a row abc padded by two on each side becomes baabccb.
+	PadReflect = PadMode{kind: padReflect}
+)
+
+// PadConstant returns a PadMode that fills the margins with a single color.
+//
+// Args:
+//
+//	fill: The RGBA color of the margins.
+//
+// Returns:
+//
+//	The padding mode.
+func PadConstant(fill [4]float64) PadMode {
+	return PadMode{kind: padConstant, fill: fill}
+}
+
+// reflectIndex maps a coordinate outside [0, n) into it by mirroring the range
+// about its ends, repeating as often as needed.
+func reflectIndex(i, n int) int {
+	i %= 2 * n
+	if i < 0 {
+		i += 2 * n
+	}
+	if i >= n {
+		i = 2*n - 1 - i
+	}
+	return i
+}
+
+// Pad enlarges the image by the given margins, for use before rotating or
+// filtering or to frame an image.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	top: The number of rows to add above the image.
+//	right: The number of columns to add right of the image.
+//	bottom: The number of rows to add below the image.
+//	left: The number of columns to add left of the image.
+//	mode: How to fill the margins. Negative margins are treated as 0, and
+//	  only PadConstant can pad an empty tensor.
+//
+// Returns:
+//
+//	An error if a worker panics, in which case the image is left unchanged.
+func Pad(tensor *[][][]float64, top, right, bottom, left int, mode PadMode) error {
+	top, right, bottom, left = max(top, 0), max(right, 0), max(bottom, 0), max(left, 0)
+	width, height, _ := Dimensions(*tensor)
+	if mode.kind != padConstant && (width == 0 || height == 0) {
+		return nil
+	}
+
+	result := newTensor(width+left+right, height+top+bottom)
+	if err := parallelRows(len(result), func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := range result[y] {
+				sx, sy := x-left, y-top
+				inside := sx >= 0 && sx < width && sy >= 0 && sy < height
+				switch {
+				case inside:
+					copy(result[y][x], (*tensor)[sy][sx])
+				case mode.kind == padEdge:
+					copy(result[y][x], (*tensor)[clampIndex(sy, height)][clampIndex(sx, width)])
+				case mode.kind == padReflect:
+					copy(result[y][x], (*tensor)[reflectIndex(sy, height)][reflectIndex(sx, width)])
+				default:
+					copy(result[y][x], mode.fill[:])
+				}
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	*tensor = result
+	return nil
+}
+
+// Crop replaces the image with the rectangle [x0, x1) x [y0, y1) of it.
+//
+// The pixels are copied, so the cropped tensor does not share memory with the
+// original.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	x0: The left edge of the rectangle, inclusive.
+//	y0: The top edge of the rectangle, inclusive.
+//	x1: The right edge of the rectangle, exclusive.
+//	y1: The bottom edge of the rectangle, exclusive.
+//
+// Returns:
+//
+//	An error if the rectangle is empty or extends past the image, or if the
+//	image is empty, ragged or has pixels with fewer than four channels.
+func Crop(tensor *[][][]float64, x0, y0, x1, y1 int) error {
+	if x1 <= x0 || y1 <= y0 {
+		return fmt.Errorf("crop rectangle (%d,%d)-(%d,%d) is empty", x0, y0, x1, y1)
+	}
+	height, width, err := dimsOf(*tensor)
+	if err != nil {
+		return err
+	}
+	if x0 < 0 || y0 < 0 || x1 > width || y1 > height {
+		return fmt.Errorf("crop rectangle (%d,%d)-(%d,%d) is out of bounds for image %dx%d", x0, y0, x1, y1, width, height)
+	}
+
+	result := newTensor(x1-x0, y1-y0)
+	for y, row := range result {
+		for x, pixel := range row {
+			copy(pixel, (*tensor)[y0+y][x0+x])
+		}
+	}
+	*tensor = result
+	return nil
+}
@@ -0,0 +1,410 @@
+package imagetor
+
+import "testing"
+
+// skyTensor returns an opaque image fading from a bright blue sky at the top
+// to a dark brown ground at the bottom.
+func skyTensor(width, height int) [][][]float64 {
+	tensor := newTensor(width, height)
+	for y, row := range tensor {
+		f := float64(y) / float64(height-1)
+		for _, p := range row {
+			p[0] = 0.5*(1-f) + 0.25*f
+			p[1] = 0.7*(1-f) + 0.15*f
+			p[2] = 1.0*(1-f) + 0.05*f
+			p[3] = 1
+		}
+	}
+	return tensor
+}
+
+func TestAutoUpright(t *testing.T) {
+	upright := skyTensor(12, 8)
+
+	tests := []struct {
+		name    string
+		turns   int
+		want    int
+		tensor  [][][]float64
+		wantOut [][][]float64
+	}{
+		{"upright", 0, 0, upright, upright},
+		{"turned clockwise", 1, 270, upright, upright},
+		{"upside down", 2, 180, upright, upright},
+		{"turned counterclockwise", 3, 90, upright, upright},
+		{"uniform", 1, 0, solidTensor(6, 4, [4]float64{0.5, 0.5, 0.5, 1}), solidTensor(4, 6, [4]float64{0.5, 0.5, 0.5, 1})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := cloneTensor(tt.tensor)
+			if err := Rotate90(&tensor, tt.turns); err != nil {
+				t.Fatal(err)
+			}
+			got, err := AutoUpright(&tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("AutoUpright rotated by %d, want %d", got, tt.want)
+			}
+			if !pixelsNear(tensor, tt.wantOut, 0) {
+				t.Error("image is not back upright")
+			}
+		})
+	}
+
+	if _, err := AutoUpright(new([][][]float64)); err == nil {
+		t.Error("empty tensor did not return an error")
+	}
+}
+
+func TestPad(t *testing.T) {
+	// a b
+	// c d
+	a, b, c, d := []float64{0.1, 0, 0, 1}, []float64{0.2, 0, 0, 1}, []float64{0.3, 0, 0, 1}, []float64{0.4, 0, 0, 1}
+	fill := []float64{0, 0, 1, 0.5}
+
+	tests := []struct {
+		name                     string
+		top, right, bottom, left int
+		mode                     PadMode
+		want                     [][][]float64
+	}{
+		{"reflect by 1", 1, 1, 1, 1, PadReflect, [][][]float64{
+			{a, a, b, b},
+			{a, a, b, b},
+			{c, c, d, d},
+			{c, c, d, d},
+		}},
+		{"reflect by 2 on the left", 0, 0, 0, 2, PadReflect, [][][]float64{
+			{b, a, a, b},
+			{d, c, c, d},
+		}},
+		{"edge", 1, 2, 0, 0, PadEdge, [][][]float64{
+			{a, b, b, b},
+			{a, b, b, b},
+			{c, d, d, d},
+		}},
+		{"constant", 0, 1, 1, 0, PadConstant([4]float64{0, 0, 1, 0.5}), [][][]float64{
+			{a, b, fill},
+			{c, d, fill},
+			{fill, fill, fill},
+		}},
+		{"negative margins", -1, 0, -3, 0, PadEdge, [][][]float64{
+			{a, b},
+			{c, d},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := cloneTensor([][][]float64{{a, b}, {c, d}})
+			if err := Pad(&tensor, tt.top, tt.right, tt.bottom, tt.left, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, tt.want, 0) {
+				t.Errorf("Pad = %v, want %v", tensor, tt.want)
+			}
+		})
+	}
+}
+
+func TestPadReflectRow(t *testing.T) {
+	// The source row is abc, with a, b and c held as 0, 1 and 2.
+	tests := []struct {
+		name   string
+		margin int
+		want   []float64
+	}{
+		{"by one", 1, []float64{0, 0, 1, 2, 2}},
+		{"by two", 2, []float64{1, 0, 0, 1, 2, 2, 1}},
+		{"by the row length", 3, []float64{2, 1, 0, 0, 1, 2, 2, 1, 0}},
+		{"past the row length", 4, []float64{2, 2, 1, 0, 0, 1, 2, 2, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := indexTensor(3, 1)
+			if err := Pad(&tensor, 0, tt.margin, 0, tt.margin, PadReflect); err != nil {
+				t.Fatal(err)
+			}
+			got := reds(tensor)[0]
+			if len(got) != len(tt.want) {
+				t.Fatalf("padded row = %v, want %v", got, tt.want)
+			}
+			for x := range got {
+				if got[x] != tt.want[x] {
+					t.Fatalf("padded row = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// reds returns the red channel of a tensor, which indexTensor sets to the
+// original position of each pixel.
+func reds(tensor [][][]float64) [][]float64 {
+	result := make([][]float64, len(tensor))
+	for y, row := range tensor {
+		result[y] = make([]float64, len(row))
+		for x, p := range row {
+			result[y][x] = p[0]
+		}
+	}
+	return result
+}
+
+func TestRotate90(t *testing.T) {
+	// The source is 2 pixels wide and 3 high:
+	//
+	//	0 1
+	//	2 3
+	//	4 5
+	clockwise := [][]float64{{4, 2, 0}, {5, 3, 1}}
+	halfTurn := [][]float64{{5, 4}, {3, 2}, {1, 0}}
+	counterclockwise := [][]float64{{1, 3, 5}, {0, 2, 4}}
+	original := [][]float64{{0, 1}, {2, 3}, {4, 5}}
+
+	tests := []struct {
+		name  string
+		times int
+		want  [][]float64
+	}{
+		{"none", 0, original},
+		{"90", 1, clockwise},
+		{"180", 2, halfTurn},
+		{"270", 3, counterclockwise},
+		{"full turn", 4, original},
+		{"counterclockwise", -1, counterclockwise},
+		{"taken modulo 4", 5, clockwise},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := indexTensor(2, 3)
+			if err := Rotate90(&tensor, tt.times); err != nil {
+				t.Fatal(err)
+			}
+			got := reds(tensor)
+			if len(got) != len(tt.want) || len(got[0]) != len(tt.want[0]) {
+				t.Fatalf("Rotate90 = %v, want %v", got, tt.want)
+			}
+			for y := range got {
+				for x := range got[y] {
+					if got[y][x] != tt.want[y][x] || tensor[y][x][3] != 1 {
+						t.Fatalf("Rotate90 = %v, want %v", got, tt.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRotate90Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+	}{
+		{"empty", nil},
+		{"ragged", [][][]float64{{{0, 0, 0, 1}, {0, 0, 0, 1}}, {{0, 0, 0, 1}}}},
+		{"too few channels", [][][]float64{{{0, 0, 0}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := tt.tensor
+			if err := Rotate90(&tensor, 1); err == nil {
+				t.Error("Rotate90 did not return an error")
+			}
+		})
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+	}{
+		{"wide", 5, 3},
+		{"tall", 2, 7},
+		{"square", 4, 4},
+		{"single row", 6, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := indexTensor(tt.width, tt.height)
+			tensor := source
+			if err := Transpose(&tensor); err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(tensor); w != tt.height || h != tt.width {
+				t.Fatalf("transposed to %dx%d, want %dx%d", w, h, tt.height, tt.width)
+			}
+			for y, row := range reds(tensor) {
+				for x, v := range row {
+					// Pixel (x, y) came from (y, x), whose index is x*width+y.
+					if want := float64(x*tt.width + y); v != want {
+						t.Fatalf("pixel (%d, %d) holds source pixel %v, want %v", x, y, v, want)
+					}
+				}
+			}
+
+			// Writing to the result must leave the source untouched.
+			for _, row := range tensor {
+				for _, p := range row {
+					p[1] = 1
+				}
+			}
+			if !pixelsNear(source, indexTensor(tt.width, tt.height), 0) {
+				t.Error("the transposed tensor shares pixels with the source")
+			}
+
+			if err := Transpose(&tensor); err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range reds(tensor) {
+				for x, v := range row {
+					if v != source[y][x][0] {
+						t.Fatal("transposing twice did not restore the image")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTransposeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+	}{
+		{"empty", nil},
+		{"ragged", [][][]float64{{{0, 0, 0, 1}, {0, 0, 0, 1}}, {{0, 0, 0, 1}}}},
+		{"too few channels", [][][]float64{{{0, 0, 0}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := tt.tensor
+			if err := Transpose(&tensor); err == nil {
+				t.Error("Transpose did not return an error")
+			}
+			if !pixelsNear(tensor, tt.tensor, 0) {
+				t.Error("Transpose changed the tensor despite the error")
+			}
+		})
+	}
+}
+
+func TestPadConstant(t *testing.T) {
+	fill := [4]float64{0.2, 0.4, 0.6, 1}
+	tests := []struct {
+		name                     string
+		top, right, bottom, left int
+	}{
+		{"centered frame", 3, 3, 3, 3},
+		{"wide frame", 1, 5, 1, 5},
+		{"uneven margins", 0, 2, 4, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := gradientTensor(6, 4)
+			tensor := cloneTensor(source)
+			if err := Pad(&tensor, tt.top, tt.right, tt.bottom, tt.left, PadConstant(fill)); err != nil {
+				t.Fatal(err)
+			}
+			wantWidth, wantHeight := 6+tt.left+tt.right, 4+tt.top+tt.bottom
+			if w, h, _ := Dimensions(tensor); w != wantWidth || h != wantHeight {
+				t.Fatalf("padded to %dx%d, want %dx%d", w, h, wantWidth, wantHeight)
+			}
+			// Find the content by its pixels, none of which match the fill.
+			x0, y0, x1, y1 := wantWidth, wantHeight, 0, 0
+			for y, row := range tensor {
+				for x, p := range row {
+					if p[0] != fill[0] || p[1] != fill[1] || p[2] != fill[2] || p[3] != fill[3] {
+						x0, y0, x1, y1 = min(x0, x), min(y0, y), max(x1, x+1), max(y1, y+1)
+					}
+				}
+			}
+			if x0 != tt.left || y0 != tt.top || wantWidth-x1 != tt.right || wantHeight-y1 != tt.bottom {
+				t.Errorf("content margins are %d, %d, %d, %d, want %d, %d, %d, %d",
+					y0, wantWidth-x1, wantHeight-y1, x0, tt.top, tt.right, tt.bottom, tt.left)
+			}
+			for y, row := range tensor {
+				for x, p := range row {
+					sx, sy := x-tt.left, y-tt.top
+					want := fill[:]
+					if sx >= 0 && sx < 6 && sy >= 0 && sy < 4 {
+						want = source[sy][sx]
+					}
+					for c := range p {
+						if p[c] != want[c] {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, p, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCrop(t *testing.T) {
+	tests := []struct {
+		name           string
+		x0, y0, x1, y1 int
+	}{
+		{"inner rectangle", 1, 2, 4, 5},
+		{"whole image", 0, 0, 5, 6},
+		{"single pixel", 4, 5, 5, 6},
+		{"top row", 0, 0, 5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := indexTensor(5, 6)
+			tensor := source
+			if err := Crop(&tensor, tt.x0, tt.y0, tt.x1, tt.y1); err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(tensor); w != tt.x1-tt.x0 || h != tt.y1-tt.y0 {
+				t.Fatalf("cropped to %dx%d, want %dx%d", w, h, tt.x1-tt.x0, tt.y1-tt.y0)
+			}
+			for y, row := range reds(tensor) {
+				for x, v := range row {
+					if want := float64((tt.y0+y)*5 + tt.x0 + x); v != want {
+						t.Fatalf("pixel (%d, %d) holds source pixel %v, want %v", x, y, v, want)
+					}
+				}
+			}
+
+			// Writing to the crop must leave the source untouched.
+			for _, row := range tensor {
+				for _, p := range row {
+					p[0] = -1
+				}
+			}
+			if !pixelsNear(source, indexTensor(5, 6), 0) {
+				t.Error("the cropped tensor shares pixels with the source")
+			}
+		})
+	}
+}
+
+func TestCropErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		tensor         [][][]float64
+		x0, y0, x1, y1 int
+	}{
+		{"empty width", indexTensor(5, 6), 2, 1, 2, 4},
+		{"inverted x", indexTensor(5, 6), 3, 1, 1, 4},
+		{"inverted y", indexTensor(5, 6), 1, 4, 3, 2},
+		{"negative origin", indexTensor(5, 6), -1, 0, 2, 2},
+		{"past the right edge", indexTensor(5, 6), 2, 0, 6, 2},
+		{"past the bottom edge", indexTensor(5, 6), 0, 3, 2, 7},
+		{"empty tensor", nil, 0, 0, 1, 1},
+		{"ragged", [][][]float64{{{0, 0, 0, 1}, {0, 0, 0, 1}}, {{0, 0, 0, 1}}}, 0, 0, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := tt.tensor
+			if err := Crop(&tensor, tt.x0, tt.y0, tt.x1, tt.y1); err == nil {
+				t.Error("Crop did not return an error")
+			}
+		})
+	}
+}
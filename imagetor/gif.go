@@ -0,0 +1,68 @@
+package imagetor
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// DecodeGIFFrames decodes every frame of an animated GIF to a tensor, so that
+// each one can be processed with the other operations.
+//
+// GIF frames usually only cover the part of the image that changes, so each
+// frame is composited over what the previous ones left on the canvas, honoring
+// their disposal methods: a frame disposed to the background is cleared to
+// transparent, and one disposed to the previous state is replaced by the
+// canvas as it was before it was drawn. Every tensor has the full size of the
+// animation.
+//
+// Args:
+//
+//	r: The reader holding the GIF.
+//
+// Returns:
+//
+//	One tensor per frame, in order, or an error if the GIF cannot be decoded.
+func DecodeGIFFrames(r io.Reader) ([][][][]float64, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) == 0 {
+		return nil, fmt.Errorf("gif has no frames")
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(bounds)
+	frames := make([][][][]float64, len(g.Image))
+
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if frames[i], err = ImageToTensor(canvas); err != nil {
+			return nil, err
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames, nil
+}
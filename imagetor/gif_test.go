@@ -0,0 +1,115 @@
+package imagetor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// gifPalette holds the colors of the test animations: transparent, red,
+// green and blue.
+var gifPalette = color.Palette{
+	color.RGBA{},
+	color.RGBA{R: 255, A: 255},
+	color.RGBA{G: 255, A: 255},
+	color.RGBA{B: 255, A: 255},
+}
+
+// gifFrame returns a frame covering r, filled with palette index fill.
+func gifFrame(r image.Rectangle, fill uint8) *image.Paletted {
+	frame := image.NewPaletted(r, gifPalette)
+	for i := range frame.Pix {
+		frame.Pix[i] = fill
+	}
+	return frame
+}
+
+func TestDecodeGIFFrames(t *testing.T) {
+	red, blue := []float64{1, 0, 0, 1}, []float64{0, 0, 1, 1}
+	transparent := []float64{0, 0, 0, 0}
+	full, patch, corner := image.Rect(0, 0, 4, 4), image.Rect(1, 1, 3, 3), image.Rect(3, 3, 4, 4)
+
+	tests := []struct {
+		name     string
+		frames   []*image.Paletted
+		disposal []byte
+		// want returns the expected color of the pixel at (x, y) of the last
+		// frame.
+		want func(x, y int) []float64
+	}{
+		{
+			"left in place",
+			[]*image.Paletted{gifFrame(full, 1), gifFrame(patch, 3)},
+			[]byte{gif.DisposalNone, gif.DisposalNone},
+			func(x, y int) []float64 {
+				if image.Pt(x, y).In(patch) {
+					return blue
+				}
+				return red
+			},
+		},
+		{
+			"disposed to the background",
+			[]*image.Paletted{gifFrame(full, 1), gifFrame(patch, 3)},
+			[]byte{gif.DisposalBackground, gif.DisposalNone},
+			func(x, y int) []float64 {
+				if image.Pt(x, y).In(patch) {
+					return blue
+				}
+				return transparent
+			},
+		},
+		{
+			"disposed to the previous frame",
+			[]*image.Paletted{gifFrame(full, 1), gifFrame(patch, 2), gifFrame(corner, 3)},
+			[]byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalNone},
+			func(x, y int) []float64 {
+				if image.Pt(x, y).In(corner) {
+					return blue
+				}
+				return red
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := gif.EncodeAll(&buf, &gif.GIF{
+				Image:    tt.frames,
+				Delay:    make([]int, len(tt.frames)),
+				Disposal: tt.disposal,
+				Config:   image.Config{ColorModel: gifPalette, Width: 4, Height: 4},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			frames, err := DecodeGIFFrames(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frames) != len(tt.frames) {
+				t.Fatalf("decoded %d frames, want %d", len(frames), len(tt.frames))
+			}
+			if !pixelsNear(frames[0], solidTensor(4, 4, [4]float64{1, 0, 0, 1}), 0) {
+				t.Error("first frame is not solid red")
+			}
+			last := frames[len(frames)-1]
+			for y, row := range last {
+				for x, p := range row {
+					if want := tt.want(x, y); !pixelsNear([][][]float64{{p}}, [][][]float64{{want}}, 0) {
+						t.Fatalf("last frame pixel (%d, %d) = %v, want %v", x, y, p, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeGIFFramesInvalid(t *testing.T) {
+	if _, err := DecodeGIFFrames(bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Error("DecodeGIFFrames of a truncated GIF did not return an error")
+	}
+}
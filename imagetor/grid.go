@@ -0,0 +1,120 @@
+package imagetor
+
+import "fmt"
+
+// gridSpans splits size pixels into n spans whose lengths differ by at most
+// one, the longer spans coming first, and returns the start of each span
+// followed by size.
+func gridSpans(size, n int) []int {
+	bounds := make([]int, n+1)
+	for i := 0; i < n; i++ {
+		span := size / n
+		if i < size%n {
+			span++
+		}
+		bounds[i+1] = bounds[i] + span
+	}
+	return bounds
+}
+
+// SplitGrid divides an image into a grid of equally sized cells, for tiling
+// map imagery or processing regions separately.
+//
+// When the image does not divide evenly, the leftmost columns and topmost rows
+// of cells are one pixel larger than the others.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	cols: The number of columns of cells.
+//	rows: The number of rows of cells.
+//
+// Returns:
+//
+//	Copies of the cells in row-major order, or an error if the tensor is empty
+//	or ragged, or the grid is empty or has more columns or rows than the image
+//	has pixels.
+func SplitGrid(tensor [][][]float64, cols, rows int) ([][][][]float64, error) {
+	if cols < 1 || rows < 1 {
+		return nil, fmt.Errorf("invalid grid %dx%d", cols, rows)
+	}
+	height, width, err := dimsOf(tensor)
+	if err != nil {
+		return nil, fmt.Errorf("cannot split: %w", err)
+	}
+	if cols > width || rows > height {
+		return nil, fmt.Errorf("grid %dx%d is larger than image %dx%d", cols, rows, width, height)
+	}
+
+	xs, ys := gridSpans(width, cols), gridSpans(height, rows)
+	cells := make([][][][]float64, 0, cols*rows)
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			cell := newTensor(xs[i+1]-xs[i], ys[j+1]-ys[j])
+			for y := range cell {
+				for x := range cell[y] {
+					copy(cell[y][x], tensor[ys[j]+y][xs[i]+x])
+				}
+			}
+			cells = append(cells, cell)
+		}
+	}
+	return cells, nil
+}
+
+// MergeGrid stitches a grid of cells, as produced by SplitGrid, back into one
+// image.
+//
+// Args:
+//
+//	cells: The cells in row-major order.
+//	cols: The number of columns of cells.
+//	rows: The number of rows of cells.
+//
+// Returns:
+//
+//	The 3D tensor representing the stitched image, or an error if the number
+//	of cells does not match the grid, a cell is empty, or the cells of a row do
+//	not share a height or the cells of a column do not share a width.
+func MergeGrid(cells [][][][]float64, cols, rows int) ([][][]float64, error) {
+	if cols < 1 || rows < 1 {
+		return nil, fmt.Errorf("invalid grid %dx%d", cols, rows)
+	}
+	if len(cells) != cols*rows {
+		return nil, fmt.Errorf("grid %dx%d needs %d cells, got %d", cols, rows, cols*rows, len(cells))
+	}
+
+	xs, ys := make([]int, cols+1), make([]int, rows+1)
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			cell := cells[j*cols+i]
+			if len(cell) == 0 || len(cell[0]) == 0 {
+				return nil, fmt.Errorf("cell %d is empty", j*cols+i)
+			}
+			w, h := len(cell[0]), len(cell)
+			if j == 0 {
+				xs[i+1] = xs[i] + w
+			} else if w != xs[i+1]-xs[i] {
+				return nil, fmt.Errorf("cell %d is %d wide, expected %d to match its column", j*cols+i, w, xs[i+1]-xs[i])
+			}
+			if i == 0 {
+				ys[j+1] = ys[j] + h
+			} else if h != ys[j+1]-ys[j] {
+				return nil, fmt.Errorf("cell %d is %d high, expected %d to match its row", j*cols+i, h, ys[j+1]-ys[j])
+			}
+		}
+	}
+
+	tensor := newTensor(xs[cols], ys[rows])
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			cell := cells[j*cols+i]
+			for y := range cell {
+				for x := range cell[y] {
+					copy(tensor[ys[j]+y][xs[i]+x], cell[y][x])
+				}
+			}
+		}
+	}
+	return tensor, nil
+}
@@ -0,0 +1,124 @@
+package imagetor
+
+import "testing"
+
+// indexTensor returns an opaque tensor whose red channel numbers the pixels
+// in row-major order, so that every pixel can be told apart.
+func indexTensor(width, height int) [][][]float64 {
+	tensor := newTensor(width, height)
+	for y, row := range tensor {
+		for x, p := range row {
+			p[0], p[3] = float64(y*width+x), 1
+		}
+	}
+	return tensor
+}
+
+func TestSplitGrid(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		cols, rows    int
+		// wantCells holds the red channel of each cell in row-major order.
+		wantCells [][][]float64
+		wantErr   bool
+	}{
+		{"4x4 into quadrants", 4, 4, 2, 2, [][][]float64{
+			{{0, 1}, {4, 5}},
+			{{2, 3}, {6, 7}},
+			{{8, 9}, {12, 13}},
+			{{10, 11}, {14, 15}},
+		}, false},
+		{"uneven columns", 5, 1, 2, 1, [][][]float64{
+			{{0, 1, 2}},
+			{{3, 4}},
+		}, false},
+		{"uneven rows", 1, 3, 1, 2, [][][]float64{
+			{{0}, {1}},
+			{{2}},
+		}, false},
+		{"more columns than pixels", 2, 2, 3, 1, nil, true},
+		{"empty grid", 2, 2, 0, 1, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells, err := SplitGrid(indexTensor(tt.width, tt.height), tt.cols, tt.rows)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitGrid error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(cells) != len(tt.wantCells) {
+				t.Fatalf("got %d cells, want %d", len(cells), len(tt.wantCells))
+			}
+			for i, cell := range cells {
+				want := tt.wantCells[i]
+				if len(cell) != len(want) {
+					t.Fatalf("cell %d has %d rows, want %d", i, len(cell), len(want))
+				}
+				for y, row := range cell {
+					if len(row) != len(want[y]) {
+						t.Fatalf("cell %d row %d has %d pixels, want %d", i, y, len(row), len(want[y]))
+					}
+					for x, p := range row {
+						if p[0] != want[y][x] || p[3] != 1 {
+							t.Errorf("cell %d pixel (%d, %d) = %v, want red %v", i, x, y, p, want[y][x])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMergeGrid(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		cols, rows    int
+	}{
+		{"quadrants", 4, 4, 2, 2},
+		{"uneven", 7, 5, 3, 2},
+		{"single cell", 3, 2, 1, 1},
+		{"one pixel per cell", 3, 3, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := indexTensor(tt.width, tt.height)
+			cells, err := SplitGrid(source, tt.cols, tt.rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := MergeGrid(cells, tt.cols, tt.rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(got, source, 0) {
+				t.Error("split and merge did not reproduce the image")
+			}
+		})
+	}
+}
+
+func TestMergeGridErrors(t *testing.T) {
+	cells, err := SplitGrid(indexTensor(4, 4), 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		cells      [][][][]float64
+		cols, rows int
+	}{
+		{"too few cells", cells[:3], 2, 2},
+		{"empty grid", cells, 0, 4},
+		{"row heights differ", [][][][]float64{cells[0], indexTensor(2, 3), cells[2], cells[3]}, 2, 2},
+		{"column widths differ", [][][][]float64{cells[0], cells[1], indexTensor(3, 2), cells[3]}, 2, 2},
+		{"empty cell", [][][][]float64{cells[0], nil, cells[2], cells[3]}, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := MergeGrid(tt.cells, tt.cols, tt.rows); err == nil {
+				t.Error("MergeGrid did not return an error")
+			}
+		})
+	}
+}
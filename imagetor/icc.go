@@ -0,0 +1,243 @@
+package imagetor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// xyzD50ToLinearSRGB converts CIE XYZ relative to the D50 white point of the
+// ICC profile connection space to linear sRGB, including the Bradford
+// adaptation from D50 to the D65 white point of sRGB.
+var xyzD50ToLinearSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// iccCurve is a tone reproduction curve of an ICC profile, mapping an encoded
+// channel value in [0, 1] to linear light.
+type iccCurve func(v float64) float64
+
+// iccProfile is an RGB matrix/TRC ICC profile: three tone curves followed by
+// a matrix to the XYZ profile connection space.
+type iccProfile struct {
+	curves [3]iccCurve
+	// toXYZ holds the red, green and blue colorants as its columns.
+	toXYZ [3][3]float64
+}
+
+// s15Fixed16 decodes an ICC s15Fixed16Number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseICCCurve decodes a curveType ("curv") or parametricCurveType ("para")
+// tag.
+func parseICCCurve(tag []byte) (iccCurve, error) {
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("curve tag is truncated")
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if len(tag) < 12+2*n {
+			return nil, fmt.Errorf("curve table is truncated")
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:14])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			pos := clamp(v) * float64(n-1)
+			i := min(int(pos), n-2)
+			frac := pos - float64(i)
+			return table[i] + frac*(table[i+1]-table[i])
+		}, nil
+	case "para":
+		kind := binary.BigEndian.Uint16(tag[8:10])
+		counts := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+		count, ok := counts[kind]
+		if !ok {
+			return nil, fmt.Errorf("unsupported parametric curve type %d", kind)
+		}
+		if len(tag) < 12+4*count {
+			return nil, fmt.Errorf("parametric curve is truncated")
+		}
+		// Parameters g, a, b, c, d, e, f, as far as the type uses them.
+		var p [7]float64
+		for i := 0; i < count; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		return func(v float64) float64 {
+			switch kind {
+			case 0:
+				return math.Pow(v, g)
+			case 1:
+				if v >= -b/a {
+					return math.Pow(a*v+b, g)
+				}
+				return 0
+			case 2:
+				if v >= -b/a {
+					return math.Pow(a*v+b, g) + c
+				}
+				return c
+			case 3:
+				if v >= d {
+					return math.Pow(a*v+b, g)
+				}
+				return c * v
+			default:
+				if v >= d {
+					return math.Pow(a*v+b, g) + e
+				}
+				return c*v + f
+			}
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported curve type %q", tag[:4])
+	}
+}
+
+// parseICCProfile decodes the colorant and tone curve tags of an RGB
+// matrix/TRC profile. Profiles built on lookup tables are not supported.
+func parseICCProfile(data []byte) (*iccProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("not an ICC profile")
+	}
+	if space := string(data[16:20]); space != "RGB " {
+		return nil, fmt.Errorf("unsupported color space %q", space)
+	}
+	if pcs := string(data[20:24]); pcs != "XYZ " {
+		return nil, fmt.Errorf("unsupported connection space %q", pcs)
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:132]))
+	for i := 0; i < count; i++ {
+		entry := 132 + 12*i
+		if entry+12 > len(data) {
+			return nil, fmt.Errorf("tag table is truncated")
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, fmt.Errorf("tag %q is out of bounds", data[entry:entry+4])
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	var profile iccProfile
+	for i, name := range []string{"r", "g", "b"} {
+		colorant, ok := tags[name+"XYZ"]
+		if !ok || len(colorant) < 20 || string(colorant[:4]) != "XYZ " {
+			return nil, fmt.Errorf("profile has no usable %sXYZ tag", name)
+		}
+		for j := 0; j < 3; j++ {
+			profile.toXYZ[j][i] = s15Fixed16(colorant[8+4*j:])
+		}
+
+		trc, ok := tags[name+"TRC"]
+		if !ok {
+			return nil, fmt.Errorf("profile has no %sTRC tag", name)
+		}
+		curve, err := parseICCCurve(trc)
+		if err != nil {
+			return nil, err
+		}
+		profile.curves[i] = curve
+	}
+	return &profile, nil
+}
+
+// ConvertToSRGB converts an image from the color space described by an ICC
+// profile to sRGB, so that images tagged with wide-gamut profiles such as
+// Adobe RGB or Display P3 show their intended colors.
+//
+// RGB matrix/TRC profiles, the kind embedded by cameras, editors and phones,
+// are supported. Colors outside the sRGB gamut are clipped. Alpha is left
+// untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	icc: The ICC profile the image is encoded in.
+//
+// Returns:
+//
+//	An error if the profile is malformed or of an unsupported kind, in which
+//	case the tensor is left unchanged.
+func ConvertToSRGB(tensor *[][][]float64, icc []byte) error {
+	profile, err := parseICCProfile(icc)
+	if err != nil {
+		return err
+	}
+
+	// Combine the profile matrix with the conversion out of the connection
+	// space into one matrix from linear profile RGB to linear sRGB.
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += xyzD50ToLinearSRGB[i][k] * profile.toXYZ[k][j]
+			}
+		}
+	}
+
+	return parallelRows(len(*tensor), func(start, end int) {
+		for y := start; y < end; y++ {
+			for _, pixel := range (*tensor)[y] {
+				alpha := pixel[3]
+				if alpha <= 0 {
+					continue
+				}
+				// Tensors are premultiplied, and the curves apply to the
+				// unpremultiplied color.
+				var linear [3]float64
+				for c := 0; c < 3; c++ {
+					linear[c] = profile.curves[c](clamp(pixel[c] / alpha))
+				}
+				for c := 0; c < 3; c++ {
+					v := m[c][0]*linear[0] + m[c][1]*linear[1] + m[c][2]*linear[2]
+					pixel[c] = linearToSRGB(clamp(v)) * alpha
+				}
+			}
+		}
+	})
+}
+
+// DecodeTensorSRGB decodes an image from a reader to a tensor in the sRGB
+// color space.
+//
+// When the image embeds an ICC profile supported by ConvertToSRGB, its colors
+// are converted to sRGB and the profile is removed from the returned metadata,
+// since it no longer describes the tensor. Images without a profile, or with
+// an unsupported one, are assumed to be sRGB already and are left unchanged.
+//
+// Args:
+//
+//	r: The reader holding the encoded image.
+//
+// Returns:
+//
+//	The decoded tensor, its metadata and an error if the image cannot be decoded.
+func DecodeTensorSRGB(r io.Reader) ([][][]float64, Metadata, error) {
+	tensor, meta, err := DecodeTensorWithMetadata(r)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if meta.ICC != nil && ConvertToSRGB(&tensor, meta.ICC) == nil {
+		meta.ICC = nil
+	}
+	return tensor, meta, nil
+}
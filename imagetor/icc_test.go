@@ -0,0 +1,129 @@
+package imagetor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// Colorants, relative to D50, of the ICC profiles built by testICCProfile.
+var (
+	displayP3Colorants = [3][3]float64{
+		{0.5151, 0.2412, -0.0011},
+		{0.2919, 0.6922, 0.0419},
+		{0.1571, 0.0666, 0.7841},
+	}
+	srgbColorants = [3][3]float64{
+		{0.4361, 0.2225, 0.0139},
+		{0.3851, 0.7169, 0.0971},
+		{0.1431, 0.0606, 0.7141},
+	}
+)
+
+// displayP3ToSRGB converts linear Display P3 to linear sRGB.
+var displayP3ToSRGB = [3][3]float64{
+	{1.2249, -0.2247, 0},
+	{-0.0420, 1.0419, 0},
+	{-0.0197, -0.0786, 1.0979},
+}
+
+// testICCProfile builds a matrix/TRC ICC profile with the given red, green
+// and blue colorants and the sRGB tone curve on every channel.
+func testICCProfile(colorants [3][3]float64) []byte {
+	fixed := func(b []byte, v float64) []byte {
+		return binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+	}
+
+	var tags [][]byte
+	for _, xyz := range colorants {
+		tag := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range xyz {
+			tag = fixed(tag, v)
+		}
+		tags = append(tags, tag)
+	}
+	trc := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		trc = fixed(trc, v)
+	}
+	tags = append(tags, trc)
+
+	names := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	offset := 132 + 12*len(names)
+	var table, data []byte
+	table = binary.BigEndian.AppendUint32(table, uint32(len(names)))
+	offsets := make([]int, len(tags))
+	for i, tag := range tags {
+		offsets[i] = offset + len(data)
+		data = append(data, tag...)
+	}
+	for i, name := range names {
+		tag := min(i, 3) // The three TRC tags share one curve.
+		table = append(table, name...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offsets[tag]))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tags[tag])))
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header, uint32(offset+len(data)))
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	profile := append(header, table...)
+	return append(profile, data...)
+}
+
+func TestDecodeTensorSRGB(t *testing.T) {
+	color := [3]float64{0.6, 0.4, 0.3}
+	var linear, converted [3]float64
+	for c := range linear {
+		linear[c] = srgbToLinear(color[c])
+	}
+	for c := range converted {
+		v := 0.0
+		for k := range linear {
+			v += displayP3ToSRGB[c][k] * linear[k]
+		}
+		converted[c] = linearToSRGB(v)
+	}
+
+	tests := []struct {
+		name    string
+		icc     []byte
+		want    [3]float64
+		wantICC bool
+	}{
+		{"display p3", testICCProfile(displayP3Colorants), converted, false},
+		{"srgb profile", testICCProfile(srgbColorants), color, false},
+		{"no profile", nil, color, false},
+		{"unsupported profile", []byte("not a profile"), color, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := solidTensor(4, 4, [4]float64{color[0], color[1], color[2], 1})
+			png, err := embedPNGMetadata(encodeTestPNG(t, source), Metadata{ICC: tt.icc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tensor, meta, err := DecodeTensorSRGB(bytes.NewReader(png))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (meta.ICC != nil) != tt.wantICC {
+				t.Errorf("metadata kept ICC profile: %v, want %v", meta.ICC != nil, tt.wantICC)
+			}
+			got := tensor[2][2]
+			for c := range tt.want {
+				// 8-bit PNG values and 4-digit colorants limit the precision.
+				if !near(got[c], tt.want[c], 0.006) {
+					t.Fatalf("pixel = %v, want %v", got, tt.want)
+				}
+			}
+			if got[3] != 1 {
+				t.Errorf("alpha = %v, want 1", got[3])
+			}
+		})
+	}
+}
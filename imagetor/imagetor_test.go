@@ -0,0 +1,1181 @@
+package imagetor
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// near reports whether a and b differ by at most tol.
+func near(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol
+}
+
+// pixelsNear reports whether every channel of two equally sized tensors
+// differs by at most tol.
+func pixelsNear(a, b [][][]float64, tol float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for y := range a {
+		if len(a[y]) != len(b[y]) {
+			return false
+		}
+		for x := range a[y] {
+			for c := range a[y][x] {
+				if !near(a[y][x][c], b[y][x][c], tol) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// logoTensor returns a colorful 4x4 overlay whose alpha forms a plus sign,
+// opaque in the middle and half transparent on the arms. Its colors are
+// premultiplied.
+func logoTensor() [][][]float64 {
+	shape := [4][4]float64{
+		{0, 0.5, 0.5, 0},
+		{0.5, 1, 1, 0.5},
+		{0.5, 1, 1, 0.5},
+		{0, 0.5, 0.5, 0},
+	}
+	tensor := newTensor(4, 4)
+	for y, row := range tensor {
+		for x, p := range row {
+			a := shape[y][x]
+			p[0], p[1], p[2], p[3] = a*float64(x)/3, a*float64(y)/3, a*0.5, a
+		}
+	}
+	return tensor
+}
+
+func TestAddTintedOverlay(t *testing.T) {
+	tests := []struct {
+		name string
+		tint [4]float64
+	}{
+		{"white", [4]float64{1, 1, 1, 1}},
+		{"half transparent white", [4]float64{1, 1, 1, 0.5}},
+		{"red", [4]float64{1, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTensor(4, 4)
+			for _, row := range target {
+				for _, p := range row {
+					p[3] = 1
+				}
+			}
+			overlay := logoTensor()
+			tint := tt.tint
+
+			if err := AddTintedOverlay(&target, &overlay, &tint); err != nil {
+				t.Fatal(err)
+			}
+			// Over black, the silhouette is the tint scaled by the alpha of
+			// the logo, whatever its original colors.
+			logo := logoTensor()
+			for y, row := range target {
+				for x, p := range row {
+					coverage := logo[y][x][3] * tt.tint[3]
+					for c := 0; c < 3; c++ {
+						if want := tt.tint[c] * coverage; !near(p[c], want, 1e-9) {
+							t.Fatalf("pixel (%d, %d) = %v, want channel %d = %v", x, y, p, c, want)
+						}
+					}
+					if p[3] != 1 {
+						t.Fatalf("pixel (%d, %d) alpha = %v, want 1", x, y, p[3])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAddOverlayAlpha(t *testing.T) {
+	tests := []struct {
+		name        string
+		under, over float64
+		wantAlpha   float64
+	}{
+		{"half over transparent", 0, 0.5, 0.5},
+		{"transparent over transparent", 0, 0, 0},
+		{"opaque over transparent", 0, 1, 1},
+		{"half over half", 0.5, 0.5, 0.75},
+		{"half over opaque", 1, 0.5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Premultiplied blue under premultiplied white.
+			target := solidTensor(3, 3, [4]float64{0, 0, tt.under, tt.under})
+			overlay := solidTensor(3, 3, [4]float64{tt.over, tt.over, tt.over, tt.over})
+			if err := AddOverlay(&target, &overlay); err != nil {
+				t.Fatal(err)
+			}
+			want := []float64{tt.over, tt.over, tt.over + tt.under*(1-tt.over), tt.wantAlpha}
+			for y, row := range target {
+				for x, p := range row {
+					for c := range p {
+						if !near(p[c], want[c], 1e-9) {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, p, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// centerDistance returns the root sum of squared differences between the red
+// channels of two 32x32 tensors over their central 16x16 pixels, away from
+// the corners that rotation leaves empty.
+func centerDistance(a, b [][][]float64) float64 {
+	sum := 0.0
+	for y := 8; y < 24; y++ {
+		for x := 8; x < 24; x++ {
+			d := a[y][x][0] - b[y][x][0]
+			sum += d * d
+		}
+	}
+	return math.Sqrt(sum)
+}
+
+func TestRotateSupersampled(t *testing.T) {
+	line := newTensor(32, 32)
+	for _, row := range line {
+		for x, p := range row {
+			if x == 16 || x == 17 {
+				p[0], p[1], p[2] = 1, 1, 1
+			}
+			p[3] = 1
+		}
+	}
+
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+		angle  float64
+	}{
+		{"edge at 30 degrees", edgeTensor(32, 32, 0, 1), 30},
+		{"edge at 45 degrees", edgeTensor(32, 32, 0, 1), 45},
+		{"thin line at 10 degrees", line, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A rotation rendered at a much higher resolution stands in for
+			// the ideal anti-aliased edge.
+			ideal := cloneTensor(tt.tensor)
+			if err := RotateSupersampled(&ideal, tt.angle, 8); err != nil {
+				t.Fatal(err)
+			}
+
+			plain := cloneTensor(tt.tensor)
+			if err := Rotate(&plain, tt.angle); err != nil {
+				t.Fatal(err)
+			}
+			single := cloneTensor(tt.tensor)
+			if err := RotateSupersampled(&single, tt.angle, 1); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(single, plain, 0) {
+				t.Error("supersample 1 differs from Rotate")
+			}
+
+			smooth := cloneTensor(tt.tensor)
+			if err := RotateSupersampled(&smooth, tt.angle, 2); err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(smooth); w != 32 || h != 32 {
+				t.Fatalf("rotated image is %dx%d, want 32x32", w, h)
+			}
+			stepped, smoothed := centerDistance(plain, ideal), centerDistance(smooth, ideal)
+			if smoothed > 0.5*stepped {
+				t.Errorf("distance from the ideal edge is %v with supersample 2 and %v without", smoothed, stepped)
+			}
+		})
+	}
+}
+
+func TestResizeZeroDimension(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+		wantErr               bool
+	}{
+		{"auto height", 100, 0, 100, 50, false},
+		{"auto width", 0, 25, 50, 25, false},
+		{"auto height rounds", 3, 0, 3, 2, false},
+		{"auto height at least one row", 1, 0, 1, 1, false},
+		{"both zero", 0, 0, 200, 100, false},
+		{"explicit", 40, 40, 40, 40, false},
+		{"negative", -10, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := solidTensor(200, 100, [4]float64{0.2, 0.4, 0.6, 1})
+			err := Resize(&tensor, tt.width, tt.height)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resize error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if w, h, _ := Dimensions(tensor); w != tt.wantWidth || h != tt.wantHeight {
+				t.Errorf("resized to %dx%d, want %dx%d", w, h, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+// rotateReference is the serial Rotate that renders into a temporary tensor
+// and copies it back row by row, kept to check and benchmark the parallel
+// version against.
+func rotateReference(tensor *[][][]float64, angle float64) {
+	height, width := len(*tensor), len((*tensor)[0])
+	centerX, centerY := float64(width)/2.0, float64(height)/2.0
+	radians := angle * math.Pi / 180
+	sin, cos := math.Sin(radians), math.Cos(radians)
+
+	temp := newTensor(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			rotateX, rotateY := float64(x)-centerX, float64(y)-centerY
+			originalX := rotateX*cos + rotateY*sin + centerX
+			originalY := -rotateX*sin + rotateY*cos + centerY
+			if originalX < 0 || originalX >= float64(width) || originalY < 0 || originalY >= float64(height) {
+				continue
+			}
+			x1, y1 := int(math.Floor(originalX)), int(math.Floor(originalY))
+			x2, y2 := min(x1+1, width-1), min(y1+1, height-1)
+			dx, dy := originalX-math.Floor(originalX), originalY-math.Floor(originalY)
+			for c := 0; c < channels; c++ {
+				temp[y][x][c] = (1-dx)*(1-dy)*(*tensor)[y1][x1][c] +
+					dx*(1-dy)*(*tensor)[y1][x2][c] +
+					(1-dx)*dy*(*tensor)[y2][x1][c] +
+					dx*dy*(*tensor)[y2][x2][c]
+			}
+		}
+	}
+	for y := range temp {
+		for x := range temp[y] {
+			copy((*tensor)[y][x], temp[y][x])
+		}
+	}
+}
+
+func TestRotateMatchesReference(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		angle         float64
+	}{
+		{"square 30", 40, 40, 30},
+		{"wide 45", 64, 24, 45},
+		{"tall -100", 17, 53, -100},
+		{"zero", 9, 9, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := stripesTensor(tt.width, tt.height)
+			rotateReference(&want, tt.angle)
+			got := stripesTensor(tt.width, tt.height)
+			if err := Rotate(&got, tt.angle); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(got, want, 0) {
+				t.Error("Rotate differs from the reference rotation")
+			}
+		})
+	}
+}
+
+func BenchmarkRotate(b *testing.B) {
+	source := stripesTensor(1000, 750)
+	for _, bm := range []struct {
+		name   string
+		rotate func(*[][][]float64) error
+	}{
+		{"copy back", func(t *[][][]float64) error {
+			rotateReference(t, 30)
+			return nil
+		}},
+		{"swap", func(t *[][][]float64) error { return Rotate(t, 30) }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tensor := cloneTensor(source)
+				b.StartTimer()
+				if err := bm.rotate(&tensor); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFlipHorizontal(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+		want   [][][]float64
+	}{
+		{"odd width", indexTensor(3, 2), [][][]float64{
+			{{2, 0, 0, 1}, {1, 0, 0, 1}, {0, 0, 0, 1}},
+			{{5, 0, 0, 1}, {4, 0, 0, 1}, {3, 0, 0, 1}},
+		}},
+		{"even width", indexTensor(4, 1), [][][]float64{
+			{{3, 0, 0, 1}, {2, 0, 0, 1}, {1, 0, 0, 1}, {0, 0, 0, 1}},
+		}},
+		{"single column", indexTensor(1, 3), [][][]float64{
+			{{0, 0, 0, 1}},
+			{{1, 0, 0, 1}},
+			{{2, 0, 0, 1}},
+		}},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			FlipHorizontal(&tt.tensor)
+			if !pixelsNear(tt.tensor, tt.want, 0) {
+				t.Errorf("FlipHorizontal = %v, want %v", tt.tensor, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlipHorizontalInPlace(t *testing.T) {
+	tensor := indexTensor(5, 2)
+	middle := tensor[1][2]
+	row := tensor[0]
+	FlipHorizontal(&tensor)
+	if &tensor[0][0] != &row[0] {
+		t.Error("FlipHorizontal reallocated a row")
+	}
+	if &tensor[1][2][0] != &middle[0] || middle[0] != 7 {
+		t.Errorf("middle pixel moved or changed to %v", tensor[1][2])
+	}
+}
+
+func TestAddOverlayInPlace(t *testing.T) {
+	tests := []struct {
+		name                  string
+		overlay               [][][]float64
+		wantWidth, wantHeight int
+		// x0, y0, x1 and y1 bound the target pixels the overlay covers.
+		x0, y0, x1, y1 int
+	}{
+		{"small overlay", solidTensor(2, 2, [4]float64{1, 1, 1, 1}), 2, 2, 3, 3, 5, 5},
+		{"overlay scaled down", solidTensor(16, 8, [4]float64{1, 1, 1, 1}), 8, 4, 0, 2, 8, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := solidTensor(8, 8, [4]float64{0, 0, 0, 1})
+			rows, pixel := &target[0], &target[4][4][0]
+			overlay := tt.overlay
+
+			if err := AddOverlay(&target, &overlay); err != nil {
+				t.Fatal(err)
+			}
+			if &target[0] != rows || &target[4][4][0] != pixel {
+				t.Error("AddOverlay replaced the target instead of modifying it")
+			}
+			for y, row := range target {
+				for x, p := range row {
+					inside := x >= tt.x0 && x < tt.x1 && y >= tt.y0 && y < tt.y1
+					if (p[0] == 1) != inside {
+						t.Fatalf("pixel (%d, %d) = %v, overlaid %v", x, y, p, inside)
+					}
+				}
+			}
+			if w, h, _ := Dimensions(overlay); w != tt.wantWidth || h != tt.wantHeight {
+				t.Errorf("overlay is %dx%d afterwards, want %dx%d", w, h, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestAddOverlayEmpty(t *testing.T) {
+	tests := []struct {
+		name            string
+		target, overlay [][][]float64
+		wantPrefix      string
+	}{
+		{"empty target", nil, solidTensor(2, 2, [4]float64{1, 1, 1, 1}), "target: "},
+		{"empty overlay", solidTensor(2, 2, [4]float64{1, 1, 1, 1}), nil, "overlay: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AddOverlay(&tt.target, &tt.overlay)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantPrefix) {
+				t.Errorf("AddOverlay error = %v, want prefix %q", err, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestTensorToImageClamps(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		want  uint32
+	}{
+		{"above one", 1.5, 65535},
+		{"negative", -0.2, 0},
+		{"one", 1, 65535},
+		{"zero", 0, 0},
+		{"half", 0.5, 32767},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.value
+			img, err := TensorToImage([][][]float64{{{v, v, v, 1}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, g, b, a := img.At(0, 0).RGBA()
+			// image.RGBA keeps 8 bits per channel, so compare at that depth.
+			want := (tt.want >> 8) * 0x101
+			if r != want || g != want || b != want || a != 65535 {
+				t.Errorf("pixel = %d, %d, %d, %d, want %d, %d, %d, 65535", r, g, b, a, want, want, want)
+			}
+		})
+	}
+}
+
+func TestRotateInterpolates(t *testing.T) {
+	tests := []struct {
+		name              string
+		angle             float64
+		wantIntermediates bool
+	}{
+		{"no rotation", 0, false},
+		{"10 degrees", 10, true},
+		{"30 degrees", 30, true},
+		{"45 degrees", 45, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := edgeTensor(16, 16, 0, 1)
+			if err := Rotate(&tensor, tt.angle); err != nil {
+				t.Fatal(err)
+			}
+			// Count the anti-aliased pixels in the middle, which the rotation
+			// does not leave empty.
+			intermediates := 0
+			for y := 4; y < 12; y++ {
+				for x := 4; x < 12; x++ {
+					if v := tensor[y][x][0]; v > 0.05 && v < 0.95 {
+						intermediates++
+					}
+				}
+			}
+			if (intermediates > 0) != tt.wantIntermediates {
+				t.Errorf("%d pixels hold intermediate values, want some: %v", intermediates, tt.wantIntermediates)
+			}
+			if !tt.wantIntermediates && !pixelsNear(tensor, edgeTensor(16, 16, 0, 1), 0) {
+				t.Error("rotating by 0 changed the image")
+			}
+		})
+	}
+}
+
+// zeroColumn returns the first column of tensor whose pixels are all zero, or
+// -1 if every column holds some data.
+func zeroColumn(tensor [][][]float64) int {
+	width, _, _ := Dimensions(tensor)
+	for x := 0; x < width; x++ {
+		empty := true
+		for _, row := range tensor {
+			if row[x][0] != 0 || row[x][3] != 0 {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			return x
+		}
+	}
+	return -1
+}
+
+func TestPrimeWidthColumns(t *testing.T) {
+	red := [4]float64{1, 0, 0, 1}
+	tests := []struct {
+		name string
+		run  func() ([][][]float64, error)
+	}{
+		{"TensorToImage and ImageToTensor", func() ([][][]float64, error) {
+			img, err := TensorToImage(solidTensor(101, 7, red))
+			if err != nil {
+				return nil, err
+			}
+			return ImageToTensor(img)
+		}},
+		{"Resize", func() ([][][]float64, error) {
+			tensor := solidTensor(50, 5, red)
+			return tensor, Resize(&tensor, 101, 7)
+		}},
+		{"AddOverlay", func() ([][][]float64, error) {
+			target := newTensor(101, 7)
+			overlay := solidTensor(101, 7, red)
+			return target, AddOverlay(&target, &overlay)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, workers := range []int{3, 4, 8} {
+				withWorkers(t, workers)
+				tensor, err := tt.run()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if w, _, _ := Dimensions(tensor); w != 101 {
+					t.Fatalf("width = %d, want 101", w)
+				}
+				if x := zeroColumn(tensor); x >= 0 {
+					t.Errorf("column %d is all zero with %d workers", x, workers)
+				}
+			}
+		})
+	}
+}
+
+func TestResizeFit(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		maxWidth, maxHeight   int
+		wantWidth, wantHeight int
+	}{
+		{"landscape", 400, 300, 200, 200, 200, 150},
+		{"portrait", 300, 400, 200, 200, 150, 200},
+		{"upscaled", 100, 50, 400, 400, 400, 200},
+		{"odd ratio", 1003, 601, 100, 100, 100, 60},
+		{"already fits", 64, 48, 64, 48, 64, 48},
+		{"thin", 500, 2, 50, 50, 50, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := gradientTensor(tt.width, tt.height)
+			if err := ResizeFit(&tensor, tt.maxWidth, tt.maxHeight); err != nil {
+				t.Fatal(err)
+			}
+			w, h, _ := Dimensions(tensor)
+			if w != tt.wantWidth || h != tt.wantHeight {
+				t.Errorf("ResizeFit = %dx%d, want %dx%d", w, h, tt.wantWidth, tt.wantHeight)
+			}
+			if w > tt.maxWidth || h > tt.maxHeight {
+				t.Errorf("%dx%d does not fit in %dx%d", w, h, tt.maxWidth, tt.maxHeight)
+			}
+			if exact := float64(w) * float64(tt.height) / float64(tt.width); math.Abs(exact-float64(h)) > 1 {
+				t.Errorf("height %d is more than a pixel from %v, the height keeping the ratio", h, exact)
+			}
+		})
+	}
+}
+
+func TestResizeSolidColor(t *testing.T) {
+	tests := []struct {
+		name                string
+		fill                [4]float64
+		width, height       int
+		newWidth, newHeight int
+	}{
+		{"2x upscale", [4]float64{0.8, 0.4, 0.2, 1}, 10, 10, 20, 20},
+		{"odd factor", [4]float64{0.1, 0.9, 0.5, 1}, 7, 5, 23, 17},
+		{"from one pixel", [4]float64{1, 1, 1, 1}, 1, 1, 9, 6},
+		{"translucent", [4]float64{0.3, 0.15, 0.05, 0.5}, 4, 6, 13, 19},
+		{"downscale", [4]float64{0.2, 0.2, 0.7, 1}, 31, 29, 10, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := solidTensor(tt.width, tt.height, tt.fill)
+			if err := Resize(&tensor, tt.newWidth, tt.newHeight); err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(tensor); w != tt.newWidth || h != tt.newHeight {
+				t.Fatalf("resized to %dx%d, want %dx%d", w, h, tt.newWidth, tt.newHeight)
+			}
+			for y, row := range tensor {
+				for x, p := range row {
+					for c, v := range tt.fill {
+						if !near(p[c], v, 1e-9) {
+							t.Fatalf("pixel (%d, %d) = %v, want the fill %v", x, y, p, tt.fill)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAddOverlayAt(t *testing.T) {
+	tests := []struct {
+		name             string
+		overlay          [][][]float64
+		offsetX, offsetY int
+		// x0, y0, x1 and y1 bound the target pixels the overlay covers.
+		x0, y0, x1, y1 int
+	}{
+		{"bottom right corner", solidTensor(3, 2, [4]float64{1, 1, 1, 1}), 7, 6, 7, 6, 10, 8},
+		{"off the bottom right edge", solidTensor(3, 2, [4]float64{1, 1, 1, 1}), 8, 7, 8, 7, 10, 8},
+		{"off the top left edge", solidTensor(3, 2, [4]float64{1, 1, 1, 1}), -1, -1, 0, 0, 2, 1},
+		{"entirely outside", solidTensor(3, 2, [4]float64{1, 1, 1, 1}), 20, -5, 0, 0, 0, 0},
+		{"larger than the target", solidTensor(16, 16, [4]float64{1, 1, 1, 1}), -2, 4, 0, 4, 10, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := solidTensor(10, 8, [4]float64{0, 0, 0, 1})
+			overlay := tt.overlay
+			overlayWidth, overlayHeight, _ := Dimensions(overlay)
+
+			if err := AddOverlayAt(&target, &overlay, tt.offsetX, tt.offsetY); err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range target {
+				for x, p := range row {
+					inside := x >= tt.x0 && x < tt.x1 && y >= tt.y0 && y < tt.y1
+					if (p[0] == 1) != inside {
+						t.Fatalf("pixel (%d, %d) = %v, overlaid %v", x, y, p, inside)
+					}
+				}
+			}
+			if w, h, _ := Dimensions(overlay); w != overlayWidth || h != overlayHeight {
+				t.Errorf("overlay was resized to %dx%d", w, h)
+			}
+		})
+	}
+}
+
+func TestAddOverlayAllRows(t *testing.T) {
+	tests := []struct {
+		name                        string
+		workers, stripe             int
+		overlayHeight, targetHeight int
+	}{
+		{"4 workers", 4, 64, 37, 40},
+		{"3 workers, short stripes", 3, 5, 37, 40},
+		{"7 workers, single row stripes", 7, 1, 30, 33},
+		{"more workers than rows", 16, 1, 5, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withWorkers(t, tt.workers)
+			withStripeHeight(t, tt.stripe)
+			target := solidTensor(6, tt.targetHeight, [4]float64{0, 0, 0, 1})
+			overlay := solidTensor(6, tt.overlayHeight, [4]float64{1, 0, 0, 1})
+			if err := AddOverlay(&target, &overlay); err != nil {
+				t.Fatal(err)
+			}
+
+			// The overlay is centered, so it starts below the top row.
+			top := (tt.targetHeight - tt.overlayHeight) / 2
+			for y, row := range target {
+				want := 0.0
+				if y >= top && y < top+tt.overlayHeight {
+					want = 1
+				}
+				for x, p := range row {
+					if p[0] != want {
+						t.Fatalf("pixel (%d, %d) = %v, want red %v", x, y, p, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAddOverlayWithOpacity(t *testing.T) {
+	tests := []struct {
+		name    string
+		opacity float64
+		over    [4]float64
+		want    []float64
+	}{
+		{"opacity 0", 0, [4]float64{1, 1, 1, 1}, []float64{0, 0, 1, 1}},
+		{"opacity 0.5", 0.5, [4]float64{1, 1, 1, 1}, []float64{0.5, 0.5, 1, 1}},
+		{"opacity 1", 1, [4]float64{1, 1, 1, 1}, []float64{1, 1, 1, 1}},
+		{"opacity 0.3", 0.3, [4]float64{1, 0, 0, 1}, []float64{0.3, 0, 0.7, 1}},
+		{"half transparent overlay", 0.5, [4]float64{0.5, 0.5, 0.5, 0.5}, []float64{0.25, 0.25, 1, 1}},
+		{"clamped above 1", 2, [4]float64{1, 1, 1, 1}, []float64{1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Premultiplied overlay over opaque blue.
+			target := solidTensor(4, 4, [4]float64{0, 0, 1, 1})
+			overlay := solidTensor(4, 4, tt.over)
+			if err := AddOverlayWithOpacity(&target, &overlay, tt.opacity); err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range target {
+				for x, p := range row {
+					for c := range p {
+						if !near(p[c], tt.want[c], 1e-9) {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, p, tt.want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMalformedTensors(t *testing.T) {
+	good := solidTensor(4, 4, [4]float64{1, 1, 1, 1})
+	tensors := []struct {
+		name   string
+		tensor [][][]float64
+	}{
+		{"nil", nil},
+		{"no rows", [][][]float64{}},
+		{"empty rows", [][][]float64{{}, {}}},
+		{"ragged", [][][]float64{{{0, 0, 0, 1}, {0, 0, 0, 1}}, {{0, 0, 0, 1}}}},
+		{"too few channels", [][][]float64{{{0, 0, 0}, {0, 0, 0}}}},
+	}
+	funcs := []struct {
+		name string
+		call func(tensor [][][]float64) error
+	}{
+		{"TensorToImage", func(tensor [][][]float64) error {
+			_, err := TensorToImage(tensor)
+			return err
+		}},
+		{"Resize", func(tensor [][][]float64) error {
+			return Resize(&tensor, 8, 8)
+		}},
+		{"ScaleFactor", func(tensor [][][]float64) error {
+			_, err := ScaleFactor(good, tensor)
+			return err
+		}},
+		{"AddOverlay target", func(tensor [][][]float64) error {
+			overlay := cloneTensor(good)
+			return AddOverlay(&tensor, &overlay)
+		}},
+		{"AddOverlay overlay", func(tensor [][][]float64) error {
+			target := cloneTensor(good)
+			return AddOverlay(&target, &tensor)
+		}},
+		{"Rotate90", func(tensor [][][]float64) error {
+			return Rotate90(&tensor, 1)
+		}},
+		{"GrayScale", func(tensor [][][]float64) error {
+			return GrayScale(&tensor)
+		}},
+		{"UpSideDown", func(tensor [][][]float64) error {
+			return UpSideDown(&tensor)
+		}},
+		{"Rotate", func(tensor [][][]float64) error {
+			return Rotate(&tensor, 30)
+		}},
+	}
+	for _, f := range funcs {
+		for _, tt := range tensors {
+			t.Run(f.name+"/"+tt.name, func(t *testing.T) {
+				if err := f.call(tt.tensor); err == nil {
+					t.Error("no error returned")
+				}
+			})
+		}
+	}
+}
+
+// genericImage hides the concrete type of an image, forcing ImageToTensor to
+// read it through the image.Image interface.
+type genericImage struct {
+	image.Image
+}
+
+// fastPathImages returns an image of each type ImageToTensor reads directly,
+// with a pattern of translucent colors. Their bounds do not start at the
+// origin.
+func fastPathImages(width, height int) []struct {
+	name string
+	img  image.Image
+} {
+	r := image.Rect(3, 5, 3+width, 5+height)
+	rgba, nrgba := image.NewRGBA(r), image.NewNRGBA(r)
+	ycbcr := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBA{uint8(x * 7), uint8(y * 5), uint8(x * y), uint8(128 + x%128)}
+			rgba.Set(x, y, c)
+			nrgba.SetNRGBA(x, y, c)
+			ycbcr.Y[ycbcr.YOffset(x, y)] = uint8(x*3 + y)
+			ycbcr.Cb[ycbcr.COffset(x, y)] = uint8(x * 2)
+			ycbcr.Cr[ycbcr.COffset(x, y)] = uint8(255 - y*2)
+		}
+	}
+	return []struct {
+		name string
+		img  image.Image
+	}{{"RGBA", rgba}, {"NRGBA", nrgba}, {"YCbCr", ycbcr}}
+}
+
+func TestImageToTensorFastPaths(t *testing.T) {
+	for _, tt := range fastPathImages(37, 29) {
+		t.Run(tt.name, func(t *testing.T) {
+			fast, err := ImageToTensor(tt.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			generic, err := ImageToTensor(genericImage{tt.img})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(fast); w != 37 || h != 29 {
+				t.Fatalf("tensor is %dx%d, want 37x29", w, h)
+			}
+			if !pixelsNear(fast, generic, 0) {
+				t.Error("fast path differs from the generic path")
+			}
+		})
+	}
+}
+
+func BenchmarkImageToTensor(b *testing.B) {
+	for _, src := range fastPathImages(4000, 3000) {
+		for _, bm := range []struct {
+			name string
+			img  image.Image
+		}{
+			{"fast", src.img},
+			{"generic", genericImage{src.img}},
+		} {
+			b.Run(src.name+"/"+bm.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := ImageToTensor(bm.img); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// countdownContext is a context that reports being cancelled once Err has
+// been called a given number of times, to cancel an operation partway.
+type countdownContext struct {
+	context.Context
+	remaining atomic.Int64
+}
+
+func newCountdownContext(calls int) *countdownContext {
+	ctx := &countdownContext{Context: context.Background()}
+	ctx.remaining.Store(int64(calls))
+	return ctx
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestResizeCtxCancel(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wantErr error
+	}{
+		{"cancelled before starting", cancelled, context.Canceled},
+		{"cancelled after a few rows", newCountdownContext(5), context.Canceled},
+		{"not cancelled", context.Background(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Check the context before every row.
+			withWorkers(t, 1)
+			withStripeHeight(t, 1)
+			source := gradientTensor(30, 20)
+			tensor := cloneTensor(source)
+
+			err := ResizeCtx(tt.ctx, &tensor, 60, 40)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResizeCtx error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				if w, h, _ := Dimensions(tensor); w != 60 || h != 40 {
+					t.Errorf("resized to %dx%d, want 60x40", w, h)
+				}
+				return
+			}
+			if !pixelsNear(tensor, source, 0) {
+				t.Error("cancelled resize modified the tensor")
+			}
+		})
+	}
+}
+
+func TestScaleFactorAndOverlayRect(t *testing.T) {
+	tests := []struct {
+		name                        string
+		targetWidth, targetHeight   int
+		overlayWidth, overlayHeight int
+		wantFactor                  float64
+		wantRect                    image.Rectangle
+	}{
+		{"smaller than the target", 100, 80, 20, 10, 1, image.Rect(40, 35, 60, 45)},
+		{"wider than the target", 100, 80, 400, 100, 0.25, image.Rect(0, 27, 100, 52)},
+		{"taller than the target", 100, 80, 50, 160, 0.5, image.Rect(37, 0, 62, 80)},
+		{"same size", 100, 80, 100, 80, 1, image.Rect(0, 0, 100, 80)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := solidTensor(tt.targetWidth, tt.targetHeight, [4]float64{0, 0, 0, 1})
+			overlay := solidTensor(tt.overlayWidth, tt.overlayHeight, [4]float64{1, 1, 1, 1})
+
+			factor, err := ScaleFactor(target, overlay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !near(factor, tt.wantFactor, 1e-12) {
+				t.Errorf("ScaleFactor = %v, want %v", factor, tt.wantFactor)
+			}
+			rect, err := OverlayRect(target, overlay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rect != tt.wantRect {
+				t.Errorf("OverlayRect = %v, want %v", rect, tt.wantRect)
+			}
+
+			// AddOverlay covers exactly the rectangle.
+			if err := AddOverlay(&target, &overlay); err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range target {
+				for x, p := range row {
+					if inside := image.Pt(x, y).In(rect); (p[0] == 1) != inside {
+						t.Fatalf("pixel (%d, %d) = %v, inside the rectangle %v", x, y, p, inside)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGrayScaleMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    GrayMode
+		want    float64
+		wantErr bool
+	}{
+		{"luminosity", GrayLuminosity, 0.2126*0.9 + 0.7152*0.3, false},
+		{"average", GrayAverage, 0.4, false},
+		{"lightness", GrayLightness, 0.45, false},
+		{"negative mode", GrayMode(-1), 0, true},
+		{"mode past the last", GrayLightness + 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The same color opaque and half transparent, premultiplied.
+			source := [][][]float64{{{0.9, 0.3, 0, 1}, {0.45, 0.15, 0, 0.5}}}
+			tensor := cloneTensor(source)
+			err := GrayScaleMode(&tensor, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GrayScaleMode error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !pixelsNear(tensor, source, 0) {
+					t.Error("failed conversion changed the image")
+				}
+				return
+			}
+			for x, p := range tensor[0] {
+				want := tt.want * source[0][x][3]
+				for c := 0; c < 3; c++ {
+					if !near(p[c], want, 1e-12) {
+						t.Fatalf("pixel %d = %v, want gray %v", x, p, want)
+					}
+				}
+				if p[3] != source[0][x][3] {
+					t.Errorf("pixel %d alpha changed to %v", x, p[3])
+				}
+			}
+		})
+	}
+
+	t.Run("GrayScale is luminosity", func(t *testing.T) {
+		want, got := gradientTensor(9, 7), gradientTensor(9, 7)
+		if err := GrayScaleMode(&want, GrayLuminosity); err != nil {
+			t.Fatal(err)
+		}
+		if err := GrayScale(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !pixelsNear(got, want, 0) {
+			t.Error("GrayScale differs from GrayLuminosity")
+		}
+	})
+}
+
+func TestDimensions(t *testing.T) {
+	tests := []struct {
+		name                    string
+		tensor                  [][][]float64
+		width, height, channels int
+	}{
+		{"nil", nil, 0, 0, 0},
+		{"no rows", [][][]float64{}, 0, 0, 0},
+		{"empty rows", [][][]float64{{}, {}}, 0, 0, 0},
+		{"single pixel", [][][]float64{{{0.1, 0.2, 0.3, 1}}}, 1, 1, 4},
+		{"normal", newTensor(7, 5), 7, 5, 4},
+		{"three channels", [][][]float64{{{0, 0, 0}, {0, 0, 0}}}, 2, 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, c := Dimensions(tt.tensor)
+			if w != tt.width || h != tt.height || c != tt.channels {
+				t.Errorf("Dimensions = %d, %d, %d, want %d, %d, %d", w, h, c, tt.width, tt.height, tt.channels)
+			}
+		})
+	}
+}
+
+func TestFlip(t *testing.T) {
+	// The source is 3x3:
+	//
+	//	0 1 2
+	//	3 4 5
+	//	6 7 8
+	tests := []struct {
+		name string
+		axis FlipAxis
+		want [][]float64
+	}{
+		{"vertically", FlipVertically, [][]float64{{6, 7, 8}, {3, 4, 5}, {0, 1, 2}}},
+		{"horizontally", FlipHorizontally, [][]float64{{2, 1, 0}, {5, 4, 3}, {8, 7, 6}}},
+		{"both", FlipBoth, [][]float64{{8, 7, 6}, {5, 4, 3}, {2, 1, 0}}},
+		{"unknown axis", FlipBoth + 1, [][]float64{{0, 1, 2}, {3, 4, 5}, {6, 7, 8}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := indexTensor(3, 3)
+			Flip(&tensor, tt.axis)
+			got := reds(tensor)
+			for y := range tt.want {
+				for x := range tt.want[y] {
+					if got[y][x] != tt.want[y][x] {
+						t.Fatalf("Flip = %v, want %v", got, tt.want)
+					}
+				}
+			}
+
+			Flip(&tensor, tt.axis)
+			if !pixelsNear(tensor, indexTensor(3, 3), 0) {
+				t.Error("flipping twice did not restore the image")
+			}
+		})
+	}
+
+	t.Run("both is a half turn", func(t *testing.T) {
+		flipped, rotated := indexTensor(5, 4), indexTensor(5, 4)
+		Flip(&flipped, FlipBoth)
+		if err := Rotate90(&rotated, 2); err != nil {
+			t.Fatal(err)
+		}
+		if !pixelsNear(flipped, rotated, 0) {
+			t.Error("FlipBoth differs from Rotate90 by two quarter turns")
+		}
+	})
+}
+
+func TestTileOverlay(t *testing.T) {
+	white := [4]float64{1, 1, 1, 1}
+	tests := []struct {
+		name               string
+		overlay            [][][]float64
+		spacingX, spacingY int
+		// covered reports whether a tile covers the target pixel at (x, y).
+		covered func(x, y int) bool
+	}{
+		{"spaced grid", solidTensor(2, 2, white), 1, 1, func(x, y int) bool {
+			return x%3 < 2 && y%3 < 2
+		}},
+		{"uneven spacing", solidTensor(3, 1, white), 2, 3, func(x, y int) bool {
+			return x%5 < 3 && y%4 == 0
+		}},
+		{"no spacing", solidTensor(2, 2, white), 0, 0, func(x, y int) bool {
+			return true
+		}},
+		{"negative spacing", solidTensor(2, 2, white), -4, -1, func(x, y int) bool {
+			return true
+		}},
+		{"scaled down", solidTensor(20, 20, white), 1, 0, func(x, y int) bool {
+			// The overlay is scaled to 7x7, so the second tile starts at 8.
+			return x != 7
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := solidTensor(10, 7, [4]float64{0, 0, 0, 1})
+			overlay := cloneTensor(tt.overlay)
+			if err := TileOverlay(&target, overlay, tt.spacingX, tt.spacingY); err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range target {
+				for x, p := range row {
+					if covered := tt.covered(x, y); (p[0] == 1) != covered {
+						t.Fatalf("pixel (%d, %d) = %v, covered by a tile %v", x, y, p, covered)
+					}
+				}
+			}
+			if !pixelsNear(overlay, tt.overlay, 0) {
+				t.Error("TileOverlay changed the overlay")
+			}
+		})
+	}
+}
+
+func TestTileOverlayErrors(t *testing.T) {
+	tests := []struct {
+		name            string
+		target, overlay [][][]float64
+		wantPrefix      string
+	}{
+		{"empty target", nil, solidTensor(2, 2, [4]float64{1, 1, 1, 1}), "target: "},
+		{"empty overlay", solidTensor(2, 2, [4]float64{1, 1, 1, 1}), nil, "overlay: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := TileOverlay(&tt.target, tt.overlay, 1, 1)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantPrefix) {
+				t.Errorf("TileOverlay error = %v, want prefix %q", err, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestTileOverlayLargeTarget(t *testing.T) {
+	// Tiling used to validate the whole target once per tile, which took
+	// minutes for a small watermark on a picture of this size.
+	const width, height = 1024, 768
+	target := solidTensor(width, height, [4]float64{0, 0, 0, 1})
+	overlay := solidTensor(2, 2, [4]float64{1, 1, 1, 1})
+	if err := TileOverlay(&target, overlay, 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	for y, row := range target {
+		for x, p := range row {
+			if covered := x%4 < 2 && y%4 < 2; (p[0] == 1) != covered {
+				t.Fatalf("pixel (%d, %d) = %v, covered by a tile %v", x, y, p, covered)
+			}
+		}
+	}
+}
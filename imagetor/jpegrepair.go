@@ -0,0 +1,374 @@
+package imagetor
+
+import (
+	"fmt"
+	"image"
+)
+
+// huffmanTable is a canonical JPEG Huffman table, as defined by a DHT segment.
+type huffmanTable struct {
+	defined bool
+	// maxCode[l] and valPtr[l] describe the codes of length l+1: codes in
+	// [minCode[l], maxCode[l]] map to symbols starting at symbols[valPtr[l]].
+	minCode, maxCode [16]int32
+	valPtr           [16]int
+	symbols          []byte
+}
+
+// newHuffmanTable builds a canonical Huffman table from the code counts and
+// symbol list of a DHT segment.
+func newHuffmanTable(counts [16]int, symbols []byte) huffmanTable {
+	t := huffmanTable{defined: true, symbols: symbols}
+	code, k := int32(0), 0
+	for l := 0; l < 16; l++ {
+		t.valPtr[l] = k
+		t.minCode[l] = code
+		code += int32(counts[l])
+		k += counts[l]
+		t.maxCode[l] = code - 1
+		code <<= 1
+	}
+	return t
+}
+
+// encode returns the code and code length for a symbol, or false if the symbol
+// is not in the table.
+func (t *huffmanTable) encode(symbol byte) (uint32, int, bool) {
+	for l := 0; l < 16; l++ {
+		for code := t.minCode[l]; code <= t.maxCode[l]; code++ {
+			if t.symbols[t.valPtr[l]+int(code-t.minCode[l])] == symbol {
+				return uint32(code), l + 1, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// jpegBitReader reads the entropy-coded segment of a JPEG scan one bit at a
+// time, undoing 0xFF00 byte stuffing and stopping at markers.
+type jpegBitReader struct {
+	data []byte
+	// pos is the index of the next unread byte in data.
+	pos int
+	// cur holds the current byte, n the number of its bits not yet read and
+	// curStart the index in data at which it was read.
+	cur      byte
+	n        int
+	curStart int
+}
+
+func (b *jpegBitReader) bit() (uint32, bool) {
+	if b.n == 0 {
+		if b.pos >= len(b.data) {
+			return 0, false
+		}
+		c := b.data[b.pos]
+		if c == 0xFF {
+			if b.pos+1 >= len(b.data) || b.data[b.pos+1] != 0x00 {
+				return 0, false
+			}
+			b.curStart, b.pos = b.pos, b.pos+2
+		} else {
+			b.curStart, b.pos = b.pos, b.pos+1
+		}
+		b.cur, b.n = c, 8
+	}
+	b.n--
+	return uint32(b.cur>>b.n) & 1, true
+}
+
+func (b *jpegBitReader) skip(n int) bool {
+	for i := 0; i < n; i++ {
+		if _, ok := b.bit(); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *jpegBitReader) decode(t *huffmanTable) (byte, bool) {
+	code := int32(0)
+	for l := 0; l < 16; l++ {
+		v, ok := b.bit()
+		if !ok {
+			return 0, false
+		}
+		code = code<<1 | int32(v)
+		if code <= t.maxCode[l] && code >= t.minCode[l] {
+			return t.symbols[t.valPtr[l]+int(code-t.minCode[l])], true
+		}
+	}
+	return 0, false
+}
+
+// jpegBitWriter writes entropy-coded data, applying 0xFF00 byte stuffing.
+type jpegBitWriter struct {
+	out []byte
+	acc uint32
+	n   int
+}
+
+func (w *jpegBitWriter) write(code uint32, length int) {
+	for i := length - 1; i >= 0; i-- {
+		w.acc = w.acc<<1 | (code>>i)&1
+		w.n++
+		if w.n == 8 {
+			w.out = append(w.out, byte(w.acc))
+			if byte(w.acc) == 0xFF {
+				w.out = append(w.out, 0x00)
+			}
+			w.acc, w.n = 0, 0
+		}
+	}
+}
+
+// flush pads the current byte with one bits, as JPEG encoders do.
+func (w *jpegBitWriter) flush() {
+	if w.n > 0 {
+		w.write(0xFF, 8-w.n)
+	}
+}
+
+// scanComponent describes one component of a single-scan baseline JPEG.
+type scanComponent struct {
+	id         byte
+	h, v       int
+	blocks     int
+	dcTable    *huffmanTable
+	acTable    *huffmanTable
+	dcPadCode  uint32
+	dcPadLen   int
+	eobPadCode uint32
+	eobPadLen  int
+}
+
+// repairJPEG rebuilds a truncated or damaged baseline JPEG so that the
+// standard decoder accepts it.
+//
+// The entropy-coded data is walked MCU by MCU until it runs out or stops
+// decoding. Everything up to the last complete MCU is kept and every missing
+// MCU is re-encoded as blocks with an unchanged DC value and no AC
+// coefficients, followed by the restart markers the decoder expects and an
+// EOI marker.
+//
+// Args:
+//
+//	data: The bytes of the damaged JPEG stream.
+//
+// Returns:
+//
+//	The repaired stream, the pixel rectangles that hold padding rather than
+//	recovered data, and an error if nothing could be recovered.
+func repairJPEG(data []byte) ([]byte, []image.Rectangle, error) {
+	var (
+		dc, ac          [4]huffmanTable
+		frame           []scanComponent
+		width, height   int
+		maxH, maxV      int
+		restartInterval int
+	)
+
+	pos := 2
+	for {
+		if pos+4 > len(data) {
+			return nil, nil, fmt.Errorf("stream ends before the first scan")
+		}
+		if data[pos] != 0xFF {
+			return nil, nil, fmt.Errorf("missing marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		if length < 2 || pos+2+length > len(data) {
+			return nil, nil, fmt.Errorf("stream ends before the first scan")
+		}
+		segment := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		switch marker {
+		case 0xC0, 0xC1: // Baseline and extended sequential DCT.
+			if len(segment) < 6 {
+				return nil, nil, fmt.Errorf("short SOF segment")
+			}
+			height = int(segment[1])<<8 | int(segment[2])
+			width = int(segment[3])<<8 | int(segment[4])
+			n := int(segment[5])
+			if n < 1 || n > 4 || len(segment) < 6+3*n || width == 0 || height == 0 {
+				return nil, nil, fmt.Errorf("bad SOF segment")
+			}
+			frame = make([]scanComponent, n)
+			maxH, maxV = 1, 1
+			for i := range frame {
+				frame[i].id = segment[6+3*i]
+				frame[i].h, frame[i].v = int(segment[7+3*i]>>4), int(segment[7+3*i]&0x0F)
+				if n == 1 {
+					// Single-component scans are non-interleaved: one block per MCU.
+					frame[i].h, frame[i].v = 1, 1
+				}
+				if frame[i].h < 1 || frame[i].v < 1 {
+					return nil, nil, fmt.Errorf("bad sampling factors")
+				}
+				frame[i].blocks = frame[i].h * frame[i].v
+				maxH, maxV = max(maxH, frame[i].h), max(maxV, frame[i].v)
+			}
+		case 0xC2, 0xC3, 0xC5, 0xC6, 0xC7, 0xC9, 0xCA, 0xCB, 0xCD, 0xCE, 0xCF:
+			return nil, nil, fmt.Errorf("only baseline JPEGs can be recovered")
+		case 0xC4: // Define Huffman Table.
+			for len(segment) > 0 {
+				if len(segment) < 17 {
+					return nil, nil, fmt.Errorf("short DHT segment")
+				}
+				class, id := segment[0]>>4, segment[0]&0x0F
+				if class > 1 || id > 3 {
+					return nil, nil, fmt.Errorf("bad DHT segment")
+				}
+				var counts [16]int
+				total := 0
+				for l := 0; l < 16; l++ {
+					counts[l] = int(segment[1+l])
+					total += counts[l]
+				}
+				if len(segment) < 17+total {
+					return nil, nil, fmt.Errorf("short DHT segment")
+				}
+				table := newHuffmanTable(counts, segment[17:17+total])
+				if class == 0 {
+					dc[id] = table
+				} else {
+					ac[id] = table
+				}
+				segment = segment[17+total:]
+			}
+		case 0xDD: // Define Restart Interval.
+			if len(segment) < 2 {
+				return nil, nil, fmt.Errorf("short DRI segment")
+			}
+			restartInterval = int(segment[0])<<8 | int(segment[1])
+		case 0xDA: // Start Of Scan.
+			if frame == nil {
+				return nil, nil, fmt.Errorf("scan before frame header")
+			}
+			if len(segment) < 1 || int(segment[0]) != len(frame) || len(segment) < 1+2*len(frame) {
+				return nil, nil, fmt.Errorf("only single-scan JPEGs can be recovered")
+			}
+			scan := make([]scanComponent, len(frame))
+			for i := range scan {
+				selector, tables := segment[1+2*i], segment[2+2*i]
+				found := false
+				for _, c := range frame {
+					if c.id == selector {
+						scan[i], found = c, true
+					}
+				}
+				if !found || tables>>4 > 3 || tables&0x0F > 3 {
+					return nil, nil, fmt.Errorf("bad SOS segment")
+				}
+				scan[i].dcTable, scan[i].acTable = &dc[tables>>4], &ac[tables&0x0F]
+				if !scan[i].dcTable.defined || !scan[i].acTable.defined {
+					return nil, nil, fmt.Errorf("scan uses an undefined Huffman table")
+				}
+				var ok1, ok2 bool
+				scan[i].dcPadCode, scan[i].dcPadLen, ok1 = scan[i].dcTable.encode(0x00)
+				scan[i].eobPadCode, scan[i].eobPadLen, ok2 = scan[i].acTable.encode(0x00)
+				if !ok1 || !ok2 {
+					return nil, nil, fmt.Errorf("huffman tables cannot encode padding blocks")
+				}
+			}
+			return repairScan(data, pos, scan, width, height, 8*maxH, 8*maxV, restartInterval)
+		case 0xD9:
+			return nil, nil, fmt.Errorf("stream ends before the first scan")
+		}
+	}
+}
+
+// repairScan walks the entropy-coded data starting at offset start and
+// rebuilds the stream as described by repairJPEG.
+func repairScan(data []byte, start int, scan []scanComponent, width, height, mcuWidth, mcuHeight, restartInterval int) ([]byte, []image.Rectangle, error) {
+	mcusX := (width + mcuWidth - 1) / mcuWidth
+	mcusY := (height + mcuHeight - 1) / mcuHeight
+	total := mcusX * mcusY
+
+	r := jpegBitReader{data: data, pos: start}
+	good := 0
+	cut := r
+
+decoding:
+	for good < total {
+		cut = r
+		if restartInterval > 0 && good > 0 && good%restartInterval == 0 {
+			expected := byte(0xD0 + (good/restartInterval-1)%8)
+			r.n = 0
+			if r.pos+1 >= len(data) || data[r.pos] != 0xFF || data[r.pos+1] != expected {
+				break
+			}
+			r.pos += 2
+		}
+		for _, c := range scan {
+			for j := 0; j < c.blocks; j++ {
+				size, ok := r.decode(c.dcTable)
+				if !ok || size > 16 || !r.skip(int(size)) {
+					break decoding
+				}
+				for k := 1; k < 64; k++ {
+					rs, ok := r.decode(c.acTable)
+					if !ok {
+						break decoding
+					}
+					run, size := int(rs>>4), int(rs&0x0F)
+					if size == 0 {
+						if run != 0x0F {
+							break
+						}
+						k += 15
+						continue
+					}
+					k += run
+					if k >= 64 || !r.skip(size) {
+						break decoding
+					}
+				}
+			}
+		}
+		good++
+	}
+	if good == total {
+		cut = r
+	}
+	if good == 0 {
+		return nil, nil, fmt.Errorf("no complete MCU in the scan")
+	}
+
+	w := jpegBitWriter{}
+	if cut.n > 0 {
+		w.out = append(w.out, data[:cut.curStart]...)
+		w.write(uint32(cut.cur>>cut.n), 8-cut.n)
+	} else {
+		w.out = append(w.out, data[:cut.pos]...)
+	}
+	for m := good; m < total; m++ {
+		if restartInterval > 0 && m > 0 && m%restartInterval == 0 {
+			w.flush()
+			w.out = append(w.out, 0xFF, byte(0xD0+(m/restartInterval-1)%8))
+		}
+		for _, c := range scan {
+			for j := 0; j < c.blocks; j++ {
+				w.write(c.dcPadCode, c.dcPadLen)
+				w.write(c.eobPadCode, c.eobPadLen)
+			}
+		}
+	}
+	w.flush()
+	w.out = append(w.out, 0xFF, 0xD9)
+
+	var damaged []image.Rectangle
+	if good < total {
+		row, col := good/mcusX, good%mcusX
+		damaged = append(damaged,
+			image.Rect(col*mcuWidth, row*mcuHeight, width, (row+1)*mcuHeight),
+			image.Rect(0, (row+1)*mcuHeight, width, height))
+	}
+	return w.out, damaged, nil
+}
@@ -0,0 +1,210 @@
+package imagetor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// GrayTensor is a single-channel image plane indexed as [y][x], with each
+// value normalized to the range [0, 1].
+//
+// It is used for masks, alpha planes and other per-pixel scalar data that
+// does not need the full RGBA layout of a tensor.
+type GrayTensor [][]float64
+
+// AlphaMask extracts the alpha channel of a tensor as a grayscale tensor.
+//
+// The returned plane can be saved, inspected or edited independently of the
+// color data and applied back to a tensor with SetAlpha.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//
+// Returns:
+//
+//	A GrayTensor with the same dimensions as the tensor, holding its alpha values.
+func AlphaMask(tensor [][][]float64) GrayTensor {
+	mask := make(GrayTensor, len(tensor))
+	for y := range tensor {
+		mask[y] = make([]float64, len(tensor[y]))
+		for x := range tensor[y] {
+			mask[y][x] = tensor[y][x][3]
+		}
+	}
+	return mask
+}
+
+// SetAlpha replaces the alpha channel of a tensor with the values of a mask.
+//
+// Tensors produced by ImageToTensor hold color premultiplied by alpha, so the
+// RGB channels of each pixel are rescaled to stay premultiplied by the new
+// alpha. Pixels that were fully transparent carry no color and stay black.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	mask: The alpha values to apply, with the same dimensions as the tensor.
+//
+// Returns:
+//
+//	An error if the mask dimensions do not match the tensor.
+func SetAlpha(tensor *[][][]float64, mask GrayTensor) error {
+	if len(mask) != len(*tensor) {
+		return fmt.Errorf("mask height %d does not match tensor height %d", len(mask), len(*tensor))
+	}
+	for y := range mask {
+		if len(mask[y]) != len((*tensor)[y]) {
+			return fmt.Errorf("mask width %d does not match tensor width %d in row %d", len(mask[y]), len((*tensor)[y]), y)
+		}
+	}
+
+	for y, row := range *tensor {
+		for x, pixel := range row {
+			alpha := mask[y][x]
+			if pixel[3] > 0 {
+				scale := alpha / pixel[3]
+				for c := 0; c < 3; c++ {
+					pixel[c] *= scale
+				}
+			}
+			pixel[3] = alpha
+		}
+	}
+	return nil
+}
+
+// ChangeMask compares two aligned images of the same size and marks the pixels
+// that differ between them.
+//
+// A pixel is marked as changed when any of its RGBA channels differs by more
+// than threshold. Pass the mask through OpenMask to drop isolated changed
+// pixels caused by noise or compression artifacts.
+//
+// Args:
+//
+//	a: The 3D tensor representing the first image.
+//	b: The 3D tensor representing the second image.
+//	threshold: The largest per-channel difference still considered unchanged.
+//
+// Returns:
+//
+//	A binary mask holding 1 for changed and 0 for unchanged pixels, or an error
+//	if the images differ in size.
+func ChangeMask(a, b [][][]float64, threshold float64) (GrayTensor, error) {
+	if len(a) != len(b) {
+		return nil, fmt.Errorf("image heights %d and %d differ", len(a), len(b))
+	}
+	for y := range a {
+		if len(a[y]) != len(b[y]) {
+			return nil, fmt.Errorf("image widths %d and %d differ in row %d", len(a[y]), len(b[y]), y)
+		}
+	}
+
+	mask := make(GrayTensor, len(a))
+	for y := range a {
+		mask[y] = make([]float64, len(a[y]))
+		for x := range a[y] {
+			for c := 0; c < channels; c++ {
+				if math.Abs(a[y][x][c]-b[y][x][c]) > threshold {
+					mask[y][x] = 1
+					break
+				}
+			}
+		}
+	}
+	return mask, nil
+}
+
+// morph returns a copy of a mask in which every pixel is replaced by the
+// minimum (erosion) or maximum (dilation) of its (2*radius+1)² neighborhood.
+// Pixels outside the mask are ignored.
+func morph(mask GrayTensor, radius int, dilate bool) GrayTensor {
+	height := len(mask)
+	result := make(GrayTensor, height)
+	for y := range mask {
+		width := len(mask[y])
+		result[y] = make([]float64, width)
+		for x := range mask[y] {
+			v := mask[y][x]
+			for ny := max(0, y-radius); ny <= min(height-1, y+radius); ny++ {
+				for nx := max(0, x-radius); nx <= min(width-1, x+radius); nx++ {
+					if dilate {
+						v = math.Max(v, mask[ny][nx])
+					} else {
+						v = math.Min(v, mask[ny][nx])
+					}
+				}
+			}
+			result[y][x] = v
+		}
+	}
+	return result
+}
+
+// OpenMask applies a morphological opening to a mask: an erosion followed by
+// a dilation with a square neighborhood.
+//
+// Marked regions smaller than the neighborhood, such as isolated noisy pixels,
+// are removed while larger regions keep their shape.
+//
+// Args:
+//
+//	mask: The mask to open.
+//	radius: The radius of the square neighborhood, in pixels.
+//
+// Returns:
+//
+//	The opened mask.
+func OpenMask(mask GrayTensor, radius int) GrayTensor {
+	return morph(morph(mask, radius, false), radius, true)
+}
+
+// PolygonMask rasterizes a polygon into a binary mask.
+//
+// A pixel is inside the polygon when its center is, following the even-odd
+// rule, so self-intersecting polygons have holes where they overlap.
+//
+// Args:
+//
+//	width: The width of the mask.
+//	height: The height of the mask.
+//	polygon: The vertices of the polygon as [x, y] pixel coordinates, in
+//	  either winding order. The polygon is closed implicitly.
+//
+// Returns:
+//
+//	A mask holding 1 inside and 0 outside the polygon.
+func PolygonMask(width, height int, polygon [][2]int) GrayTensor {
+	mask := make(GrayTensor, height)
+	for y := range mask {
+		mask[y] = make([]float64, width)
+		if len(polygon) < 3 {
+			continue
+		}
+
+		// Find where the edges cross the horizontal line through the pixel
+		// centers of this row and fill between pairs of crossings.
+		cy := float64(y) + 0.5
+		var crossings []float64
+		for i := range polygon {
+			a, b := polygon[i], polygon[(i+1)%len(polygon)]
+			ay, by := float64(a[1]), float64(b[1])
+			if (ay <= cy) == (by <= cy) {
+				continue
+			}
+			t := (cy - ay) / (by - ay)
+			crossings = append(crossings, float64(a[0])+t*float64(b[0]-a[0]))
+		}
+		sort.Float64s(crossings)
+		for i := 0; i+1 < len(crossings); i += 2 {
+			start := max(0, int(math.Ceil(crossings[i]-0.5)))
+			end := min(width, int(math.Ceil(crossings[i+1]-0.5)))
+			for x := start; x < end; x++ {
+				mask[y][x] = 1
+			}
+		}
+	}
+	return mask
+}
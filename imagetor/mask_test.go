@@ -0,0 +1,167 @@
+package imagetor
+
+import (
+	"image"
+	"testing"
+)
+
+func TestAlphaMask(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		height int
+	}{
+		{"single pixel", 1, 1},
+		{"horizontal gradient", 5, 2},
+		{"wide gradient", 11, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := newTensor(tt.width, tt.height)
+			for _, row := range tensor {
+				for x, p := range row {
+					alpha := float64(x) / float64(max(tt.width-1, 1))
+					p[0], p[3] = 0.5*alpha, alpha
+				}
+			}
+
+			mask := AlphaMask(tensor)
+			if len(mask) != tt.height {
+				t.Fatalf("mask height = %d, want %d", len(mask), tt.height)
+			}
+			for y, row := range mask {
+				if len(row) != tt.width {
+					t.Fatalf("mask row %d width = %d, want %d", y, len(row), tt.width)
+				}
+				for x, v := range row {
+					want := float64(x) / float64(max(tt.width-1, 1))
+					if !near(v, want, 1e-12) {
+						t.Errorf("mask[%d][%d] = %v, want %v", y, x, v, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSetAlpha(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(alpha float64) float64
+	}{
+		{"unchanged", func(a float64) float64 { return a }},
+		{"halved", func(a float64) float64 { return a / 2 }},
+		{"inverted", func(a float64) float64 { return 1 - a }},
+		{"cleared", func(a float64) float64 { return 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// An opaque orange pixel and a half transparent one, premultiplied.
+			tensor := [][][]float64{{{1, 0.5, 0, 1}, {0.5, 0.25, 0, 0.5}}}
+			mask := AlphaMask(tensor)
+			for y := range mask {
+				for x := range mask[y] {
+					mask[y][x] = tt.modify(mask[y][x])
+				}
+			}
+
+			if err := SetAlpha(&tensor, mask); err != nil {
+				t.Fatal(err)
+			}
+			for x, p := range tensor[0] {
+				if p[3] != mask[0][x] {
+					t.Errorf("pixel %d alpha = %v, want %v", x, p[3], mask[0][x])
+				}
+				// The straight color stays orange at any alpha.
+				want := [3]float64{1 * p[3], 0.5 * p[3], 0}
+				for c := 0; c < 3; c++ {
+					if !near(p[c], want[c], 1e-12) {
+						t.Errorf("pixel %d channel %d = %v, want %v", x, c, p[c], want[c])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSetAlphaMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		mask GrayTensor
+	}{
+		{"too few rows", GrayTensor{}},
+		{"too many rows", GrayTensor{{1, 1}, {1, 1}}},
+		{"short row", GrayTensor{{1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := [][][]float64{{{1, 0, 0, 1}, {0, 1, 0, 1}}}
+			if err := SetAlpha(&tensor, tt.mask); err == nil {
+				t.Fatal("SetAlpha returned no error")
+			}
+			if tensor[0][0][3] != 1 || tensor[0][1][3] != 1 {
+				t.Errorf("alpha changed to %v and %v", tensor[0][0][3], tensor[0][1][3])
+			}
+		})
+	}
+}
+
+func TestChangeMask(t *testing.T) {
+	const width, height = 12, 10
+	base := gradientTensor(width, height)
+	changed := image.Rect(3, 2, 8, 6)
+
+	// edited has a gray block painted over the changed rectangle and a single
+	// faint pixel of noise below the threshold.
+	edited := cloneTensor(base)
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		for x := changed.Min.X; x < changed.Max.X; x++ {
+			copy(edited[y][x], []float64{0.2, 0.9, 0.1, 1})
+		}
+	}
+	edited[8][1][2] += 0.02
+
+	// speckled adds an isolated changed pixel above the threshold, which
+	// OpenMask removes.
+	speckled := cloneTensor(edited)
+	speckled[8][10][2] = 1
+
+	tests := []struct {
+		name string
+		b    [][][]float64
+		open bool
+		want image.Rectangle
+	}{
+		{"identical", base, false, image.Rectangle{}},
+		{"changed region", edited, false, changed},
+		{"changed region after opening", speckled, true, changed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, err := ChangeMask(base, tt.b, 0.05)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.open {
+				mask = OpenMask(mask, 1)
+			}
+			for y, row := range mask {
+				for x, v := range row {
+					want := 0.0
+					if image.Pt(x, y).In(tt.want) {
+						want = 1
+					}
+					if v != want {
+						t.Errorf("mask at (%d, %d) = %v, want %v", x, y, v, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestChangeMaskMismatch(t *testing.T) {
+	if _, err := ChangeMask(gradientTensor(4, 4), gradientTensor(4, 5), 0.1); err == nil {
+		t.Error("images of different sizes did not return an error")
+	}
+}
@@ -0,0 +1,303 @@
+package imagetor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Metadata holds the raw metadata blocks of an encoded image that are lost
+// when it is decoded to a tensor.
+type Metadata struct {
+	// EXIF is the TIFF-structured EXIF payload, without the "Exif\x00\x00"
+	// prefix used by JPEG APP1 segments.
+	EXIF []byte
+	// ICC is the embedded ICC color profile.
+	ICC []byte
+}
+
+const (
+	jpegExifPrefix = "Exif\x00\x00"
+	jpegICCPrefix  = "ICC_PROFILE\x00"
+	pngSignature   = "\x89PNG\r\n\x1a\n"
+	// maxJPEGSegment is the largest payload a JPEG marker segment can hold.
+	maxJPEGSegment = 65533
+)
+
+// DecodeTensorWithMetadata decodes an image from a reader to a tensor and
+// captures its EXIF and ICC profile blocks.
+//
+// Metadata is read from JPEG APP1/APP2 segments and PNG eXIf/iCCP chunks. Pass
+// it to EncodeTensorWithMetadata to carry it over to the processed image.
+//
+// Images with an EXIF orientation are turned upright with ApplyOrientation, and
+// the orientation in the returned EXIF is reset to 1 to match.
+//
+// Args:
+//
+//	r: The reader holding the encoded image.
+//
+// Returns:
+//
+//	The decoded tensor, its metadata and an error if the image cannot be decoded.
+func DecodeTensorWithMetadata(r io.Reader) ([][][]float64, Metadata, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return decodeWithMetadata(data)
+}
+
+// decodeWithMetadata decodes an encoded image and its metadata, turning the
+// tensor upright according to its EXIF orientation.
+func decodeWithMetadata(data []byte) ([][][]float64, Metadata, error) {
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var meta Metadata
+	switch detectFormat(data) {
+	case "jpeg":
+		meta = jpegMetadata(data)
+	case "png":
+		meta = pngMetadata(data)
+	}
+
+	tensor, err := ImageToTensor(img)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if orientation := exifOrientation(meta.EXIF); orientation != 1 {
+		if err := ApplyOrientation(&tensor, orientation); err != nil {
+			return nil, Metadata{}, err
+		}
+		meta.EXIF = withUprightOrientation(meta.EXIF)
+	}
+	return tensor, meta, nil
+}
+
+// EncodeTensor encodes a tensor as a JPEG, PNG, BMP or TIFF image to a writer,
+// for example to stream a result into an HTTP response.
+//
+// Args:
+//
+//	w: The writer to write the encoded image to.
+//	tensor: The 3D tensor representing the image.
+//	format: "jpeg" (or "jpg"), "png", "bmp" or "tiff" (or "tif").
+//	quality: The JPEG quality, from 1 to 100. It is ignored for other formats.
+//
+// Returns:
+//
+//	An error if the format is unsupported or encoding or writing fails.
+func EncodeTensor(w io.Writer, tensor [][][]float64, format string, quality int) error {
+	img, err := TensorToImage(tensor)
+	if err != nil {
+		return err
+	}
+
+	return encodeImage(w, img, format, quality)
+}
+
+// EncodeTensorWithMetadata encodes a tensor as a JPEG or PNG image and embeds
+// the given metadata in it.
+//
+// Args:
+//
+//	w: The writer to write the encoded image to.
+//	tensor: The 3D tensor representing the image.
+//	format: "jpeg" (or "jpg") or "png".
+//	quality: The JPEG quality, from 1 to 100. It is ignored for PNG.
+//	meta: The metadata to embed.
+//
+// Returns:
+//
+//	An error if the format is unsupported, the metadata cannot be embedded,
+//	which is only possible in JPEG and PNG, or writing fails.
+func EncodeTensorWithMetadata(w io.Writer, tensor [][][]float64, format string, quality int, meta Metadata) error {
+	var buf bytes.Buffer
+	if err := EncodeTensor(&buf, tensor, format, quality); err != nil {
+		return err
+	}
+
+	var out []byte
+	var err error
+	switch strings.ToLower(format) {
+	case "png":
+		out, err = embedPNGMetadata(buf.Bytes(), meta)
+	case "jpeg", "jpg":
+		out, err = embedJPEGMetadata(buf.Bytes(), meta)
+	default:
+		if meta.EXIF != nil || meta.ICC != nil {
+			return fmt.Errorf("cannot embed metadata in format %q", format)
+		}
+		out = buf.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// jpegMetadata collects the EXIF and ICC blocks from the marker segments that
+// precede the first scan of a JPEG. ICC profiles split across several APP2
+// segments are reassembled in sequence order.
+func jpegMetadata(data []byte) Metadata {
+	var meta Metadata
+	chunks := map[int][]byte{}
+
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		switch {
+		case marker == 0xE1 && meta.EXIF == nil && bytes.HasPrefix(segment, []byte(jpegExifPrefix)):
+			meta.EXIF = append([]byte(nil), segment[len(jpegExifPrefix):]...)
+		case marker == 0xE2 && len(segment) > len(jpegICCPrefix)+2 && bytes.HasPrefix(segment, []byte(jpegICCPrefix)):
+			seq := int(segment[len(jpegICCPrefix)])
+			chunks[seq] = segment[len(jpegICCPrefix)+2:]
+		}
+	}
+
+	for seq := 1; len(chunks[seq]) > 0; seq++ {
+		meta.ICC = append(meta.ICC, chunks[seq]...)
+	}
+	return meta
+}
+
+// embedJPEGMetadata inserts EXIF and ICC segments right after the SOI marker
+// of an encoded JPEG, splitting the ICC profile across APP2 segments as needed.
+func embedJPEGMetadata(data []byte, meta Metadata) ([]byte, error) {
+	out := append([]byte(nil), data[:2]...)
+	segment := func(marker byte, parts ...[]byte) {
+		length := 2
+		for _, p := range parts {
+			length += len(p)
+		}
+		out = append(out, 0xFF, marker, byte(length>>8), byte(length))
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+	}
+
+	if len(meta.EXIF) > 0 {
+		if len(jpegExifPrefix)+len(meta.EXIF) > maxJPEGSegment {
+			return nil, fmt.Errorf("EXIF block of %d bytes does not fit in a JPEG segment", len(meta.EXIF))
+		}
+		segment(0xE1, []byte(jpegExifPrefix), meta.EXIF)
+	}
+
+	if len(meta.ICC) > 0 {
+		chunkSize := maxJPEGSegment - len(jpegICCPrefix) - 2
+		count := (len(meta.ICC) + chunkSize - 1) / chunkSize
+		if count > 255 {
+			return nil, fmt.Errorf("ICC profile of %d bytes is too large for a JPEG", len(meta.ICC))
+		}
+		for i := 0; i < count; i++ {
+			chunk := meta.ICC[i*chunkSize : min((i+1)*chunkSize, len(meta.ICC))]
+			segment(0xE2, []byte(jpegICCPrefix), []byte{byte(i + 1), byte(count)}, chunk)
+		}
+	}
+
+	return append(out, data[2:]...), nil
+}
+
+// pngMetadata collects the EXIF and ICC blocks from the eXIf and iCCP chunks
+// of a PNG, decompressing the ICC profile. A malformed iCCP chunk is skipped,
+// leaving ICC nil, as image/png ignores it when decoding the pixels too.
+func pngMetadata(data []byte) Metadata {
+	var meta Metadata
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) {
+			break
+		}
+		chunk := data[pos+8 : pos+8+length]
+		pos += 12 + length
+
+		switch kind {
+		case "eXIf":
+			meta.EXIF = append([]byte(nil), chunk...)
+		case "iCCP":
+			meta.ICC = iccpProfile(chunk)
+		case "IEND":
+			return meta
+		}
+	}
+	return meta
+}
+
+// iccpProfile decompresses the ICC profile held in the payload of an iCCP
+// chunk, returning nil if the payload is malformed.
+func iccpProfile(chunk []byte) []byte {
+	// Profile name, null separator, compression method, zlib data.
+	nul := bytes.IndexByte(chunk, 0)
+	if nul < 0 || nul+2 > len(chunk) {
+		return nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(chunk[nul+2:]))
+	if err != nil {
+		return nil
+	}
+	icc, err := io.ReadAll(zr)
+	if err != nil {
+		return nil
+	}
+	return icc
+}
+
+// embedPNGMetadata inserts iCCP and eXIf chunks right after the IHDR chunk of
+// an encoded PNG, ahead of the image data as the PNG specification requires.
+func embedPNGMetadata(data []byte, meta Metadata) ([]byte, error) {
+	// The signature is followed by the IHDR chunk: 4 bytes of length, 4 of
+	// type, 13 of data and 4 of CRC.
+	ihdrEnd := len(pngSignature) + 25
+	out := append([]byte(nil), data[:ihdrEnd]...)
+	chunk := func(kind string, payload []byte) {
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+		copy(header[4:], kind)
+		out = append(out, header[:]...)
+		out = append(out, payload...)
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(payload)
+		out = binary.BigEndian.AppendUint32(out, crc.Sum32())
+	}
+
+	if len(meta.ICC) > 0 {
+		var compressed bytes.Buffer
+		compressed.WriteString("ICC Profile\x00\x00")
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(meta.ICC); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		chunk("iCCP", compressed.Bytes())
+	}
+	if len(meta.EXIF) > 0 {
+		chunk("eXIf", meta.EXIF)
+	}
+
+	return append(out, data[ihdrEnd:]...), nil
+}
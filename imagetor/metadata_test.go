@@ -0,0 +1,199 @@
+package imagetor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// fakeICC returns n bytes standing in for an ICC profile. Only the bytes are
+// compared, so they need not parse as a profile.
+func fakeICC(n int) []byte {
+	icc := make([]byte, n)
+	for i := range icc {
+		icc[i] = byte(i * 7)
+	}
+	return icc
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		meta   Metadata
+	}{
+		{"jpeg icc", "jpeg", Metadata{ICC: fakeICC(560)}},
+		{"jpeg icc split across segments", "jpeg", Metadata{ICC: fakeICC(150000)}},
+		{"jpeg icc and exif", "jpeg", Metadata{ICC: fakeICC(560), EXIF: orientationEXIF(1)}},
+		{"png icc", "png", Metadata{ICC: fakeICC(560)}},
+		{"png icc and exif", "png", Metadata{ICC: fakeICC(560), EXIF: orientationEXIF(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := embedJPEGMetadata(encodeTestJPEG(t, stripesTensor(64, 48)), tt.meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tensor, meta, err := DecodeTensorWithMetadata(bytes.NewReader(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(meta.ICC, tt.meta.ICC) || !bytes.Equal(meta.EXIF, tt.meta.EXIF) {
+				t.Fatal("decoded metadata differs from the embedded metadata")
+			}
+			if err := Resize(&tensor, 32, 24); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := EncodeTensorWithMetadata(&buf, tensor, tt.format, 90, meta); err != nil {
+				t.Fatal(err)
+			}
+			got, gotMeta, err := DecodeTensorWithMetadata(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(got); w != 32 || h != 24 {
+				t.Errorf("saved image is %dx%d, want 32x24", w, h)
+			}
+			if !bytes.Equal(gotMeta.ICC, tt.meta.ICC) {
+				t.Errorf("ICC profile of %d bytes came back as %d bytes", len(tt.meta.ICC), len(gotMeta.ICC))
+			}
+			if !bytes.Equal(gotMeta.EXIF, tt.meta.EXIF) {
+				t.Errorf("EXIF = %x, want %x", gotMeta.EXIF, tt.meta.EXIF)
+			}
+		})
+	}
+}
+
+func TestEncodeTensorWithMetadataUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	err := EncodeTensorWithMetadata(&buf, stripesTensor(8, 8), "bmp", 0, Metadata{ICC: fakeICC(16)})
+	if err == nil {
+		t.Error("embedding metadata in a BMP did not return an error")
+	}
+}
+
+func TestEncodeTensorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		tensor  [][][]float64
+		format  string
+		quality int
+		tol     float64
+	}{
+		{"png", gradientTensor(40, 30), "png", 0, 1.0 / 255},
+		{"png keeps alpha", alphaRamp(40, 30), "png", 0, 1.0 / 255},
+		{"jpeg", gradientTensor(40, 30), "jpeg", 95, 0.05},
+		{"jpg alias", gradientTensor(40, 30), "jpg", 95, 0.05},
+		{"jpeg low quality", gradientTensor(40, 30), "jpeg", 20, 0.15},
+		{"bmp", gradientTensor(40, 30), "bmp", 0, 1.0 / 255},
+		{"tiff", alphaRamp(40, 30), "tiff", 0, 1.0 / 255},
+		{"tif alias", gradientTensor(40, 30), "tif", 0, 1.0 / 255},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeTensor(&buf, tt.tensor, tt.format, tt.quality); err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodeTensor(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(got, tt.tensor, tt.tol) {
+				t.Error("decoded image differs from the encoded tensor")
+			}
+		})
+	}
+}
+
+func TestEncodeTensorQuality(t *testing.T) {
+	sizes := make(map[int]int)
+	for _, quality := range []int{10, 90} {
+		var buf bytes.Buffer
+		if err := EncodeTensor(&buf, stripesTensor(64, 64), "jpeg", quality); err != nil {
+			t.Fatal(err)
+		}
+		sizes[quality] = buf.Len()
+	}
+	if sizes[10] >= sizes[90] {
+		t.Errorf("quality 10 gave %d bytes and quality 90 gave %d, want fewer", sizes[10], sizes[90])
+	}
+}
+
+func TestEncodeTensorErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+		format string
+	}{
+		{"unsupported format", gradientTensor(4, 4), "gif"},
+		{"empty tensor", nil, "png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeTensor(&buf, tt.tensor, tt.format, 90); err == nil {
+				t.Error("EncodeTensor did not return an error")
+			}
+		})
+	}
+}
+
+// withPNGChunk inserts a chunk with the given type and payload, and a valid
+// CRC, right after the IHDR chunk of an encoded PNG.
+func withPNGChunk(data []byte, kind string, payload []byte) []byte {
+	ihdrEnd := len(pngSignature) + 25
+	out := append([]byte(nil), data[:ihdrEnd]...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(payload)))
+	out = append(out, kind...)
+	out = append(out, payload...)
+	out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(append([]byte(kind), payload...)))
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestDecodeTensorMalformedICCP(t *testing.T) {
+	var profile bytes.Buffer
+	zw := zlib.NewWriter(&profile)
+	zw.Write(fakeICC(560))
+	zw.Close()
+	truncated := profile.Bytes()[:profile.Len()/2]
+
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"no name separator", []byte("ICC Profile")},
+		{"no compression method", []byte("ICC Profile\x00")},
+		{"not zlib", []byte("ICC Profile\x00\x00not zlib data")},
+		{"truncated zlib", append([]byte("ICC Profile\x00\x00"), truncated...)},
+	}
+	source := gradientTensor(8, 6)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := withPNGChunk(encodeTestPNG(t, source), "iCCP", tt.payload)
+
+			tensor, err := DecodeTensor(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("DecodeTensor error = %v", err)
+			}
+			if !pixelsNear(tensor, source, 1.0/255) {
+				t.Error("DecodeTensor pixels differ from the encoded tensor")
+			}
+			if _, partial, err := DecodeTensorTolerant(bytes.NewReader(data)); err != nil || partial {
+				t.Errorf("DecodeTensorTolerant = partial %v, error %v", partial, err)
+			}
+			_, meta, err := DecodeTensorWithMetadata(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("DecodeTensorWithMetadata error = %v", err)
+			}
+			if meta.ICC != nil {
+				t.Errorf("ICC = %d bytes, want nil", len(meta.ICC))
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package imagetor
+
+import "fmt"
+
+// Op is an image operation that modifies a tensor in place, such as a filter
+// wrapped in a closure binding its parameters:
+//
+//	sharpen := func(t *[][][]float64) error {
+//		return UnsharpMask(t, 1.5, 1, 0)
+//	}
+type Op func(tensor *[][][]float64) error
+
+// ApplyMasked applies an operation to the parts of an image selected by a
+// mask.
+//
+// The operation runs on a copy of the image, and each pixel of the result is
+// blended with the original by the mask value: 1 takes the operated pixel, 0
+// keeps the original and values in between mix them, which softens the border
+// of feathered masks.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	mask: The selection, with the same dimensions as the tensor.
+//	op: The operation to apply. It must not change the dimensions of the
+//	  image.
+//
+// Returns:
+//
+//	An error if the mask or the result of op do not match the dimensions of
+//	the tensor, or the error returned by op.
+func ApplyMasked(tensor *[][][]float64, mask GrayTensor, op Op) error {
+	height := len(*tensor)
+	if len(mask) != height {
+		return fmt.Errorf("mask height %d does not match tensor height %d", len(mask), height)
+	}
+	for y := range mask {
+		if len(mask[y]) != len((*tensor)[y]) {
+			return fmt.Errorf("mask width %d does not match tensor width %d in row %d", len(mask[y]), len((*tensor)[y]), y)
+		}
+	}
+
+	result := cloneTensor(*tensor)
+	if err := op(&result); err != nil {
+		return err
+	}
+	if len(result) != height {
+		return fmt.Errorf("operation changed the image height from %d to %d", height, len(result))
+	}
+	for y := range result {
+		if len(result[y]) != len((*tensor)[y]) {
+			return fmt.Errorf("operation changed the image width from %d to %d", len((*tensor)[y]), len(result[y]))
+		}
+	}
+
+	return parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x, pixel := range (*tensor)[y] {
+				weight := clamp(mask[y][x])
+				for c := 0; c < channels; c++ {
+					pixel[c] += weight * (result[y][x][c] - pixel[c])
+				}
+			}
+		}
+	})
+}
+
+// ApplyInPolygon applies an operation only inside a polygon, for blurring a
+// face or redacting an arbitrarily shaped region.
+//
+// The polygon is rasterized with PolygonMask and the operation is applied
+// through ApplyMasked, so pixels outside the polygon are left untouched.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	polygon: The vertices of the polygon as [x, y] pixel coordinates.
+//	op: The operation to apply. It must not change the dimensions of the
+//	  image.
+//
+// Returns:
+//
+//	An error if the polygon has fewer than three vertices, if the result of op
+//	does not match the dimensions of the tensor, or the error returned by op.
+func ApplyInPolygon(tensor *[][][]float64, polygon [][2]int, op Op) error {
+	if len(polygon) < 3 {
+		return fmt.Errorf("polygon needs at least 3 vertices, got %d", len(polygon))
+	}
+	width, height, _ := Dimensions(*tensor)
+	return ApplyMasked(tensor, PolygonMask(width, height, polygon), op)
+}
@@ -0,0 +1,77 @@
+package imagetor
+
+import (
+	"errors"
+	"testing"
+)
+
+// blurOp blurs an image strongly enough to change every pixel of a detailed
+// one.
+func blurOp(t *[][][]float64) error {
+	return BoxBlur(t, 2)
+}
+
+func TestApplyInPolygon(t *testing.T) {
+	tests := []struct {
+		name    string
+		polygon [][2]int
+	}{
+		{"triangle", [][2]int{{2, 2}, {13, 2}, {2, 13}}},
+		{"quadrilateral", [][2]int{{4, 1}, {14, 6}, {9, 14}, {1, 8}}},
+		{"partly outside", [][2]int{{-5, -5}, {10, -5}, {-5, 10}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := mustPattern(t, 16, 16, PatternCheckerboard)
+			tensor := cloneTensor(source)
+			if err := ApplyInPolygon(&tensor, tt.polygon, blurOp); err != nil {
+				t.Fatal(err)
+			}
+
+			mask := PolygonMask(16, 16, tt.polygon)
+			changed := 0
+			for y, row := range tensor {
+				for x, p := range row {
+					if mask[y][x] == 0 && !samePixel(p, source[y][x]) {
+						t.Fatalf("pixel (%d, %d) outside the polygon changed from %v to %v", x, y, source[y][x], p)
+					}
+					if mask[y][x] == 1 && !samePixel(p, source[y][x]) {
+						changed++
+					}
+				}
+			}
+			if changed == 0 {
+				t.Error("no pixel inside the polygon was blurred")
+			}
+		})
+	}
+}
+
+func TestApplyInPolygonErrors(t *testing.T) {
+	errOp := errors.New("op failed")
+	tests := []struct {
+		name    string
+		polygon [][2]int
+		op      Op
+		wantErr error
+	}{
+		{"too few vertices", [][2]int{{0, 0}, {4, 4}}, blurOp, nil},
+		{"op fails", [][2]int{{0, 0}, {4, 0}, {0, 4}}, func(*[][][]float64) error { return errOp }, errOp},
+		{"op resizes", [][2]int{{0, 0}, {4, 0}, {0, 4}}, func(t *[][][]float64) error { return Resize(t, 4, 4) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := stripesTensor(8, 8)
+			err := ApplyInPolygon(&tensor, tt.polygon, tt.op)
+			if err == nil {
+				t.Fatal("ApplyInPolygon did not return an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !pixelsNear(tensor, stripesTensor(8, 8), 0) {
+				t.Error("image changed despite the error")
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package imagetor
+
+import "encoding/binary"
+
+// exifOrientationTag is the EXIF tag holding the orientation of the image.
+const exifOrientationTag = 0x0112
+
+// exifOrientationOffset returns the offset within a TIFF-structured EXIF
+// payload of the value of the orientation tag of its first IFD, the byte order
+// of the payload, and whether the tag was found.
+func exifOrientationOffset(exif []byte) (int, binary.ByteOrder, bool) {
+	if len(exif) < 8 {
+		return 0, nil, false
+	}
+	var order binary.ByteOrder
+	switch string(exif[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0, nil, false
+	}
+
+	ifd := int(order.Uint32(exif[4:8]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return 0, nil, false
+	}
+	count := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(exif) {
+			break
+		}
+		// The orientation is a single SHORT, stored in the value field.
+		if order.Uint16(exif[entry:]) == exifOrientationTag && order.Uint16(exif[entry+2:]) == 3 {
+			return entry + 8, order, true
+		}
+	}
+	return 0, nil, false
+}
+
+// exifOrientation returns the orientation recorded in a TIFF-structured EXIF
+// payload, or 1 (upright) if it has none or it is not a valid value.
+func exifOrientation(exif []byte) int {
+	offset, order, ok := exifOrientationOffset(exif)
+	if !ok {
+		return 1
+	}
+	orientation := int(order.Uint16(exif[offset:]))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// withUprightOrientation returns a copy of a TIFF-structured EXIF payload
+// whose orientation tag, if any, is reset to 1 (upright).
+func withUprightOrientation(exif []byte) []byte {
+	offset, order, ok := exifOrientationOffset(exif)
+	if !ok {
+		return exif
+	}
+	result := append([]byte(nil), exif...)
+	order.PutUint16(result[offset:], 1)
+	return result
+}
+
+// ApplyOrientation flips and rotates an image as described by an EXIF
+// orientation value, turning it from the way it was stored into the way it is
+// meant to be displayed.
+//
+// Cameras and phones store pictures the way the sensor captured them and
+// record in EXIF how to turn them upright, so a portrait shot is often stored
+// sideways with orientation 6.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//	orientation: The EXIF orientation, from 1 to 8. Other values, including
+//	  1 (already upright), leave the image unchanged.
+//
+// Returns:
+//
+//	An error if the image is empty, ragged or has pixels with fewer than four
+//	channels, in which case it is left unchanged.
+func ApplyOrientation(tensor *[][][]float64, orientation int) error {
+	if _, _, err := dimsOf(*tensor); err != nil {
+		return err
+	}
+
+	switch orientation {
+	case 2:
+		FlipHorizontal(tensor)
+	case 3:
+		return Rotate90(tensor, 2)
+	case 4:
+		Flip(tensor, FlipVertically)
+	case 5:
+		return Transpose(tensor)
+	case 6:
+		return Rotate90(tensor, 1)
+	case 7:
+		FlipHorizontal(tensor)
+		return Rotate90(tensor, 1)
+	case 8:
+		return Rotate90(tensor, -1)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package imagetor
+
+import (
+	"fmt"
+	"image"
+)
+
+// OverlayPosition selects the point of the target an overlay is anchored to.
+type OverlayPosition int
+
+const (
+	// PositionCenter centers the overlay on the target.
+	PositionCenter OverlayPosition = iota
+	// PositionTopLeft places the overlay in the top left corner.
+	PositionTopLeft
+	// PositionTop centers the overlay along the top edge.
+	PositionTop
+	// PositionTopRight places the overlay in the top right corner.
+	PositionTopRight
+	// PositionLeft centers the overlay along the left edge.
+	PositionLeft
+	// PositionRight centers the overlay along the right edge.
+	PositionRight
+	// PositionBottomLeft places the overlay in the bottom left corner.
+	PositionBottomLeft
+	// PositionBottom centers the overlay along the bottom edge.
+	PositionBottom
+	// PositionBottomRight places the overlay in the bottom right corner.
+	PositionBottomRight
+)
+
+// OverlayOptions configures how AddOverlayWithOptions composites an overlay.
+// Start from DefaultOverlayOptions rather than the zero value, whose
+// GlobalAlpha of 0 makes the overlay invisible.
+type OverlayOptions struct {
+	// Position is the point of the target the overlay is anchored to.
+	Position OverlayPosition
+	// Offset moves the overlay from its anchored position, in pixels. Parts of
+	// the overlay moved past the edges of the target are clipped.
+	Offset image.Point
+	// GlobalAlpha scales the opacity of the whole overlay, from 0 to 1.
+	GlobalAlpha float64
+	// Feather fades the opacity of the overlay in towards its edges over this
+	// many pixels, softening the border of rectangular overlays.
+	Feather int
+	// Tint, when not nil, replaces the colors of the overlay with a single
+	// color, keeping only the shape of its alpha channel. The alpha component
+	// of the tint scales the opacity of the overlay.
+	Tint *[4]float64
+	// ResizeMethod is the filter used to scale the overlay down to fit the
+	// target.
+	ResizeMethod ResizeMethod
+	// Premultiplied reports whether the color channels of the target and the
+	// overlay are premultiplied by alpha, as those of ImageToTensor are.
+	Premultiplied bool
+	// Linear blends in linear light instead of directly on sRGB values, which
+	// keeps the semi-transparent parts of the overlay from looking too dark.
+	Linear bool
+	// NoResize keeps the overlay at its own size even when it is larger than
+	// the target, instead of scaling it down to fit.
+	NoResize bool
+}
+
+// DefaultOverlayOptions returns the options used by AddOverlay: the overlay
+// is scaled down to fit the target and centered on it at full opacity.
+//
+// Returns:
+//
+//	The default overlay options.
+func DefaultOverlayOptions() OverlayOptions {
+	return OverlayOptions{GlobalAlpha: 1, Premultiplied: true}
+}
+
+// overlayOrigin returns where the top left corner of an overlay of the given
+// size lands on the target: anchored by opts.Position, then moved by
+// opts.Offset. The result may lie outside the target.
+func overlayOrigin(targetWidth, targetHeight, overlayWidth, overlayHeight int, opts OverlayOptions) image.Point {
+	x := (targetWidth - overlayWidth) / 2
+	y := (targetHeight - overlayHeight) / 2
+	switch opts.Position {
+	case PositionTopLeft, PositionLeft, PositionBottomLeft:
+		x = 0
+	case PositionTopRight, PositionRight, PositionBottomRight:
+		x = targetWidth - overlayWidth
+	}
+	switch opts.Position {
+	case PositionTopLeft, PositionTop, PositionTopRight:
+		y = 0
+	case PositionBottomLeft, PositionBottom, PositionBottomRight:
+		y = targetHeight - overlayHeight
+	}
+	return image.Point{X: x + opts.Offset.X, Y: y + opts.Offset.Y}
+}
+
+// AddOverlayWithOptions adds an overlay image to a target image with alpha
+// blending, configured by opts.
+//
+// Unless opts.NoResize is set, the overlay is first scaled to fit within the
+// target while maintaining its aspect ratio, and the overlay tensor is replaced
+// by the scaled copy.
+//
+// Args:
+//
+//	target: A pointer to the 3D tensor representing the target image.
+//	overlay: A pointer to the 3D tensor representing the overlay image.
+//	opts: How to place and blend the overlay.
+//
+// Returns:
+//
+//	An error if the target or overlay image is empty or ragged, or if
+//	blending panics.
+func AddOverlayWithOptions(target *[][][]float64, overlay *[][][]float64, opts OverlayOptions) error {
+	targetHeight, targetWidth, err := dimsOf(*target)
+	if err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	overlayHeight, overlayWidth, err := dimsOf(*overlay)
+	if err != nil {
+		return fmt.Errorf("overlay: %w", err)
+	}
+
+	if !opts.NoResize {
+		factor, err := ScaleFactor(*target, *overlay)
+		if err != nil {
+			return err
+		}
+		if factor < 1 {
+			overlayWidth = int(float64(overlayWidth) * factor)
+			overlayHeight = int(float64(overlayHeight) * factor)
+			if overlayWidth == 0 || overlayHeight == 0 {
+				return nil
+			}
+			resized, err := resizeWith(*overlay, overlayWidth, overlayHeight, opts.ResizeMethod, !opts.Premultiplied)
+			if err != nil {
+				return fmt.Errorf("overlay: %w", err)
+			}
+			*overlay = resized
+		}
+	}
+
+	return blendOverlay(*target, *overlay, overlayOrigin(targetWidth, targetHeight, overlayWidth, overlayHeight, opts), opts)
+}
+
+// blendOverlay alpha blends overlay onto target with its top left corner at
+// origin, clipping it to the target. Both tensors must already have been
+// validated with dimsOf; blendOverlay does not check them again.
+func blendOverlay(target, overlay [][][]float64, origin image.Point, opts OverlayOptions) error {
+	targetHeight, targetWidth := len(target), len(target[0])
+	overlayHeight, overlayWidth := len(overlay), len(overlay[0])
+	offsetX, offsetY := origin.X, origin.Y
+
+	// Clip the overlay to the target.
+	startX, endX := max(offsetX, 0), min(offsetX+overlayWidth, targetWidth)
+	startY, endY := max(offsetY, 0), min(offsetY+overlayHeight, targetHeight)
+	if startX >= endX || startY >= endY {
+		return nil
+	}
+
+	return parallelRows(endY-startY, func(start, end int) {
+		for y := startY + start; y < startY+end; y++ {
+			for x := startX; x < endX; x++ {
+				ox, oy := x-offsetX, y-offsetY
+				pixel := overlay[oy][ox]
+				dst := target[y][x]
+
+				// weight scales the opacity of the overlay pixel.
+				weight := opts.GlobalAlpha
+				if opts.Feather > 0 {
+					edge := min(ox, overlayWidth-1-ox, oy, overlayHeight-1-oy)
+					weight *= min(1, float64(edge+1)/float64(opts.Feather+1))
+				}
+				alpha := pixel[3] * weight
+				if opts.Tint != nil {
+					alpha *= opts.Tint[3]
+				}
+
+				// Work on premultiplied colors, so the composite is the "over"
+				// operator applied alike to the color and alpha channels.
+				var over, under [3]float64
+				for i := 0; i < 3; i++ {
+					switch {
+					case opts.Tint != nil:
+						over[i] = opts.Tint[i] * alpha
+					case opts.Premultiplied:
+						over[i] = pixel[i] * weight
+					default:
+						over[i] = pixel[i] * alpha
+					}
+					under[i] = dst[i]
+					if !opts.Premultiplied {
+						under[i] *= dst[3]
+					}
+				}
+
+				resultAlpha := alpha + ((1 - alpha) * dst[3])
+				for i := 0; i < 3; i++ {
+					var value float64
+					if opts.Linear {
+						value = fromLinearPremultiplied(toLinearPremultiplied(over[i], alpha)+((1-alpha)*toLinearPremultiplied(under[i], dst[3])), resultAlpha)
+					} else {
+						value = over[i] + ((1 - alpha) * under[i])
+					}
+					if !opts.Premultiplied {
+						if resultAlpha > 0 {
+							value /= resultAlpha
+						} else {
+							value = 0
+						}
+					}
+					dst[i] = value
+				}
+				dst[3] = resultAlpha
+			}
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package imagetor
+
+import (
+	"image"
+	"testing"
+)
+
+func TestAddOverlayWithOptions(t *testing.T) {
+	black := [4]float64{0, 0, 0, 1}
+	white := [4]float64{1, 1, 1, 1}
+	red := [4]float64{1, 0, 0, 1}
+	blue := [4]float64{0, 0, 1, 1}
+
+	// halves is a 16x16 overlay, red on the left and blue on the right.
+	halves := solidTensor(16, 16, red)
+	for _, row := range halves {
+		for x := 8; x < 16; x++ {
+			copy(row[x], blue[:])
+		}
+	}
+	half := linearToSRGB(0.5)
+
+	// paint expects over inside r and black elsewhere.
+	paint := func(r image.Rectangle, over [4]float64) func(x, y int) [4]float64 {
+		return func(x, y int) [4]float64 {
+			if image.Pt(x, y).In(r) {
+				return over
+			}
+			return black
+		}
+	}
+
+	tests := []struct {
+		name    string
+		overlay [][][]float64
+		opts    func(*OverlayOptions)
+		want    func(x, y int) [4]float64
+	}{
+		{"defaults", solidTensor(2, 2, white), func(o *OverlayOptions) {},
+			paint(image.Rect(3, 3, 5, 5), white)},
+		{"top left", solidTensor(2, 2, white), func(o *OverlayOptions) {
+			o.Position = PositionTopLeft
+		}, paint(image.Rect(0, 0, 2, 2), white)},
+		{"bottom right with offset", solidTensor(2, 2, white), func(o *OverlayOptions) {
+			o.Position = PositionBottomRight
+			o.Offset = image.Pt(-1, -1)
+		}, paint(image.Rect(5, 5, 7, 7), white)},
+		{"offset past the edge", solidTensor(2, 2, white), func(o *OverlayOptions) {
+			o.Position = PositionTopLeft
+			o.Offset = image.Pt(-1, -1)
+		}, paint(image.Rect(0, 0, 1, 1), white)},
+		{"global alpha", solidTensor(2, 2, white), func(o *OverlayOptions) {
+			o.Position = PositionTopLeft
+			o.GlobalAlpha = 0.5
+		}, paint(image.Rect(0, 0, 2, 2), [4]float64{0.5, 0.5, 0.5, 1})},
+		{"tint", solidTensor(2, 2, white), func(o *OverlayOptions) {
+			o.Tint = &red
+		}, paint(image.Rect(3, 3, 5, 5), red)},
+		{"straight alpha", solidTensor(2, 2, [4]float64{1, 0, 0, 0.5}), func(o *OverlayOptions) {
+			o.Premultiplied = false
+		}, paint(image.Rect(3, 3, 5, 5), [4]float64{0.5, 0, 0, 1})},
+		{"linear", solidTensor(2, 2, [4]float64{0.5, 0.5, 0.5, 0.5}), func(o *OverlayOptions) {
+			o.Linear = true
+		}, paint(image.Rect(3, 3, 5, 5), [4]float64{half, half, half, 1})},
+		{"feather", solidTensor(8, 8, white), func(o *OverlayOptions) {
+			o.Feather = 1
+		}, func(x, y int) [4]float64 {
+			if x == 0 || y == 0 || x == 7 || y == 7 {
+				return [4]float64{0.5, 0.5, 0.5, 1}
+			}
+			return white
+		}},
+		{"resized to fit", cloneTensor(halves), func(o *OverlayOptions) {}, func(x, y int) [4]float64 {
+			if x < 4 {
+				return red
+			}
+			return blue
+		}},
+		{"no resize", cloneTensor(halves), func(o *OverlayOptions) {
+			o.Position = PositionTopLeft
+			o.NoResize = true
+		}, paint(image.Rect(0, 0, 8, 8), red)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := solidTensor(8, 8, black)
+			opts := DefaultOverlayOptions()
+			tt.opts(&opts)
+			if err := AddOverlayWithOptions(&target, &tt.overlay, opts); err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range target {
+				for x, p := range row {
+					want := tt.want(x, y)
+					for c := range want {
+						if !near(p[c], want[c], 1e-6) {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, p, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// haloOverlay returns an 8x8 red overlay: opaque in the middle 4x4 square,
+// half transparent in the ring around it and fully transparent, with black
+// color, along the border. Its colors are premultiplied when premultiplied is
+// set and straight otherwise.
+func haloOverlay(premultiplied bool) [][][]float64 {
+	overlay := newTensor(8, 8)
+	for y, row := range overlay {
+		for x, p := range row {
+			switch ring := min(x, y, 7-x, 7-y); {
+			case ring >= 2:
+				copy(p, []float64{1, 0, 0, 1})
+			case ring == 1 && premultiplied:
+				copy(p, []float64{0.5, 0, 0, 0.5})
+			case ring == 1:
+				copy(p, []float64{1, 0, 0, 0.5})
+			}
+		}
+	}
+	return overlay
+}
+
+func TestAddOverlayNoHalo(t *testing.T) {
+	tests := []struct {
+		name          string
+		premultiplied bool
+		targetSize    int
+	}{
+		{"premultiplied", true, 8},
+		{"straight", false, 8},
+		{"premultiplied, scaled down", true, 4},
+		{"straight, scaled down", false, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := solidTensor(tt.targetSize, tt.targetSize, [4]float64{1, 1, 1, 1})
+			overlay := haloOverlay(tt.premultiplied)
+			opts := DefaultOverlayOptions()
+			opts.Premultiplied = tt.premultiplied
+			if err := AddOverlayWithOptions(&target, &overlay, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			// Red over white keeps the red channel at 1 whatever the coverage;
+			// a halo darkens it where the overlay is partly transparent.
+			for y, row := range target {
+				for x, p := range row {
+					if !near(p[0], 1, 1e-9) || !near(p[1], p[2], 1e-9) || !near(p[3], 1, 1e-9) {
+						t.Fatalf("pixel (%d, %d) = %v, want a tint of red over white", x, y, p)
+					}
+				}
+			}
+			if tt.targetSize == 8 {
+				if p := target[1][4]; !near(p[1], 0.5, 1e-9) {
+					t.Errorf("half transparent edge pixel = %v, want [1 0.5 0.5 1]", p)
+				}
+			}
+		})
+	}
+}
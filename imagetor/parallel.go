@@ -0,0 +1,116 @@
+package imagetor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// stripeHeight is the number of rows processed as one unit of work by
+// parallelRows. Stripes of a few dozen rows keep the rows a worker touches
+// resident in cache across the passes of multi-pass filters.
+var stripeHeight int = 64
+
+// SetStripeHeight sets the number of rows in each stripe of work handed to the
+// worker goroutines.
+//
+// Smaller stripes balance the load better across many cores, larger ones
+// reduce scheduling overhead. It must not be called while an operation is in
+// progress.
+//
+// Args:
+//
+//	height: The number of rows per stripe.
+//
+// Returns:
+//
+//	An error if height is less than 1.
+func SetStripeHeight(height int) error {
+	if height < 1 {
+		return fmt.Errorf("stripe height must be at least 1, got %d", height)
+	}
+	stripeHeight = height
+	return nil
+}
+
+// SetWorkers sets the number of goroutines operations split their work
+// across. It defaults to the number of CPUs.
+//
+// It must not be called while an operation is in progress.
+//
+// Args:
+//
+//	n: The number of worker goroutines.
+//
+// Returns:
+//
+//	An error if n is less than 1.
+func SetWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("worker count must be at least 1, got %d", n)
+	}
+	numWorkers = n
+	return nil
+}
+
+// parallelRows splits the rows [0, height) into stripes of stripeHeight rows
+// and runs fn on them from numWorkers goroutines, waiting for all of them to
+// finish.
+//
+// Workers claim the next unprocessed stripe from a shared counter as soon as
+// they finish their current one, so a worker that is slowed down on expensive
+// rows does not hold up the others. Every row is processed exactly once.
+//
+// A panic in fn, such as an index out of range on a malformed tensor, is
+// recovered in the worker instead of crashing the program, the remaining
+// stripes are skipped and the panic is returned as an error.
+func parallelRows(height int, fn func(start, end int)) error {
+	return parallelRowsCtx(context.Background(), height, fn)
+}
+
+// parallelRowsCtx is parallelRows, except that workers stop claiming stripes
+// once ctx is done. It returns ctx.Err() if some rows were left unprocessed.
+func parallelRowsCtx(ctx context.Context, height int, fn func(start, end int)) error {
+	stripe := stripeHeight
+	stripes := (height + stripe - 1) / stripe
+	workers := min(numWorkers, stripes)
+
+	var next, done atomic.Int64
+	var failed atomic.Bool
+	var panicOnce sync.Once
+	var panicErr error
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panicOnce.Do(func() {
+						panicErr = fmt.Errorf("worker panicked: %v", r)
+					})
+					failed.Store(true)
+				}
+			}()
+			for {
+				s := int(next.Add(1) - 1)
+				if s >= stripes || failed.Load() || ctx.Err() != nil {
+					return
+				}
+				fn(s*stripe, min((s+1)*stripe, height))
+				done.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait() // Wait for all goroutines finish
+	if panicErr != nil {
+		return panicErr
+	}
+	if int(done.Load()) < stripes {
+		return ctx.Err()
+	}
+	return nil
+}
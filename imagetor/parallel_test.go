@@ -0,0 +1,257 @@
+package imagetor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// panickingImage is an image whose At panics on one row, standing in for a
+// broken image.Image implementation.
+type panickingImage struct {
+	image.Rectangle
+	row int
+}
+
+func (p panickingImage) ColorModel() color.Model { return color.RGBAModel }
+func (p panickingImage) Bounds() image.Rectangle { return p.Rectangle }
+
+func (p panickingImage) At(x, y int) color.Color {
+	if y == p.row {
+		panic("broken pixel")
+	}
+	return color.RGBA{R: 255, A: 255}
+}
+
+func TestParallelRowsPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		height  int
+		workers int
+		panicAt int
+		wantErr bool
+	}{
+		{"no panic", 200, 4, -1, false},
+		{"panic in first stripe", 200, 4, 0, true},
+		{"panic in last row", 200, 3, 199, true},
+		{"single worker", 200, 1, 100, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withWorkers(t, tt.workers)
+			rows := make([]bool, tt.height)
+			err := parallelRows(tt.height, func(start, end int) {
+				for y := start; y < end; y++ {
+					if y == tt.panicAt {
+						panic("boom")
+					}
+					rows[y] = true
+				}
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parallelRows error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "boom") {
+				t.Errorf("error %q does not mention the panic value", err)
+			}
+			if !tt.wantErr {
+				for y, ok := range rows {
+					if !ok {
+						t.Fatalf("row %d was not processed", y)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestImageToTensorWorkerPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		img     image.Image
+		wantErr bool
+	}{
+		{"healthy", panickingImage{image.Rect(0, 0, 8, 130), -1}, false},
+		{"panics", panickingImage{image.Rect(0, 0, 8, 130), 70}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor, err := ImageToTensor(tt.img)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImageToTensor error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if tensor != nil {
+					t.Errorf("ImageToTensor returned a tensor along with error %v", err)
+				}
+				return
+			}
+			if got := tensor[129][7]; got[0] != 1 || got[3] != 1 {
+				t.Errorf("pixel (7, 129) = %v, want opaque red", got)
+			}
+		})
+	}
+}
+
+// withWorkers sets the worker count for the duration of a test or benchmark.
+func withWorkers(tb testing.TB, n int) {
+	tb.Helper()
+	old := numWorkers
+	if err := SetWorkers(n); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { numWorkers = old })
+}
+
+func TestSetWorkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		wantErr bool
+	}{
+		{"one", 1, false},
+		{"many", 16, false},
+		{"zero", 0, true},
+		{"negative", -2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withWorkers(t, 4)
+			if err := SetWorkers(tt.n); (err != nil) != tt.wantErr {
+				t.Fatalf("SetWorkers error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && numWorkers != 4 {
+				t.Errorf("worker count changed to %d", numWorkers)
+			}
+		})
+	}
+}
+
+func TestWorkersOutput(t *testing.T) {
+	source := stripesTensor(37, 101)
+	overlay := alphaRamp(37, 101)
+	run := func(t *testing.T, workers int) [][][]float64 {
+		withWorkers(t, workers)
+		img, err := TensorToImage(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tensor, err := ImageToTensor(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := AddOverlay(&tensor, &overlay); err != nil {
+			t.Fatal(err)
+		}
+		if err := Resize(&tensor, 23, 61); err != nil {
+			t.Fatal(err)
+		}
+		return tensor
+	}
+
+	want := run(t, 1)
+	for _, workers := range []int{2, 8} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			if got := run(t, workers); !pixelsNear(got, want, 0) {
+				t.Error("output differs from the single worker result")
+			}
+		})
+	}
+}
+
+// withStripeHeight sets the stripe height for the duration of a test or
+// benchmark.
+func withStripeHeight(tb testing.TB, height int) {
+	tb.Helper()
+	old := stripeHeight
+	if err := SetStripeHeight(height); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { stripeHeight = old })
+}
+
+// blurSharpen runs the multi-pass pipeline of the stripe benchmarks.
+func blurSharpen(tensor *[][][]float64) error {
+	if err := GaussianBlur(tensor, 3, 1.5); err != nil {
+		return err
+	}
+	return Sharpen(tensor, 1)
+}
+
+func TestSetStripeHeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		height  int
+		wantErr bool
+	}{
+		{"single row", 1, false},
+		{"default", 64, false},
+		{"taller than the image", 1000, false},
+		{"zero", 0, true},
+		{"negative", -8, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStripeHeight(t, 64)
+			if err := SetStripeHeight(tt.height); (err != nil) != tt.wantErr {
+				t.Fatalf("SetStripeHeight error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && stripeHeight != 64 {
+				t.Errorf("stripe height changed to %d", stripeHeight)
+			}
+		})
+	}
+}
+
+func TestStripeHeightOutput(t *testing.T) {
+	source := stripesTensor(37, 101)
+	want := cloneTensor(source)
+	if err := blurSharpen(&want); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, height := range []int{1, 7, 64, 101, 500} {
+		t.Run(fmt.Sprint(height), func(t *testing.T) {
+			withStripeHeight(t, height)
+			got := cloneTensor(source)
+			if err := blurSharpen(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(got, want, 0) {
+				t.Error("output differs from the default stripe height")
+			}
+		})
+	}
+}
+
+// BenchmarkStripes runs a blur and sharpen pipeline over a large image with
+// stripes of different heights. The band case gives each worker one stripe,
+// as the scheduler did before stripes were introduced.
+func BenchmarkStripes(b *testing.B) {
+	const width, height = 1000, 750
+	source := stripesTensor(width, height)
+
+	for _, bm := range []struct {
+		name   string
+		height int
+	}{
+		{"16", 16},
+		{"64", 64},
+		{"256", 256},
+		{"band", (height + numWorkers - 1) / numWorkers},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			withStripeHeight(b, bm.height)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tensor := cloneTensor(source)
+				b.StartTimer()
+				if err := blurSharpen(&tensor); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package imagetor
+
+import (
+	"fmt"
+	"math"
+)
+
+// PatternKind selects a calibration pattern generated by TestPattern.
+type PatternKind int
+
+const (
+	// PatternColorBars draws eight vertical bars: white, yellow, cyan, green,
+	// magenta, red, blue and black.
+	PatternColorBars PatternKind = iota
+	// PatternGrayRamp draws a horizontal gradient from black on the left to
+	// white on the right.
+	PatternGrayRamp
+	// PatternCheckerboard draws black and white squares, eight per short side.
+	PatternCheckerboard
+	// PatternResolutionChart draws a zone plate: concentric rings whose
+	// frequency rises from the center up to one cycle per two pixels at the
+	// edges, revealing aliasing and blur.
+	PatternResolutionChart
+)
+
+// colorBars are the colors of PatternColorBars, from left to right.
+var colorBars = [8][3]float64{
+	{1, 1, 1}, {1, 1, 0}, {0, 1, 1}, {0, 1, 0},
+	{1, 0, 1}, {1, 0, 0}, {0, 0, 1}, {0, 0, 0},
+}
+
+// TestPattern generates a standard calibration pattern, for checking displays
+// and the fidelity of encoding and processing pipelines.
+//
+// Args:
+//
+//	width: The width of the pattern.
+//	height: The height of the pattern.
+//	kind: The pattern to draw.
+//
+// Returns:
+//
+//	An opaque 3D tensor holding the pattern, or an error if a dimension is
+//	negative. Unknown kinds produce a black image.
+func TestPattern(width, height int, kind PatternKind) ([][][]float64, error) {
+	if width < 0 || height < 0 {
+		return nil, fmt.Errorf("invalid dimensions %dx%d", width, height)
+	}
+	tensor := newTensor(width, height)
+	cell := max(1, min(width, height)/8)
+	centerX, centerY := float64(width-1)/2, float64(height-1)/2
+	// Phase k*r² has a local frequency of 2*k*r radians per pixel, reaching
+	// π (the Nyquist limit) at the largest radius.
+	k := math.Pi / (2 * math.Max(1, math.Hypot(centerX, centerY)))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b float64
+			switch kind {
+			case PatternColorBars:
+				bar := colorBars[x*len(colorBars)/width]
+				r, g, b = bar[0], bar[1], bar[2]
+			case PatternGrayRamp:
+				if width > 1 {
+					r = float64(x) / float64(width-1)
+				}
+				g, b = r, r
+			case PatternCheckerboard:
+				if (x/cell+y/cell)%2 == 0 {
+					r, g, b = 1, 1, 1
+				}
+			case PatternResolutionChart:
+				dx, dy := float64(x)-centerX, float64(y)-centerY
+				r = 0.5 + 0.5*math.Cos(k*(dx*dx+dy*dy))
+				g, b = r, r
+			}
+			tensor[y][x][0], tensor[y][x][1], tensor[y][x][2], tensor[y][x][3] = r, g, b, 1
+		}
+	}
+	return tensor, nil
+}
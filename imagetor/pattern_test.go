@@ -0,0 +1,74 @@
+package imagetor
+
+import "testing"
+
+func TestTestPatternGrayRamp(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+	}{
+		{"wide", 256, 4},
+		{"odd width", 11, 3},
+		{"two columns", 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor, err := TestPattern(tt.width, tt.height, PatternGrayRamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(tensor); w != tt.width || h != tt.height {
+				t.Fatalf("pattern is %dx%d, want %dx%d", w, h, tt.width, tt.height)
+			}
+			for y, row := range tensor {
+				prev := -1.0
+				for x, p := range row {
+					l := luminance(p[0], p[1], p[2])
+					if l <= prev {
+						t.Fatalf("luminance at (%d, %d) is %v, not above %v", x, y, l, prev)
+					}
+					if p[3] != 1 {
+						t.Fatalf("pixel (%d, %d) has alpha %v", x, y, p[3])
+					}
+					prev = l
+				}
+				if first := luminance(row[0][0], row[0][1], row[0][2]); !near(first, 0, 1e-9) {
+					t.Errorf("row %d starts at %v, want 0", y, first)
+				}
+				if !near(prev, 1, 1e-9) {
+					t.Errorf("row %d ends at %v, want 1", y, prev)
+				}
+			}
+		})
+	}
+}
+
+func TestTestPatternDimensions(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		kind          PatternKind
+		wantErr       bool
+	}{
+		{"color bars", 16, 8, PatternColorBars, false},
+		{"checkerboard", 16, 16, PatternCheckerboard, false},
+		{"resolution chart", 9, 7, PatternResolutionChart, false},
+		{"empty", 0, 0, PatternGrayRamp, false},
+		{"negative width", -1, 8, PatternGrayRamp, true},
+		{"negative height", 8, -4, PatternCheckerboard, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor, err := TestPattern(tt.width, tt.height, tt.kind)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TestPattern error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if w, h, _ := Dimensions(tensor); w != tt.width || h != tt.height {
+				t.Errorf("pattern is %dx%d, want %dx%d", w, h, tt.width, tt.height)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package imagetor
+
+import "fmt"
+
+// Pipeline runs a sequence of operations on an image.
+//
+// By default the image is quantized to 8 bits per channel after every
+// operation, reproducing exactly what a chain of tools that each save and
+// reload an 8-bit image would produce. Setting KeepFloat keeps the full
+// precision of the tensor across all operations instead, so rounding errors do
+// not accumulate and the image is only quantized once, when it is finally
+// saved.
+type Pipeline struct {
+	// Ops are the operations to run, in order.
+	Ops []Op
+	// KeepFloat skips the 8-bit quantization between operations.
+	KeepFloat bool
+}
+
+// quantize8 rounds every channel of a tensor to 8 bits in place.
+func quantize8(tensor [][][]float64) error {
+	return parallelRows(len(tensor), func(start, end int) {
+		for y := start; y < end; y++ {
+			for _, pixel := range tensor[y] {
+				q := quantizeColor(pixel)
+				for c := 0; c < channels; c++ {
+					pixel[c] = float64(q[c]) / 255
+				}
+			}
+		}
+	})
+}
+
+// Run applies the operations of the pipeline to an image.
+//
+// Args:
+//
+//	tensor: A pointer to the 3D tensor representing the image.
+//
+// Returns:
+//
+//	The error of the first operation that fails, wrapped with its position in
+//	the pipeline. The remaining operations are not run.
+func (p Pipeline) Run(tensor *[][][]float64) error {
+	for i, op := range p.Ops {
+		if err := op(tensor); err != nil {
+			return fmt.Errorf("pipeline step %d: %w", i, err)
+		}
+		if !p.KeepFloat {
+			if err := quantize8(*tensor); err != nil {
+				return fmt.Errorf("pipeline step %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
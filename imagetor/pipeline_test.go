@@ -0,0 +1,101 @@
+package imagetor
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// scaleOp returns an operation multiplying the color channels by factor.
+func scaleOp(factor float64) Op {
+	return func(t *[][][]float64) error {
+		for _, row := range *t {
+			for _, p := range row {
+				for c := 0; c < 3; c++ {
+					p[c] *= factor
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// meanError returns the mean absolute difference of the color channels of
+// two equally sized tensors.
+func meanError(a, b [][][]float64) float64 {
+	sum, count := 0.0, 0
+	for y := range a {
+		for x := range a[y] {
+			for c := 0; c < 3; c++ {
+				sum += math.Abs(a[y][x][c] - b[y][x][c])
+				count++
+			}
+		}
+	}
+	return sum / float64(count)
+}
+
+func TestPipelineKeepFloat(t *testing.T) {
+	// Five darken and brighten pairs, which cancel out exactly in float.
+	var ops []Op
+	for i := 0; i < 5; i++ {
+		ops = append(ops, scaleOp(0.37), scaleOp(1/0.37))
+	}
+	source := quantized(gradientTensor(64, 64))
+
+	run := func(keepFloat bool) [][][]float64 {
+		tensor := cloneTensor(source)
+		if err := (Pipeline{Ops: ops, KeepFloat: keepFloat}).Run(&tensor); err != nil {
+			t.Fatal(err)
+		}
+		// Quantize once more, as saving the result would.
+		return quantized(tensor)
+	}
+
+	floatErr := meanError(run(true), source)
+	roundTripErr := meanError(run(false), source)
+	if floatErr >= roundTripErr {
+		t.Errorf("KeepFloat error %v is not below the round-tripping error %v", floatErr, roundTripErr)
+	}
+	if floatErr > 1e-9 {
+		t.Errorf("KeepFloat error = %v, want 0", floatErr)
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	errOp := errors.New("op failed")
+	ran := 0
+	count := func(*[][][]float64) error {
+		ran++
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		ops        []Op
+		wantRan    int
+		wantErr    error
+		wantInStep string
+	}{
+		{"all steps", []Op{count, count, count}, 3, nil, ""},
+		{"empty", nil, 0, nil, ""},
+		{"failing step", []Op{count, func(*[][][]float64) error { return errOp }, count}, 1, errOp, "step 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = 0
+			tensor := gradientTensor(4, 4)
+			err := Pipeline{Ops: tt.ops}.Run(&tensor)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.wantInStep) {
+				t.Errorf("error %q does not name %s", err, tt.wantInStep)
+			}
+			if ran != tt.wantRan {
+				t.Errorf("%d operations ran, want %d", ran, tt.wantRan)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package imagetor
+
+import "sync"
+
+// TensorPool recycles tensors between frames of a video or stream to reduce
+// how many tensors each frame allocates, and later garbage collects.
+//
+// Allocations are reduced, not avoided: tensors are pooled by dimensions, and
+// Get only hands out a recycled tensor of exactly the requested size,
+// allocating a fresh one when none is available. Pooled tensors are held in a
+// sync.Pool, so any of them may be dropped at a garbage collection. The zero
+// value is an empty pool ready for use, and a pool is safe for concurrent use.
+//
+// Ownership rules: a tensor obtained from Get belongs to the caller until it
+// is passed to Put. After Put, the caller must not read or write the tensor,
+// or any row or pixel slice taken from it, as it may already have been handed
+// to another caller. Never Put a tensor that is still referenced elsewhere,
+// and never Put the same tensor twice.
+type TensorPool struct {
+	mu sync.Mutex
+	// pools holds *[][][]float64 values. Put still allocates a small slice
+	// header for each of them, but never the rows or pixels of a tensor.
+	pools map[[2]int]*sync.Pool
+}
+
+// pool returns the pool holding tensors of the given dimensions.
+func (p *TensorPool) pool(width, height int) *sync.Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pools == nil {
+		p.pools = make(map[[2]int]*sync.Pool)
+	}
+	key := [2]int{width, height}
+	pool, ok := p.pools[key]
+	if !ok {
+		pool = &sync.Pool{}
+		p.pools[key] = pool
+	}
+	return pool
+}
+
+// Get returns a zeroed tensor of the given dimensions, reusing a tensor
+// previously returned with Put when one of the same size is available.
+//
+// Args:
+//
+//	width: The width of the tensor.
+//	height: The height of the tensor.
+//
+// Returns:
+//
+//	A zeroed 3D tensor of height rows and width pixels.
+func (p *TensorPool) Get(width, height int) [][][]float64 {
+	if ptr, ok := p.pool(width, height).Get().(*[][][]float64); ok {
+		tensor := *ptr
+		for _, row := range tensor {
+			for _, pixel := range row {
+				clear(pixel)
+			}
+		}
+		return tensor
+	}
+	return newTensor(width, height)
+}
+
+// Put returns a tensor to the pool so that a later Get of the same size can
+// reuse it. See the TensorPool ownership rules.
+//
+// Args:
+//
+//	tensor: The tensor to recycle. Empty tensors are ignored.
+func (p *TensorPool) Put(tensor [][][]float64) {
+	width, height, _ := Dimensions(tensor)
+	if width == 0 || height == 0 {
+		return
+	}
+	p.pool(width, height).Put(&tensor)
+}
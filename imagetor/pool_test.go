@@ -0,0 +1,81 @@
+package imagetor
+
+import "testing"
+
+func TestTensorPool(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		putW, putH    int
+	}{
+		{"same size", 8, 6, 8, 6},
+		{"different size", 8, 6, 6, 8},
+		{"single pixel", 1, 1, 1, 1},
+		{"empty put", 3, 2, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pool TensorPool
+			dirty := newTensor(tt.putW, tt.putH)
+			for _, row := range dirty {
+				for _, p := range row {
+					p[0], p[1], p[2], p[3] = 1, 1, 1, 1
+				}
+			}
+			pool.Put(dirty)
+
+			for i := 0; i < 3; i++ {
+				tensor := pool.Get(tt.width, tt.height)
+				width, height, channels := Dimensions(tensor)
+				if width != tt.width || height != tt.height || channels != 4 {
+					t.Fatalf("Get returned %dx%dx%d, want %dx%dx4", width, height, channels, tt.width, tt.height)
+				}
+				for y, row := range tensor {
+					for x, p := range row {
+						if p[0] != 0 || p[1] != 0 || p[2] != 0 || p[3] != 0 {
+							t.Fatalf("pixel (%d, %d) = %v, want zero", x, y, p)
+						}
+					}
+				}
+				tensor[0][0][0] = 1
+				pool.Put(tensor)
+			}
+		})
+	}
+}
+
+// BenchmarkTensorPool processes 100 frames of the same size, allocating the
+// output tensor of each frame either fresh or from a TensorPool.
+func BenchmarkTensorPool(b *testing.B) {
+	const width, height, frames = 320, 240, 100
+
+	benchmarks := []struct {
+		name string
+		get  func(p *TensorPool) [][][]float64
+		put  func(p *TensorPool, tensor [][][]float64)
+	}{
+		{
+			name: "alloc",
+			get:  func(p *TensorPool) [][][]float64 { return newTensor(width, height) },
+			put:  func(p *TensorPool, tensor [][][]float64) {},
+		},
+		{
+			name: "pool",
+			get:  func(p *TensorPool) [][][]float64 { return p.Get(width, height) },
+			put:  func(p *TensorPool, tensor [][][]float64) { p.Put(tensor) },
+		},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			var pool TensorPool
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for f := 0; f < frames; f++ {
+					frame := bm.get(&pool)
+					frame[0][0][0] = float64(f)
+					bm.put(&pool, frame)
+				}
+			}
+		})
+	}
+}
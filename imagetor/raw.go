@@ -0,0 +1,143 @@
+package imagetor
+
+import (
+	"fmt"
+	"math"
+)
+
+// ToRGBABytes converts a tensor to raw 8-bit RGBA pixels, for piping into
+// tools such as ffmpeg's rawvideo input or for uploading to a GPU.
+//
+// Pixels are written row by row, four bytes per pixel, with each channel
+// clamped to [0, 1] and rounded to 8 bits. Like image.RGBA, the color channels
+// hold the premultiplied values of the tensor.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//
+// Returns:
+//
+//	A byte slice of width*height*4 bytes, or an error if the tensor is empty
+//	or ragged.
+func ToRGBABytes(tensor [][][]float64) ([]byte, error) {
+	height, width, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, height*width*channels)
+	for _, row := range tensor {
+		for _, pixel := range row {
+			for c := 0; c < channels; c++ {
+				buf = append(buf, uint8(math.Round(clamp(pixel[c])*255)))
+			}
+		}
+	}
+	return buf, nil
+}
+
+// FromRGBABytes converts raw 8-bit RGBA pixels, laid out as produced by
+// ToRGBABytes, to a tensor.
+//
+// Args:
+//
+//	buf: The pixel data, row by row with four bytes per pixel.
+//	width: The width of the image.
+//	height: The height of the image.
+//
+// Returns:
+//
+//	The 3D tensor representing the image, or an error if the dimensions are
+//	negative or do not match the length of buf.
+func FromRGBABytes(buf []byte, width, height int) ([][][]float64, error) {
+	if width < 0 || height < 0 {
+		return nil, fmt.Errorf("invalid dimensions %dx%d", width, height)
+	}
+	if len(buf) != width*height*channels {
+		return nil, fmt.Errorf("buffer holds %d bytes, %dx%d RGBA needs %d", len(buf), width, height, width*height*channels)
+	}
+
+	tensor := newTensor(width, height)
+	i := 0
+	for _, row := range tensor {
+		for _, pixel := range row {
+			for c := 0; c < channels; c++ {
+				pixel[c] = float64(buf[i]) / 255
+				i++
+			}
+		}
+	}
+	return tensor, nil
+}
+
+// ToMatBytes converts a tensor to 8-bit BGR pixels in the default memory
+// layout of an OpenCV Mat of type CV_8UC3, for handing images to gocv or
+// Gorgonia without reordering channels by hand.
+//
+// Rows are contiguous, three bytes per pixel in blue, green, red order, with
+// each channel clamped to [0, 1] and rounded to 8 bits. Alpha is dropped, so
+// transparent areas come out as black.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//
+// Returns:
+//
+//	The pixel data and the stride, the number of bytes per row, which is
+//	width*3, or an error if the tensor is empty or ragged.
+func ToMatBytes(tensor [][][]float64) ([]byte, int, error) {
+	height, width, err := dimsOf(tensor)
+	if err != nil {
+		return nil, 0, err
+	}
+	stride := width * 3
+	buf := make([]byte, 0, height*stride)
+	for _, row := range tensor {
+		for _, pixel := range row {
+			for c := 2; c >= 0; c-- {
+				buf = append(buf, uint8(math.Round(clamp(pixel[c])*255)))
+			}
+		}
+	}
+	return buf, stride, nil
+}
+
+// FromMatBytes converts 8-bit BGR pixels in the memory layout of an OpenCV
+// Mat of type CV_8UC3 to an opaque tensor.
+//
+// Args:
+//
+//	buf: The pixel data, three bytes per pixel in blue, green, red order.
+//	width: The width of the image.
+//	height: The height of the image.
+//	stride: The number of bytes from the start of one row to the next, as
+//	  given by Mat.Step. Rows may be padded, so it can exceed width*3.
+//
+// Returns:
+//
+//	The 3D tensor representing the image, or an error if the dimensions are
+//	negative, the stride is shorter than a row or buf is too short.
+func FromMatBytes(buf []byte, width, height, stride int) ([][][]float64, error) {
+	if width < 0 || height < 0 {
+		return nil, fmt.Errorf("invalid dimensions %dx%d", width, height)
+	}
+	if stride < width*3 {
+		return nil, fmt.Errorf("stride %d is shorter than a row of %d bytes", stride, width*3)
+	}
+	if height > 0 && len(buf) < (height-1)*stride+width*3 {
+		return nil, fmt.Errorf("buffer holds %d bytes, %dx%d BGR with stride %d needs %d", len(buf), width, height, stride, (height-1)*stride+width*3)
+	}
+
+	tensor := newTensor(width, height)
+	for y, row := range tensor {
+		for x, pixel := range row {
+			i := y*stride + x*3
+			pixel[0] = float64(buf[i+2]) / 255
+			pixel[1] = float64(buf[i+1]) / 255
+			pixel[2] = float64(buf[i]) / 255
+			pixel[3] = 1
+		}
+	}
+	return tensor, nil
+}
@@ -0,0 +1,149 @@
+package imagetor
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// quantized returns a copy of a tensor with every channel clamped and rounded
+// to 8 bits.
+func quantized(tensor [][][]float64) [][][]float64 {
+	result := cloneTensor(tensor)
+	for _, row := range result {
+		for _, p := range row {
+			for c := range p {
+				p[c] = math.Round(clamp(p[c])*255) / 255
+			}
+		}
+	}
+	return result
+}
+
+func TestRGBABytesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+	}{
+		{"gradient", gradientTensor(7, 5)},
+		{"translucent", solidTensor(3, 2, [4]float64{0.2, 0.1, 0.3, 0.4})},
+		{"out of range", [][][]float64{{{-0.5, 1.5, 0.5, 1}, {0.333, 0.667, 0.001, 0.999}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, _ := Dimensions(tt.tensor)
+			buf, err := ToRGBABytes(tt.tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(buf) != width*height*4 {
+				t.Fatalf("got %d bytes, want %d", len(buf), width*height*4)
+			}
+
+			got, err := FromRGBABytes(buf, width, height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(got, quantized(tt.tensor), 1e-12) {
+				t.Errorf("round trip = %v, want %v", got, quantized(tt.tensor))
+			}
+
+			again, err := ToRGBABytes(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, buf) {
+				t.Error("second round trip changed the bytes")
+			}
+		})
+	}
+}
+
+func TestRGBABytesErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		buf           []byte
+		width, height int
+	}{
+		{"short buffer", make([]byte, 15), 2, 2},
+		{"long buffer", make([]byte, 17), 2, 2},
+		{"negative width", nil, -1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromRGBABytes(tt.buf, tt.width, tt.height); err == nil {
+				t.Error("FromRGBABytes did not return an error")
+			}
+		})
+	}
+
+	if _, err := ToRGBABytes(nil); err == nil {
+		t.Error("ToRGBABytes of an empty tensor did not return an error")
+	}
+}
+
+func TestMatBytesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+		// padding is the number of bytes added to the end of every row.
+		padding int
+	}{
+		{"gradient", gradientTensor(7, 5), 0},
+		{"padded rows", gradientTensor(5, 3), 3},
+		{"single pixel", [][][]float64{{{0.2, 0.4, 0.6, 1}}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, _ := Dimensions(tt.tensor)
+			buf, stride, err := ToMatBytes(tt.tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stride != width*3 || len(buf) != height*stride {
+				t.Fatalf("got %d bytes with stride %d, want %d with stride %d", len(buf), stride, height*width*3, width*3)
+			}
+			// The first pixel is stored blue first.
+			first := quantized(tt.tensor)[0][0]
+			if !near(float64(buf[0])/255, first[2], 1e-12) || !near(float64(buf[2])/255, first[0], 1e-12) {
+				t.Errorf("first pixel bytes %v are not in BGR order of %v", buf[:3], first)
+			}
+
+			if tt.padding > 0 {
+				padded := make([]byte, 0, height*(stride+tt.padding))
+				for y := 0; y < height; y++ {
+					padded = append(padded, buf[y*stride:(y+1)*stride]...)
+					padded = append(padded, make([]byte, tt.padding)...)
+				}
+				buf, stride = padded, stride+tt.padding
+			}
+
+			got, err := FromMatBytes(buf, width, height, stride)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(got, quantized(tt.tensor), 1e-12) {
+				t.Errorf("round trip = %v, want %v", got, quantized(tt.tensor))
+			}
+		})
+	}
+}
+
+func TestFromMatBytesErrors(t *testing.T) {
+	tests := []struct {
+		name                  string
+		buf                   []byte
+		width, height, stride int
+	}{
+		{"short buffer", make([]byte, 11), 2, 2, 6},
+		{"stride shorter than a row", make([]byte, 12), 2, 2, 5},
+		{"negative height", nil, 2, -1, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromMatBytes(tt.buf, tt.width, tt.height, tt.stride); err == nil {
+				t.Error("FromMatBytes did not return an error")
+			}
+		})
+	}
+}
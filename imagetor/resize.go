@@ -0,0 +1,693 @@
+package imagetor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// resample resizes a tensor using bilinear interpolation. It is the shared
+// core of ResizeFit and of the overlay scaling in AddOverlay, and runs the
+// same flat Tensor.resize as Resize, so every bilinear resize in the package
+// produces identical pixels.
+//
+// Samples at the right and bottom edges interpolate towards the last source
+// column and row instead of being skipped, so no output pixel is left black.
+//
+// When premultiply is set, the color channels are multiplied by alpha before
+// interpolating and divided by it afterwards, so that the color of transparent
+// pixels cannot bleed into their opaque neighbors as dark or colored halos.
+// This is required for tensors holding straight (non-premultiplied) alpha;
+// tensors produced by ImageToTensor are already premultiplied.
+//
+// Args:
+//
+//	tensor: The tensor to resize.
+//	width: The width of the resized tensor.
+//	height: The height of the resized tensor.
+//	premultiply: Whether to interpolate color premultiplied by alpha.
+//
+// Returns:
+//
+//	A new tensor with the requested dimensions, or an error if the tensor is
+//	empty or ragged, a dimension is less than 1 or a worker panics.
+func resample(tensor [][][]float64, width int, height int, premultiply bool) ([][][]float64, error) {
+	flat, err := FromNested(tensor)
+	if err != nil {
+		return nil, err
+	}
+	if premultiply {
+		premultiplyFlat(flat)
+	}
+	if err := flat.resize(context.Background(), width, height); err != nil {
+		return nil, err
+	}
+
+	result := flat.Nested()
+	if premultiply {
+		unpremultiply(result)
+	}
+	return result, nil
+}
+
+// premultiplyFlat multiplies the color channels of a straight-alpha flat
+// tensor by alpha in place.
+func premultiplyFlat(t Tensor) {
+	for i := 0; i < len(t.Data); i += t.Channels {
+		for c := 0; c < 3; c++ {
+			t.Data[i+c] *= t.Data[i+3]
+		}
+	}
+}
+
+// premultiplied returns a copy of a straight-alpha tensor with its color
+// channels multiplied by alpha.
+func premultiplied(tensor [][][]float64) [][][]float64 {
+	result := newTensor(len(tensor[0]), len(tensor))
+	for y, row := range tensor {
+		for x, pixel := range row {
+			for c := 0; c < 3; c++ {
+				result[y][x][c] = pixel[c] * pixel[3]
+			}
+			result[y][x][3] = pixel[3]
+		}
+	}
+	return result
+}
+
+// unpremultiply divides the color channels of a premultiplied tensor by alpha
+// in place, leaving fully transparent pixels black.
+func unpremultiply(tensor [][][]float64) {
+	for _, row := range tensor {
+		for _, pixel := range row {
+			for c := 0; c < 3; c++ {
+				if pixel[3] > 0 {
+					pixel[c] /= pixel[3]
+				} else {
+					pixel[c] = 0
+				}
+			}
+		}
+	}
+}
+
+// areaWeight is the contribution of one source pixel to a destination pixel
+// along a single axis.
+type areaWeight struct {
+	index  int
+	weight float64
+}
+
+// areaWeights computes, for each of the dst destination pixels along an axis,
+// the source pixels it covers and the fraction of its area each one occupies.
+func areaWeights(src, dst int) [][]areaWeight {
+	scale := float64(src) / float64(dst)
+	weights := make([][]areaWeight, dst)
+	for i := 0; i < dst; i++ {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for s := int(start); s < src && float64(s) < end; s++ {
+			overlap := math.Min(end, float64(s+1)) - math.Max(start, float64(s))
+			if overlap > 0 {
+				weights[i] = append(weights[i], areaWeight{s, overlap / scale})
+			}
+		}
+	}
+	return weights
+}
+
+// resizeArea resizes a tensor by averaging every source pixel that falls
+// within each destination pixel, weighted by the area it covers.
+//
+// This is the appropriate filter for downscaling, as every source pixel
+// contributes to the result and fine detail cannot alias.
+//
+// Args:
+//
+//	tensor: The tensor to resize.
+//	width: The width of the resized tensor.
+//	height: The height of the resized tensor.
+//
+// Returns:
+//
+//	A new tensor with the requested dimensions, or an error if the tensor is
+//	empty or ragged or a worker panics.
+func resizeArea(tensor [][][]float64, width int, height int) ([][][]float64, error) {
+	oldHeight, oldWidth, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+	return resizeSeparable(tensor, areaWeights(oldWidth, width), areaWeights(oldHeight, height))
+}
+
+// bilinearWeights computes, for each of the dst destination pixels along an
+// axis, the two source pixels resample interpolates between and their weights.
+func bilinearWeights(src, dst int) [][]areaWeight {
+	weights := make([][]areaWeight, dst)
+	for i := 0; i < dst; i++ {
+		old := float64(i) * float64(src) / float64(dst)
+		i0 := int(old)
+		i1 := min(i0+1, src-1)
+		d := old - float64(i0)
+		weights[i] = []areaWeight{{i0, 1 - d}, {i1, d}}
+	}
+	return weights
+}
+
+// catmullRom evaluates the Catmull-Rom cubic kernel, which is 0 from a
+// distance of 2 on.
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return 1.5*x*x*x - 2.5*x*x + 1
+	case x < 2:
+		return -0.5*x*x*x + 2.5*x*x - 4*x + 2
+	default:
+		return 0
+	}
+}
+
+// bicubicWeights computes, for each of the dst destination pixels along an
+// axis, the source pixels and Catmull-Rom weights of bicubic resampling.
+// Indices past the edges are clamped to the border pixels. When downscaling,
+// the kernel is stretched by the scale factor so that it still covers every
+// source pixel, as area resampling does, instead of aliasing.
+func bicubicWeights(src, dst int) [][]areaWeight {
+	scale := math.Max(1, float64(src)/float64(dst))
+	support := 2 * scale
+	weights := make([][]areaWeight, dst)
+	for i := range weights {
+		center := (float64(i)+0.5)*float64(src)/float64(dst) - 0.5
+		total := 0.0
+		for s := int(math.Floor(center - support + 1)); float64(s) <= center+support; s++ {
+			w := catmullRom((float64(s) - center) / scale)
+			if w == 0 {
+				continue
+			}
+			weights[i] = append(weights[i], areaWeight{clampIndex(s, src), w})
+			total += w
+		}
+		for k := range weights[i] {
+			weights[i][k].weight /= total
+		}
+	}
+	return weights
+}
+
+// clampTensor clamps every channel of a tensor to [0, 1] in place, removing
+// the overshoot of kernels with negative lobes.
+func clampTensor(tensor [][][]float64) {
+	for _, row := range tensor {
+		for _, pixel := range row {
+			for c := range pixel {
+				pixel[c] = clamp(pixel[c])
+			}
+		}
+	}
+}
+
+// resizeBicubic returns a copy of a tensor resized to width x height with
+// bicubic resampling.
+func resizeBicubic(tensor [][][]float64, width, height int) ([][][]float64, error) {
+	oldHeight, oldWidth, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+	result, err := resizeSeparable(tensor, bicubicWeights(oldWidth, width), bicubicWeights(oldHeight, height))
+	if err != nil {
+		return nil, err
+	}
+	clampTensor(result)
+	return result, nil
+}
+
+// nearestIndex returns the source index whose pixel center is nearest to the
+// center of destination index i, when resizing src pixels to dst.
+func nearestIndex(i, src, dst int) int {
+	return min(int((float64(i)+0.5)*float64(src)/float64(dst)), src-1)
+}
+
+// nearestWeights returns the single source pixel each destination pixel takes
+// along one axis for nearest-neighbor resampling.
+func nearestWeights(src, dst int) [][]areaWeight {
+	weights := make([][]areaWeight, dst)
+	for i := range weights {
+		weights[i] = []areaWeight{{nearestIndex(i, src, dst), 1}}
+	}
+	return weights
+}
+
+// resizeNearest returns a copy of a tensor resized to width x height by
+// copying the nearest source pixel to each destination pixel.
+func resizeNearest(tensor [][][]float64, width, height int) ([][][]float64, error) {
+	oldHeight, oldWidth, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]int, width)
+	for x := range columns {
+		columns[x] = nearestIndex(x, oldWidth, width)
+	}
+
+	result := newTensor(width, height)
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := tensor[nearestIndex(y, oldHeight, height)]
+			for x, sx := range columns {
+				copy(result[y][x], row[sx])
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// resizeDirect resizes a tensor to len(xWeights) x len(yWeights), weighting
+// every source pixel by the product of its weights along either axis in a
+// single pass. It suits filters with few taps, for which the intermediate
+// tensor of resizeSeparable costs more than it saves.
+func resizeDirect(tensor [][][]float64, xWeights, yWeights [][]areaWeight) ([][][]float64, error) {
+	width, height := len(xWeights), len(yWeights)
+	result := newTensor(width, height)
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				out := result[y][x]
+				for _, wy := range yWeights[y] {
+					row := tensor[wy.index]
+					for _, wx := range xWeights[x] {
+						w := wy.weight * wx.weight
+						p := row[wx.index]
+						for c := 0; c < channels; c++ {
+							out[c] += w * p[c]
+						}
+					}
+				}
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// resizeSeparable resizes a tensor to len(xWeights) x len(yWeights) with a
+// separable filter, given the source pixels and weights each destination
+// pixel takes along either axis.
+func resizeSeparable(tensor [][][]float64, xWeights, yWeights [][]areaWeight) ([][][]float64, error) {
+	oldHeight := len(tensor)
+	width, height := len(xWeights), len(yWeights)
+
+	// Horizontal pass into an intermediate tensor of oldHeight x width.
+	temp := newTensor(width, oldHeight)
+	if err := parallelRows(oldHeight, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				for _, w := range xWeights[x] {
+					for c := 0; c < channels; c++ {
+						temp[y][x][c] += w.weight * tensor[y][w.index][c]
+					}
+				}
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	// Vertical pass into the result.
+	result := newTensor(width, height)
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for _, w := range yWeights[y] {
+				for x := 0; x < width; x++ {
+					for c := 0; c < channels; c++ {
+						result[y][x][c] += w.weight * temp[w.index][x][c]
+					}
+				}
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GenerateResponsiveSet produces downscaled copies of an image at each of the
+// requested widths, for use as the candidates of a responsive srcset.
+//
+// Each copy keeps the aspect ratio of the original. The widths are processed
+// from largest to smallest and each copy is area-downscaled from the previous
+// one, so the cost of the whole set stays close to that of the first resize.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	widths: The widths of the copies to generate.
+//
+// Returns:
+//
+//	A map from each requested width to its downscaled tensor, or an error if
+//	the tensor is empty or ragged or a width is not between 1 and the image width.
+func GenerateResponsiveSet(tensor [][][]float64, widths []int) (map[int][][][]float64, error) {
+	height, width, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := append([]int(nil), widths...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	set := make(map[int][][][]float64, len(sorted))
+	current := tensor
+	for _, w := range sorted {
+		if w < 1 || w > width {
+			return nil, fmt.Errorf("width %d is outside the range 1 to %d", w, width)
+		}
+		if _, ok := set[w]; ok {
+			continue
+		}
+		h := max(1, int(math.Round(float64(w)*float64(height)/float64(width))))
+		if current, err = resizeArea(current, w, h); err != nil {
+			return nil, err
+		}
+		set[w] = current
+	}
+	return set, nil
+}
+
+// DownscaleInt downscales an image by an integer factor, averaging each
+// factor x factor block of source pixels into one output pixel.
+//
+// Unlike interpolating resizes, every source pixel contributes with equal
+// weight to exactly one output pixel, so the result is free of aliasing and
+// resampling artifacts. Both dimensions must be divisible by factor.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	factor: The downscaling factor, at least 1.
+//
+// Returns:
+//
+//	The downscaled tensor, or an error if the tensor is empty or ragged, factor is less
+//	than 1 or the dimensions are not divisible by factor.
+func DownscaleInt(tensor [][][]float64, factor int) ([][][]float64, error) {
+	oldHeight, oldWidth, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+	if factor < 1 {
+		return nil, fmt.Errorf("factor must be at least 1, got %d", factor)
+	}
+	if oldWidth%factor != 0 || oldHeight%factor != 0 {
+		return nil, fmt.Errorf("dimensions %dx%d are not divisible by %d", oldWidth, oldHeight, factor)
+	}
+
+	width, height := oldWidth/factor, oldHeight/factor
+	area := float64(factor * factor)
+	result := newTensor(width, height)
+
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				out := result[y][x]
+				for sy := y * factor; sy < (y+1)*factor; sy++ {
+					for sx := x * factor; sx < (x+1)*factor; sx++ {
+						for c := 0; c < channels; c++ {
+							out[c] += tensor[sy][sx][c]
+						}
+					}
+				}
+				for c := 0; c < channels; c++ {
+					out[c] /= area
+				}
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UpscaleAlgo selects the algorithm used by Upscale2x.
+type UpscaleAlgo int
+
+const (
+	// UpscalePixelDouble repeats every pixel into a 2x2 block.
+	UpscalePixelDouble UpscaleAlgo = iota
+	// UpscaleScale2x uses the Scale2x (EPX) pixel-art algorithm, which rounds
+	// off the stair steps of diagonal lines without introducing new colors.
+	UpscaleScale2x
+)
+
+// samePixel reports whether two pixels are exactly equal in every channel.
+func samePixel(a, b []float64) bool {
+	for c := 0; c < channels; c++ {
+		if a[c] != b[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// Upscale2x doubles the dimensions of an image without interpolation, for
+// enlarging pixel art and retro sprites that bilinear resizing would blur.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	algorithm: The upscaling algorithm. Unknown algorithms double pixels.
+//
+// Returns:
+//
+//	A new tensor twice as wide and high as the input, holding only colors
+//	present in the input, or an error if the tensor is empty or ragged.
+func Upscale2x(tensor [][][]float64, algorithm UpscaleAlgo) ([][][]float64, error) {
+	height, width, err := dimsOf(tensor)
+	if err != nil {
+		return nil, err
+	}
+	result := newTensor(2*width, 2*height)
+
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				p := tensor[y][x]
+				e := [4][]float64{p, p, p, p}
+				if algorithm == UpscaleScale2x {
+					a := tensor[clampIndex(y-1, height)][x]
+					b := tensor[y][clampIndex(x+1, width)]
+					c := tensor[y][clampIndex(x-1, width)]
+					d := tensor[clampIndex(y+1, height)][x]
+					if !samePixel(c, b) && !samePixel(a, d) {
+						if samePixel(c, a) {
+							e[0] = a
+						}
+						if samePixel(a, b) {
+							e[1] = b
+						}
+						if samePixel(d, c) {
+							e[2] = c
+						}
+						if samePixel(b, d) {
+							e[3] = d
+						}
+					}
+				}
+				copy(result[2*y][2*x], e[0])
+				copy(result[2*y][2*x+1], e[1])
+				copy(result[2*y+1][2*x], e[2])
+				copy(result[2*y+1][2*x+1], e[3])
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ResizeMethod selects the filter used to resample an image.
+type ResizeMethod int
+
+const (
+	// ResampleBilinear interpolates between the four nearest source pixels.
+	ResampleBilinear ResizeMethod = iota
+	// ResampleArea averages the source pixels covered by each destination
+	// pixel, which avoids aliasing when downscaling.
+	ResampleArea
+	// ResampleNearest copies the nearest source pixel, which keeps hard edges
+	// such as those of pixel art and masks.
+	ResampleNearest
+	// ResampleBicubic interpolates the 4x4 nearest source pixels with a
+	// Catmull-Rom kernel, giving sharper results than bilinear.
+	ResampleBicubic
+)
+
+// resizeWith resizes a tensor with the given method. Unknown methods resample
+// bilinearly. See resample for the meaning of premultiply.
+func resizeWith(tensor [][][]float64, width, height int, method ResizeMethod, premultiply bool) ([][][]float64, error) {
+	var resize func([][][]float64, int, int) ([][][]float64, error)
+	switch method {
+	case ResampleArea:
+		resize = resizeArea
+	case ResampleNearest:
+		return resizeNearest(tensor, width, height)
+	case ResampleBicubic:
+		resize = resizeBicubic
+	default:
+		return resample(tensor, width, height, premultiply)
+	}
+	if !premultiply {
+		return resize(tensor, width, height)
+	}
+	if _, _, err := dimsOf(tensor); err != nil {
+		return nil, err
+	}
+	result, err := resize(premultiplied(tensor), width, height)
+	if err != nil {
+		return nil, err
+	}
+	unpremultiply(result)
+	return result, nil
+}
+
+// Resizer resizes images of one size to another, computing the source pixels
+// and weights of every destination pixel once instead of on every call. It
+// speeds up resizing many frames of the same size, such as those of a video.
+//
+// A Resizer is not modified by Resize and may be used from several goroutines.
+type Resizer struct {
+	srcWidth, srcHeight int
+	method              ResizeMethod
+	xWeights, yWeights  [][]areaWeight
+}
+
+// NewResizer creates a Resizer from srcWidth x srcHeight to dstWidth x
+// dstHeight.
+//
+// Args:
+//
+//	srcWidth: The width of the images to resize.
+//	srcHeight: The height of the images to resize.
+//	dstWidth: The width of the resized images.
+//	dstHeight: The height of the resized images.
+//	method: The resampling filter. Unknown methods resample bilinearly.
+//
+// Returns:
+//
+//	The Resizer.
+func NewResizer(srcWidth, srcHeight, dstWidth, dstHeight int, method ResizeMethod) *Resizer {
+	r := &Resizer{srcWidth: srcWidth, srcHeight: srcHeight, method: method}
+	switch method {
+	case ResampleArea:
+		r.xWeights, r.yWeights = areaWeights(srcWidth, dstWidth), areaWeights(srcHeight, dstHeight)
+	case ResampleNearest:
+		r.xWeights, r.yWeights = nearestWeights(srcWidth, dstWidth), nearestWeights(srcHeight, dstHeight)
+	case ResampleBicubic:
+		r.xWeights, r.yWeights = bicubicWeights(srcWidth, dstWidth), bicubicWeights(srcHeight, dstHeight)
+	default:
+		r.xWeights, r.yWeights = bilinearWeights(srcWidth, dstWidth), bilinearWeights(srcHeight, dstHeight)
+	}
+	return r
+}
+
+// Resize resizes a tensor with the precomputed weights.
+//
+// Tensors whose dimensions differ from the source dimensions of the Resizer
+// are still resized to its destination dimensions, but without the benefit of
+// the precomputed weights.
+//
+// Args:
+//
+//	tensor: A pointer to the tensor to resize.
+//
+// Returns:
+//
+//	An error if the tensor is ragged or a worker panics, in which case it is
+//	left unchanged.
+func (r *Resizer) Resize(tensor *[][][]float64) error {
+	var result [][][]float64
+	var err error
+	switch width, height, _ := Dimensions(*tensor); {
+	case width != r.srcWidth || height != r.srcHeight || height == 0:
+		result, err = resizeWith(*tensor, len(r.xWeights), len(r.yWeights), r.method, false)
+	case r.method == ResampleArea || r.method == ResampleBicubic:
+		result, err = resizeSeparable(*tensor, r.xWeights, r.yWeights)
+		if err == nil && r.method == ResampleBicubic {
+			clampTensor(result)
+		}
+	default:
+		result, err = resizeDirect(*tensor, r.xWeights, r.yWeights)
+	}
+	if err != nil {
+		return err
+	}
+	*tensor = result
+	return nil
+}
+
+// areaCostPerPixel is a conservative estimate of the time resizeArea spends
+// per source pixel, used by FastThumbnail to stay within its budget.
+const areaCostPerPixel = 20 * time.Nanosecond
+
+// FastThumbnail downscales an image to fit within a size x size square,
+// choosing the resampling method for the caller.
+//
+// Small reductions, under a factor of 2, are resampled bilinearly. Larger ones
+// average every source pixel with area resampling, which avoids the aliasing
+// bilinear resampling shows there. When averaging the whole image is estimated
+// to take longer than budget, the image is first reduced bilinearly to the
+// largest size that fits the budget, but never below twice the thumbnail size,
+// and then averaged down from there.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//	size: The longest side of the thumbnail, in pixels.
+//	budget: The time the resize should take at most, or 0 for no limit. It is
+//	  a target based on an estimate, not a hard deadline.
+//
+// Returns:
+//
+//	The thumbnail, which keeps the aspect ratio of the image. Images already
+//	within size, and sizes below 1, return a copy of the image. An error is
+//	returned if a worker panics.
+func FastThumbnail(tensor [][][]float64, size int, budget time.Duration) ([][][]float64, error) {
+	width, height, _ := Dimensions(tensor)
+	if size < 1 || width == 0 || height == 0 {
+		return cloneTensor(tensor), nil
+	}
+	longest := max(width, height)
+	if longest <= size {
+		return cloneTensor(tensor), nil
+	}
+
+	// fit returns the dimensions of the image scaled to a longest side of n.
+	fit := func(n int) (int, int) {
+		scale := float64(n) / float64(longest)
+		return max(1, int(math.Round(float64(width)*scale))), max(1, int(math.Round(float64(height)*scale)))
+	}
+	thumbWidth, thumbHeight := fit(size)
+
+	if longest < 2*size {
+		return resample(tensor, thumbWidth, thumbHeight, false)
+	}
+
+	if budget > 0 && time.Duration(width*height)*areaCostPerPixel > budget {
+		// Scale the source area down to what can be averaged in time.
+		affordable := float64(budget/areaCostPerPixel) / float64(width*height)
+		intermediate := max(2*size, int(float64(longest)*math.Sqrt(affordable)))
+		if intermediate < longest {
+			w, h := fit(intermediate)
+			var err error
+			if tensor, err = resample(tensor, w, h, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resizeArea(tensor, thumbWidth, thumbHeight)
+}
@@ -0,0 +1,572 @@
+package imagetor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGenerateResponsiveSet(t *testing.T) {
+	source := newTensor(800, 400)
+	for _, row := range source {
+		for _, p := range row {
+			p[0], p[1], p[2], p[3] = 0.2, 0.4, 0.6, 1
+		}
+	}
+
+	tests := []struct {
+		name    string
+		widths  []int
+		want    map[int][2]int
+		wantErr bool
+	}{
+		{"srcset", []int{400, 200, 100}, map[int][2]int{400: {400, 200}, 200: {200, 100}, 100: {100, 50}}, false},
+		{"unsorted with duplicates", []int{100, 800, 100}, map[int][2]int{800: {800, 400}, 100: {100, 50}}, false},
+		{"odd width", []int{333}, map[int][2]int{333: {333, 167}}, false},
+		{"wider than the image", []int{1600}, nil, true},
+		{"zero width", []int{0}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := GenerateResponsiveSet(source, tt.widths)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateResponsiveSet error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(set) != len(tt.want) {
+				t.Fatalf("got %d tensors, want %d", len(set), len(tt.want))
+			}
+			for w, dims := range tt.want {
+				width, height, _ := Dimensions(set[w])
+				if width != dims[0] || height != dims[1] {
+					t.Errorf("width %d: got %dx%d, want %dx%d", w, width, height, dims[0], dims[1])
+					continue
+				}
+				if p := set[w][height/2][width/2]; !near(p[0], 0.2, 1e-9) || !near(p[2], 0.6, 1e-9) || !near(p[3], 1, 1e-9) {
+					t.Errorf("width %d: center pixel = %v, want the source color", w, p)
+				}
+			}
+		})
+	}
+}
+
+func TestDownscaleInt(t *testing.T) {
+	// Pixel (x, y) of a 6x6 image has red x/10, green y/10, blue (x+y)/20
+	// and alpha 1.
+	source := newTensor(6, 6)
+	for y, row := range source {
+		for x, p := range row {
+			p[0], p[1], p[2], p[3] = float64(x)/10, float64(y)/10, float64(x+y)/20, 1
+		}
+	}
+
+	tests := []struct {
+		name    string
+		factor  int
+		want    [][][]float64
+		wantErr bool
+	}{
+		{"factor 3", 3, [][][]float64{
+			{{0.1, 0.1, 0.1, 1}, {0.4, 0.1, 0.25, 1}},
+			{{0.1, 0.4, 0.25, 1}, {0.4, 0.4, 0.4, 1}},
+		}, false},
+		{"factor 1", 1, source, false},
+		{"factor 6", 6, [][][]float64{{{0.25, 0.25, 0.25, 1}}}, false},
+		{"not a divisor", 4, nil, true},
+		{"zero", 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DownscaleInt(source, tt.factor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownscaleInt error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !pixelsNear(got, tt.want, 1e-9) {
+				t.Errorf("DownscaleInt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// gradientTensor returns an opaque tensor whose red channel ramps left to
+// right and green channel top to bottom, which any resize must interpolate.
+func gradientTensor(width, height int) [][][]float64 {
+	tensor := newTensor(width, height)
+	for y, row := range tensor {
+		for x, p := range row {
+			p[0] = float64(x) / float64(max(1, width-1))
+			p[1] = float64(y) / float64(max(1, height-1))
+			p[2], p[3] = 0.5, 1
+		}
+	}
+	return tensor
+}
+
+func TestResampleSharedPath(t *testing.T) {
+	source := gradientTensor(13, 9)
+
+	tests := []struct {
+		name          string
+		width, height int
+	}{
+		{"downscale", 5, 4},
+		{"upscale", 29, 17},
+		{"stretch", 26, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := resample(source, tt.width, tt.height, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			public := cloneTensor(source)
+			if err := Resize(&public, tt.width, tt.height); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(public, want, 0) {
+				t.Error("Resize differs from resample")
+			}
+
+			overlay, err := resizeWith(source, tt.width, tt.height, ResampleBilinear, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(overlay, want, 0) {
+				t.Error("overlay resize differs from resample")
+			}
+
+			// Every output pixel is opaque and the red and green ramps still
+			// rise into the last column and row, so no edge was skipped.
+			for y, row := range want {
+				for x, p := range row {
+					if !near(p[3], 1, 1e-9) {
+						t.Fatalf("pixel (%d, %d) has alpha %v", x, y, p[3])
+					}
+				}
+			}
+			last := want[tt.height-1][tt.width-1]
+			if last[0] < want[0][0][0] || last[1] < want[0][0][1] || last[0] < 0.75 {
+				t.Errorf("bottom-right pixel = %v, top-left pixel %v", last, want[0][0])
+			}
+		})
+	}
+}
+
+func TestResamplePremultiply(t *testing.T) {
+	// Straight alpha: opaque white on the left, fully transparent red on the
+	// right, whose hidden color must not bleed into the result.
+	source := newTensor(4, 2)
+	for _, row := range source {
+		for x, p := range row {
+			if x < 2 {
+				p[0], p[1], p[2], p[3] = 1, 1, 1, 1
+			} else {
+				p[0] = 1
+			}
+		}
+	}
+
+	tests := []struct {
+		name        string
+		premultiply bool
+		wantBleed   bool
+	}{
+		{"straight", false, true},
+		{"premultiplied", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, resize := range []func() ([][][]float64, error){
+				func() ([][][]float64, error) { return resample(source, 7, 2, tt.premultiply) },
+				func() ([][][]float64, error) {
+					return resizeWith(source, 7, 2, ResampleBilinear, tt.premultiply)
+				},
+			} {
+				got, err := resize()
+				if err != nil {
+					t.Fatal(err)
+				}
+				bleed := false
+				for _, p := range got[0] {
+					if p[3] > 0 && (p[1] < 1-1e-9 || p[2] < 1-1e-9) {
+						bleed = true
+					}
+				}
+				if bleed != tt.wantBleed {
+					t.Errorf("red bleed = %v, want %v: %v", bleed, tt.wantBleed, got[0])
+				}
+			}
+		})
+	}
+}
+
+// colorSet returns the distinct colors of a tensor.
+func colorSet(tensor [][][]float64) map[[4]float64]bool {
+	set := map[[4]float64]bool{}
+	for _, row := range tensor {
+		for _, p := range row {
+			set[[4]float64{p[0], p[1], p[2], p[3]}] = true
+		}
+	}
+	return set
+}
+
+func TestUpscale2x(t *testing.T) {
+	// A black diagonal line on a white sprite.
+	sprite := solidTensor(4, 4, [4]float64{1, 1, 1, 1})
+	for i := 0; i < 4; i++ {
+		copy(sprite[i][i], []float64{0, 0, 0, 1})
+	}
+	palette := colorSet(sprite)
+
+	doubled, err := Upscale2x(sprite, UpscalePixelDouble)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bilinear, err := resample(sprite, 8, 8, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		algorithm   UpscaleAlgo
+		wantDoubled bool
+	}{
+		{"pixel double", UpscalePixelDouble, true},
+		{"scale2x", UpscaleScale2x, false},
+		{"unknown algorithm", UpscaleAlgo(99), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Upscale2x(sprite, tt.algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(got); w != 8 || h != 8 {
+				t.Fatalf("upscaled sprite is %dx%d, want 8x8", w, h)
+			}
+			for y, row := range got {
+				for x, p := range row {
+					if !samePixel(p, sprite[y/2][x/2]) && tt.wantDoubled {
+						t.Fatalf("pixel (%d, %d) = %v, want a copy of source pixel (%d, %d)", x, y, p, x/2, y/2)
+					}
+				}
+			}
+			for color := range colorSet(got) {
+				if !palette[color] {
+					t.Errorf("upscaling introduced color %v", color)
+				}
+			}
+			// Scale2x fills in the steps beside the diagonal.
+			if smoothed := !pixelsNear(got, doubled, 0); smoothed == tt.wantDoubled {
+				t.Errorf("result differs from pixel doubling: %v, want %v", smoothed, !tt.wantDoubled)
+			}
+		})
+	}
+
+	newColors := 0
+	for color := range colorSet(bilinear) {
+		if !palette[color] {
+			newColors++
+		}
+	}
+	if newColors == 0 {
+		t.Error("bilinear resize introduced no new colors")
+	}
+}
+
+func TestResizer(t *testing.T) {
+	source := gradientTensor(37, 23)
+	methods := []struct {
+		name   string
+		method ResizeMethod
+	}{
+		{"bilinear", ResampleBilinear},
+		{"area", ResampleArea},
+		{"nearest", ResampleNearest},
+		{"bicubic", ResampleBicubic},
+	}
+	sizes := []struct {
+		name          string
+		width, height int
+	}{
+		{"down", 12, 9},
+		{"up", 50, 31},
+	}
+	for _, m := range methods {
+		for _, size := range sizes {
+			t.Run(m.name+" "+size.name, func(t *testing.T) {
+				want, err := resizeWith(source, size.width, size.height, m.method, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := NewResizer(37, 23, size.width, size.height, m.method)
+				// Resizing twice checks that the Resizer is reusable.
+				for i := 0; i < 2; i++ {
+					got := cloneTensor(source)
+					if err := r.Resize(&got); err != nil {
+						t.Fatal(err)
+					}
+					if !pixelsNear(got, want, 1e-9) {
+						t.Fatalf("Resizer output differs from the %s resize", m.name)
+					}
+				}
+
+				// Other source sizes still reach the destination size.
+				other := gradientTensor(20, 20)
+				if err := r.Resize(&other); err != nil {
+					t.Fatal(err)
+				}
+				if w, h, _ := Dimensions(other); w != size.width || h != size.height {
+					t.Errorf("20x20 image resized to %dx%d, want %dx%d", w, h, size.width, size.height)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkResizer resizes 100 frames of the same size with a shared Resizer
+// and with one resize call per frame.
+func BenchmarkResizer(b *testing.B) {
+	const frames = 100
+	source := stripesTensor(320, 240)
+	for _, m := range []struct {
+		name   string
+		method ResizeMethod
+	}{
+		{"bilinear", ResampleBilinear},
+		{"bicubic", ResampleBicubic},
+		{"area", ResampleArea},
+	} {
+		b.Run(m.name+"/per call", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for f := 0; f < frames; f++ {
+					if _, err := resizeWith(source, 200, 150, m.method, false); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(m.name+"/resizer", func(b *testing.B) {
+			r := NewResizer(320, 240, 200, 150, m.method)
+			for i := 0; i < b.N; i++ {
+				for f := 0; f < frames; f++ {
+					// Resize replaces the tensor, leaving source intact.
+					tensor := source
+					if err := r.Resize(&tensor); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFastThumbnail(t *testing.T) {
+	// A one-pixel checkerboard, whose average is mid-gray and which aliases
+	// badly when sampled instead of averaged.
+	checker := newTensor(300, 200)
+	for y, row := range checker {
+		for x, p := range row {
+			v := float64((x + y) % 2)
+			p[0], p[1], p[2], p[3] = v, v, v, 1
+		}
+	}
+
+	tests := []struct {
+		name                  string
+		tensor                [][][]float64
+		size                  int
+		budget                time.Duration
+		wantWidth, wantHeight int
+		// averaged reports whether the result must be close to flat gray.
+		averaged bool
+	}{
+		{"large reduction", checker, 32, 0, 32, 21, true},
+		{"large reduction within budget", checker, 32, time.Second, 32, 21, true},
+		{"large reduction over budget", checker, 32, time.Microsecond, 32, 21, false},
+		{"small reduction", gradientTensor(120, 60), 80, 0, 80, 40, false},
+		{"portrait", gradientTensor(50, 200), 20, 0, 5, 20, false},
+		{"already small", gradientTensor(30, 20), 64, 0, 30, 20, false},
+		{"size zero", gradientTensor(30, 20), 0, 0, 30, 20, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumb, err := FastThumbnail(tt.tensor, tt.size, tt.budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(thumb); w != tt.wantWidth || h != tt.wantHeight {
+				t.Fatalf("thumbnail is %dx%d, want %dx%d", w, h, tt.wantWidth, tt.wantHeight)
+			}
+			if !tt.averaged {
+				return
+			}
+			for y, row := range thumb {
+				for x, p := range row {
+					if !near(p[0], 0.5, 0.05) {
+						t.Fatalf("pixel (%d, %d) = %v, want about 0.5", x, y, p[0])
+					}
+				}
+			}
+		})
+	}
+
+	// Sampling the checkerboard bilinearly instead leaves strong aliasing,
+	// which is what averaging avoids.
+	sampled, err := resample(checker, 32, 21, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliased := false
+	for _, row := range sampled {
+		for _, p := range row {
+			aliased = aliased || !near(p[0], 0.5, 0.05)
+		}
+	}
+	if !aliased {
+		t.Error("bilinear sampling of the checkerboard did not alias")
+	}
+}
+
+// checkerboard returns an opaque black and white checkerboard of squares of
+// the given size, white in the top left corner.
+func checkerboard(width, height, square int) [][][]float64 {
+	tensor := newTensor(width, height)
+	for y, row := range tensor {
+		for x, p := range row {
+			v := float64(1 - (x/square+y/square)%2)
+			p[0], p[1], p[2], p[3] = v, v, v, 1
+		}
+	}
+	return tensor
+}
+
+func TestResizeNearest(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+		// want is the expected result, or nil to only check that no new
+		// colors appear.
+		want [][][]float64
+	}{
+		{"2x2 upscaled 4x", 8, 8, 8, 8, checkerboard(8, 8, 4)},
+		{"2x2 upscaled 2x", 4, 4, 4, 4, checkerboard(4, 4, 2)},
+		{"uneven factors", 7, 5, 7, 5, nil},
+		{"width derived", 6, 0, 6, 6, checkerboard(6, 6, 3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := checkerboard(2, 2, 1)
+			if err := ResizeNearest(&tensor, tt.width, tt.height); err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(tensor); w != tt.wantWidth || h != tt.wantHeight {
+				t.Fatalf("resized to %dx%d, want %dx%d", w, h, tt.wantWidth, tt.wantHeight)
+			}
+			if colors := colorSet(tensor); len(colors) != 2 {
+				t.Errorf("resized image has %d colors, want only black and white", len(colors))
+			}
+			if tt.want != nil && !pixelsNear(tensor, tt.want, 0) {
+				t.Error("resized image is not the scaled checkerboard")
+			}
+		})
+	}
+}
+
+// stripedRamp returns an opaque gray ramp from 0.2 to 0.8 along x, overlaid
+// with single-pixel stripes of +-0.1 that alias when undersampled.
+func stripedRamp(width, height int) [][][]float64 {
+	tensor := newTensor(width, height)
+	for _, row := range tensor {
+		for x, p := range row {
+			v := 0.2 + 0.6*float64(x)/float64(width-1) + 0.1*float64(1-2*(x%2))
+			p[0], p[1], p[2], p[3] = v, v, v, 1
+		}
+	}
+	return tensor
+}
+
+// rampError returns the mean absolute difference between the red channel of
+// a resized stripedRamp of the given source width and the ramp without its
+// stripes, away from the left and right edges.
+func rampError(tensor [][][]float64, srcWidth int) float64 {
+	sum, n := 0.0, 0
+	for _, row := range tensor {
+		scale := float64(srcWidth) / float64(len(row))
+		for x := 2; x < len(row)-2; x++ {
+			center := (float64(x)+0.5)*scale - 0.5
+			sum += math.Abs(row[x][0] - (0.2 + 0.6*center/float64(srcWidth-1)))
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+func TestResizeBicubic(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{"large reduction", 77, 4, 77, 4},
+		{"odd ratio", 97, 0, 97, 5},
+		{"upscale", 1200, 60, 1200, 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := stripedRamp(600, 30)
+			bicubic, bilinear := cloneTensor(source), cloneTensor(source)
+			if err := ResizeBicubic(&bicubic, tt.width, tt.height); err != nil {
+				t.Fatal(err)
+			}
+			if err := Resize(&bilinear, tt.wantWidth, tt.wantHeight); err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(bicubic); w != tt.wantWidth || h != tt.wantHeight {
+				t.Fatalf("resized to %dx%d, want %dx%d", w, h, tt.wantWidth, tt.wantHeight)
+			}
+			for _, row := range bicubic {
+				for _, p := range row {
+					if p[0] < 0 || p[0] > 1 || !near(p[3], 1, 1e-9) {
+						t.Fatalf("pixel %v is out of range", p)
+					}
+				}
+			}
+			if tt.wantWidth < 600 {
+				if got, ref := rampError(bicubic, 600), rampError(bilinear, 600); got >= ref/4 {
+					t.Errorf("bicubic strays %v from the ramp, want well below the bilinear %v", got, ref)
+				}
+			}
+		})
+	}
+}
+
+func TestResizeArea(t *testing.T) {
+	tests := []struct {
+		name          string
+		source        [][][]float64
+		width, height int
+		want          [4]float64
+		tol           float64
+	}{
+		{"single pixel squares by 8", checkerboard(64, 64, 1), 8, 8, [4]float64{0.5, 0.5, 0.5, 1}, 1e-12},
+		{"single pixel squares by 2", checkerboard(64, 64, 1), 32, 32, [4]float64{0.5, 0.5, 0.5, 1}, 1e-12},
+		{"4 pixel squares by 8", checkerboard(64, 64, 4), 8, 8, [4]float64{0.5, 0.5, 0.5, 1}, 1e-12},
+		{"uneven ratio", checkerboard(64, 48, 1), 10, 7, [4]float64{0.5, 0.5, 0.5, 1}, 0.1},
+		{"color stripes", stripesTensor(16, 48), 4, 2, [4]float64{1.0 / 3, 1.0 / 3, 1.0 / 3, 1}, 1e-12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := cloneTensor(tt.source)
+			if err := ResizeArea(&tensor, tt.width, tt.height); err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(tensor, solidTensor(tt.width, tt.height, tt.want), tt.tol) {
+				t.Errorf("downscaled pattern is not an even %v", tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package imagetor
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// encodeImage encodes an image to a writer in the given format: "jpeg" (or
+// "jpg"), "png", "bmp" or "tiff" (or "tif"). The quality only applies to JPEG.
+func encodeImage(w io.Writer, img image.Image, format string, quality int) error {
+	switch strings.ToLower(format) {
+	case "jpeg", "jpg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "png":
+		return png.Encode(w, img)
+	case "bmp":
+		return bmp.Encode(w, img)
+	case "tiff", "tif":
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// SaveImage encodes an image to a file, choosing the format by the extension
+// of the path.
+//
+// ".png" files are written as PNG, which keeps the alpha channel intact, for
+// example around an overlay on a transparent canvas. ".jpg" and ".jpeg" files
+// are written as JPEG at quality 100, which flattens any transparency. ".bmp"
+// files are written as BMP and ".tif" and ".tiff" files as Deflate-compressed
+// TIFF.
+//
+// Args:
+//
+//	img: The image to save.
+//	path: The path of the file to create, replacing any existing file.
+//
+// Returns:
+//
+//	An error if the extension is not supported or encoding or writing fails.
+func SaveImage(img image.Image, path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".png", ".jpg", ".jpeg", ".bmp", ".tif", ".tiff":
+	default:
+		return fmt.Errorf("unsupported file extension %q", ext)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if err := encodeImage(writer, img, ext[1:], 100); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
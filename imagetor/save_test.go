@@ -0,0 +1,87 @@
+package imagetor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// alphaRamp returns a red tensor whose alpha ramps from transparent on the
+// left to opaque on the right. Its colors are premultiplied.
+func alphaRamp(width, height int) [][][]float64 {
+	tensor := newTensor(width, height)
+	for _, row := range tensor {
+		for x, p := range row {
+			a := float64(x) / float64(width-1)
+			p[0], p[3] = a, a
+		}
+	}
+	return tensor
+}
+
+func TestSaveImage(t *testing.T) {
+	source := alphaRamp(16, 4)
+	tests := []struct {
+		name      string
+		file      string
+		keepAlpha bool
+		tol       float64
+	}{
+		{"png", "out.png", true, 1.0 / 255},
+		{"upper case extension", "OUT.PNG", true, 1.0 / 255},
+		{"tiff", "out.tiff", true, 1.0 / 255},
+		{"jpeg", "out.jpg", false, 0.05},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := TensorToImage(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := SaveImage(img, path); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			got, err := DecodeTensor(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range got {
+				for x, p := range row {
+					wantAlpha := 1.0
+					if tt.keepAlpha {
+						wantAlpha = source[y][x][3]
+					}
+					if !near(p[3], wantAlpha, tt.tol) {
+						t.Fatalf("alpha at (%d, %d) = %v, want %v", x, y, p[3], wantAlpha)
+					}
+					if !near(p[0], source[y][x][0], tt.tol) {
+						t.Fatalf("red at (%d, %d) = %v, want %v", x, y, p[0], source[y][x][0])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSaveImageUnsupported(t *testing.T) {
+	img, err := TensorToImage(alphaRamp(4, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"out.gif", "out"} {
+		path := filepath.Join(t.TempDir(), file)
+		if err := SaveImage(img, path); err == nil {
+			t.Errorf("saving to %q did not return an error", file)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("saving to %q created a file", file)
+		}
+	}
+}
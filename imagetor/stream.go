@@ -0,0 +1,38 @@
+package imagetor
+
+import (
+	"image/jpeg"
+	"io"
+)
+
+// StreamTransform decodes an image from a reader, applies an operation to it
+// and encodes the result to a writer, so that command line tools can process
+// images in a pipe:
+//
+//	cat in.png | tool | cat > out.png
+//
+// EXIF and ICC metadata of the input are carried over to the output. JPEG
+// output is encoded at the default quality of image/jpeg.
+//
+// Args:
+//
+//	r: The reader to decode the image from.
+//	w: The writer to write the encoded result to.
+//	format: The output format, "jpeg" (or "jpg") or "png".
+//	op: The operation to apply, or nil to re-encode the image unchanged.
+//
+// Returns:
+//
+//	An error if decoding, the operation or encoding fails.
+func StreamTransform(r io.Reader, w io.Writer, format string, op Op) error {
+	tensor, meta, err := DecodeTensorWithMetadata(r)
+	if err != nil {
+		return err
+	}
+	if op != nil {
+		if err := op(&tensor); err != nil {
+			return err
+		}
+	}
+	return EncodeTensorWithMetadata(w, tensor, format, jpeg.DefaultQuality, meta)
+}
@@ -0,0 +1,90 @@
+package imagetor
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// encodeTestPNG encodes a tensor as a PNG.
+func encodeTestPNG(t *testing.T, tensor [][][]float64) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := EncodeTensor(&buf, tensor, "png", 0); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStreamTransform(t *testing.T) {
+	input := encodeTestPNG(t, gradientTensor(24, 16))
+	want, err := DecodeTensor(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := func(*[][][]float64) error { return nil }
+
+	tests := []struct {
+		name   string
+		format string
+		op     Op
+		// exact reports whether the output must hold the input pixels.
+		exact bool
+	}{
+		{"identity to png", "png", identity, true},
+		{"nil op to png", "png", nil, true},
+		{"identity to jpeg", "jpeg", identity, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := StreamTransform(bytes.NewReader(input), &out, tt.format, tt.op); err != nil {
+				t.Fatal(err)
+			}
+			if format := detectFormat(out.Bytes()); format != tt.format {
+				t.Fatalf("output format is %q, want %q", format, tt.format)
+			}
+			got, err := DecodeTensor(&out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h, _ := Dimensions(got); w != 24 || h != 16 {
+				t.Fatalf("output is %dx%d, want 24x16", w, h)
+			}
+			tol := 0.1
+			if tt.exact {
+				tol = 1e-12
+			}
+			if !pixelsNear(got, want, tol) {
+				t.Error("output pixels differ from the input")
+			}
+		})
+	}
+}
+
+func TestStreamTransformErrors(t *testing.T) {
+	errOp := errors.New("op failed")
+	input := encodeTestPNG(t, gradientTensor(4, 4))
+
+	tests := []struct {
+		name   string
+		input  []byte
+		format string
+		op     Op
+	}{
+		{"undecodable input", []byte("not an image"), "png", nil},
+		{"op fails", input, "png", func(*[][][]float64) error { return errOp }},
+		{"unknown format", input, "gif", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := StreamTransform(bytes.NewReader(tt.input), &out, tt.format, tt.op); err == nil {
+				t.Error("StreamTransform did not return an error")
+			}
+			if out.Len() != 0 {
+				t.Errorf("%d bytes were written despite the error", out.Len())
+			}
+		})
+	}
+}
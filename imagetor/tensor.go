@@ -0,0 +1,213 @@
+package imagetor
+
+import (
+	"context"
+	"fmt"
+)
+
+// Tensor is an image stored as a single contiguous slice of float64 values,
+// row by row and pixel by pixel, with the channels of each pixel adjacent.
+//
+// The nested [][][]float64 form used by most of the package costs three
+// pointer dereferences per value and scatters the pixels across the heap.
+// Tensor keeps them together, which is considerably faster for large images.
+// FromNested and Nested convert between the two forms.
+type Tensor struct {
+	// Data holds the values, with the value of channel c of the pixel at
+	// (x, y) at index (y*Width+x)*Channels+c.
+	Data []float64
+	// Width is the number of pixels in a row.
+	Width int
+	// Height is the number of rows.
+	Height int
+	// Channels is the number of values per pixel, 4 for RGBA.
+	Channels int
+}
+
+// NewTensor allocates a zeroed RGBA tensor of the given dimensions.
+//
+// Args:
+//
+//	width: The width of the tensor.
+//	height: The height of the tensor.
+//
+// Returns:
+//
+//	The new tensor.
+func NewTensor(width, height int) Tensor {
+	return Tensor{
+		Data:     make([]float64, width*height*channels),
+		Width:    width,
+		Height:   height,
+		Channels: channels,
+	}
+}
+
+// FromNested copies a tensor in the nested [][][]float64 form into a Tensor.
+//
+// Args:
+//
+//	tensor: The 3D tensor representing the image.
+//
+// Returns:
+//
+//	The flat copy, or an error if the tensor is empty or ragged.
+func FromNested(tensor [][][]float64) (Tensor, error) {
+	height, width, err := dimsOf(tensor)
+	if err != nil {
+		return Tensor{}, err
+	}
+
+	t := NewTensor(width, height)
+	if err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x, pixel := range tensor[y] {
+				copy(t.Pixel(x, y), pixel[:channels])
+			}
+		}
+	}); err != nil {
+		return Tensor{}, err
+	}
+	return t, nil
+}
+
+// Nested returns the tensor in the nested [][][]float64 form used by the rest
+// of the package.
+//
+// No values are copied: the pixels of the returned tensor are slices of t.Data,
+// so changes made through either form are visible in the other.
+//
+// Returns:
+//
+//	The nested tensor.
+func (t Tensor) Nested() [][][]float64 {
+	tensor := make([][][]float64, t.Height)
+	pixels := make([][]float64, t.Width*t.Height)
+	for y := range tensor {
+		tensor[y] = pixels[y*t.Width : (y+1)*t.Width : (y+1)*t.Width]
+		for x := range tensor[y] {
+			tensor[y][x] = t.Pixel(x, y)
+		}
+	}
+	return tensor
+}
+
+// Pixel returns the channels of the pixel at (x, y) as a slice of t.Data.
+//
+// Args:
+//
+//	x: The column of the pixel.
+//	y: The row of the pixel.
+//
+// Returns:
+//
+//	The channel values of the pixel, which can be modified in place.
+func (t Tensor) Pixel(x, y int) []float64 {
+	i := (y*t.Width + x) * t.Channels
+	return t.Data[i : i+t.Channels : i+t.Channels]
+}
+
+// At returns the value of channel c of the pixel at (x, y).
+//
+// Args:
+//
+//	x: The column of the pixel.
+//	y: The row of the pixel.
+//	c: The channel.
+//
+// Returns:
+//
+//	The channel value.
+func (t Tensor) At(x, y, c int) float64 {
+	return t.Data[(y*t.Width+x)*t.Channels+c]
+}
+
+// Set sets the value of channel c of the pixel at (x, y).
+//
+// Args:
+//
+//	x: The column of the pixel.
+//	y: The row of the pixel.
+//	c: The channel.
+//	v: The new value.
+func (t Tensor) Set(x, y, c int, v float64) {
+	t.Data[(y*t.Width+x)*t.Channels+c] = v
+}
+
+// Resize resizes the tensor using bilinear interpolation, exactly as the
+// package-level Resize does for nested tensors.
+//
+// Args:
+//
+//	width: The desired width of the resized tensor.
+//	height: The desired height of the resized tensor.
+//
+// Returns:
+//
+//	An error if the tensor is empty or a dimension is less than 1, in which
+//	case the tensor is left unchanged.
+func (t *Tensor) Resize(width, height int) error {
+	return t.resize(context.Background(), width, height)
+}
+
+// resize is Resize, abandoning the work and returning ctx.Err() if ctx is done
+// before it completes.
+func (t *Tensor) resize(ctx context.Context, width, height int) error {
+	if t.Width < 1 || t.Height < 1 {
+		return fmt.Errorf("tensor is empty")
+	}
+	if width < 1 || height < 1 {
+		return fmt.Errorf("invalid size %dx%d", width, height)
+	}
+
+	src, n := t.Data, t.Channels
+	oldWidth, oldHeight := t.Width, t.Height
+	data := make([]float64, width*height*n)
+
+	err := parallelRowsCtx(ctx, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			oldY := float64(y) * float64(oldHeight) / float64(height)
+			y0 := int(oldY)
+			y1 := min(y0+1, oldHeight-1)
+			dy := oldY - float64(y0)
+			row0, row1 := y0*oldWidth*n, y1*oldWidth*n
+
+			for x := 0; x < width; x++ {
+				oldX := float64(x) * float64(oldWidth) / float64(width)
+				x0 := int(oldX)
+				x1 := min(x0+1, oldWidth-1)
+				dx := oldX - float64(x0)
+
+				out := data[(y*width+x)*n:]
+				for c := 0; c < n; c++ {
+					out[c] = (1-dx)*(1-dy)*src[row0+x0*n+c] + dx*(1-dy)*src[row0+x1*n+c] + (1-dx)*dy*src[row1+x0*n+c] + dx*dy*src[row1+x1*n+c]
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	t.Data, t.Width, t.Height = data, width, height
+	return nil
+}
+
+// GrayScale converts the tensor to grayscale in place, exactly as the
+// package-level GrayScale does for nested tensors.
+//
+// Returns:
+//
+//	An error if a worker panics.
+func (t *Tensor) GrayScale() error {
+	n := t.Channels
+	if n < 3 {
+		return nil
+	}
+	return parallelRows(t.Height, func(start, end int) {
+		for i := start * t.Width * n; i < end*t.Width*n; i += n {
+			gray := luminance(t.Data[i], t.Data[i+1], t.Data[i+2])
+			t.Data[i], t.Data[i+1], t.Data[i+2] = gray, gray, gray
+		}
+	})
+}
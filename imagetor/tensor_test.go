@@ -0,0 +1,184 @@
+package imagetor
+
+import "testing"
+
+// resizeNested is the bilinear interpolation of Tensor.Resize written against
+// the nested layout, as Resize was before it moved to the flat one.
+func resizeNested(tensor [][][]float64, width, height int) ([][][]float64, error) {
+	oldWidth, oldHeight, _ := Dimensions(tensor)
+	result := newTensor(width, height)
+	err := parallelRows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			oldY := float64(y) * float64(oldHeight) / float64(height)
+			y0 := int(oldY)
+			y1 := min(y0+1, oldHeight-1)
+			dy := oldY - float64(y0)
+			for x := 0; x < width; x++ {
+				oldX := float64(x) * float64(oldWidth) / float64(width)
+				x0 := int(oldX)
+				x1 := min(x0+1, oldWidth-1)
+				dx := oldX - float64(x0)
+				for c := 0; c < channels; c++ {
+					result[y][x][c] = (1-dx)*(1-dy)*tensor[y0][x0][c] + dx*(1-dy)*tensor[y0][x1][c] +
+						(1-dx)*dy*tensor[y1][x0][c] + dx*dy*tensor[y1][x1][c]
+				}
+			}
+		}
+	})
+	return result, err
+}
+
+func TestTensorNested(t *testing.T) {
+	source := gradientTensor(7, 5)
+	flat, err := FromNested(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flat.Width != 7 || flat.Height != 5 || flat.Channels != channels || len(flat.Data) != 7*5*channels {
+		t.Fatalf("FromNested = %dx%dx%d with %d values", flat.Width, flat.Height, flat.Channels, len(flat.Data))
+	}
+	for y, row := range source {
+		for x, p := range row {
+			for c, v := range p {
+				if got := flat.At(x, y, c); got != v {
+					t.Fatalf("At(%d, %d, %d) = %v, want %v", x, y, c, got, v)
+				}
+			}
+		}
+	}
+
+	nested := flat.Nested()
+	if !pixelsNear(nested, source, 0) {
+		t.Fatal("Nested differs from the source")
+	}
+	flat.Set(2, 3, 1, 0.25)
+	if nested[3][2][1] != 0.25 {
+		t.Error("Nested does not share the values of the tensor")
+	}
+	nested[4][6][0] = 0.75
+	if flat.Pixel(6, 4)[0] != 0.75 {
+		t.Error("Pixel does not share the values of the tensor")
+	}
+}
+
+func TestFromNestedErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor [][][]float64
+	}{
+		{"empty", nil},
+		{"ragged", [][][]float64{{{0, 0, 0, 1}, {0, 0, 0, 1}}, {{0, 0, 0, 1}}}},
+		{"too few channels", [][][]float64{{{0, 0, 0}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromNested(tt.tensor); err == nil {
+				t.Error("FromNested did not return an error")
+			}
+		})
+	}
+}
+
+func TestTensorResize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+	}{
+		{"upscale", 40, 30},
+		{"downscale", 9, 7},
+		{"stretch", 50, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := gradientTensor(20, 15)
+			flat, err := FromNested(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := flat.Resize(tt.width, tt.height); err != nil {
+				t.Fatal(err)
+			}
+			if flat.Width != tt.width || flat.Height != tt.height {
+				t.Fatalf("resized to %dx%d, want %dx%d", flat.Width, flat.Height, tt.width, tt.height)
+			}
+			want, err := resizeNested(source, tt.width, tt.height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pixelsNear(flat.Nested(), want, 1e-12) {
+				t.Error("flat resize differs from the nested reference")
+			}
+		})
+	}
+
+	t.Run("invalid size", func(t *testing.T) {
+		flat := NewTensor(4, 4)
+		if err := flat.Resize(0, 4); err == nil {
+			t.Error("Resize to a width of 0 did not return an error")
+		}
+		if flat.Width != 4 || flat.Height != 4 {
+			t.Errorf("failed resize changed the tensor to %dx%d", flat.Width, flat.Height)
+		}
+	})
+}
+
+func TestTensorGrayScale(t *testing.T) {
+	source := gradientTensor(13, 11)
+	want := cloneTensor(source)
+	if err := GrayScale(&want); err != nil {
+		t.Fatal(err)
+	}
+	flat, err := FromNested(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flat.GrayScale(); err != nil {
+		t.Fatal(err)
+	}
+	if !pixelsNear(flat.Nested(), want, 1e-12) {
+		t.Error("flat grayscale differs from GrayScale")
+	}
+}
+
+// BenchmarkFlatLayout compares Resize and GrayScale in the nested layout with
+// the same operations on a Tensor.
+func BenchmarkFlatLayout(b *testing.B) {
+	source := stripesTensor(2000, 1500)
+	flatSource, err := FromNested(source)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Resize/nested", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := resizeNested(source, 1500, 1000); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Resize/flat", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			flat := flatSource
+			if err := flat.Resize(1500, 1000); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GrayScale/nested", func(b *testing.B) {
+		tensor := cloneTensor(source)
+		for i := 0; i < b.N; i++ {
+			if err := GrayScale(&tensor); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GrayScale/flat", func(b *testing.B) {
+		flat := NewTensor(flatSource.Width, flatSource.Height)
+		copy(flat.Data, flatSource.Data)
+		for i := 0; i < b.N; i++ {
+			if err := flat.GrayScale(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}